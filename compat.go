@@ -0,0 +1,267 @@
+package goloader
+
+import (
+	"cmd/objfile/objabi"
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+const (
+	ExportRemoved          = "export removed"
+	ExportAdded            = "export added"
+	ExportKindChanged      = "changed between func and var"
+	ExportFuncChanged      = "function signature changed"
+	ExportVarChanged       = "variable type changed"
+	ExportTypeRemoved      = "type removed"
+	ExportTypeAdded        = "type added"
+	ExportTypeChanged      = "type layout changed"
+	ExportPackageRemoved   = "package removed"
+	ExportPackageAdded     = "package added"
+	ExportMethodSetChanged = "type method set changed"
+)
+
+type ExportChange struct {
+	PkgPath     string
+	Name        string // Exported identifier within PkgPath, e.g. "Handler", "(*Server).Serve", or empty for package level changes
+	Change      string // One of the Export* constants
+	Breaking    bool
+	Differences []TypeDifference // For changed funcs, vars and types, the divergences between the old and new types
+}
+
+func (c ExportChange) String() string {
+	severity := "non-breaking"
+	if c.Breaking {
+		severity = "breaking"
+	}
+	name := c.PkgPath
+	if c.Name != "" {
+		name += "." + c.Name
+	}
+	if len(c.Differences) == 0 {
+		return fmt.Sprintf("%s: %s (%s)", name, c.Change, severity)
+	}
+	return fmt.Sprintf("%s: %s (%s):\n%s", name, c.Change, severity, formatTypeDifferences(c.Differences))
+}
+
+type ExportComparison struct {
+	Changes []ExportChange
+}
+
+func (c *ExportComparison) BreakingChanges() []ExportChange {
+	var breaking []ExportChange
+	for _, change := range c.Changes {
+		if change.Breaking {
+			breaking = append(breaking, change)
+		}
+	}
+	return breaking
+}
+
+func (c *ExportComparison) Compatible() bool {
+	return len(c.BreakingChanges()) == 0
+}
+
+// Err returns an error describing all breaking changes, or nil if there are none
+func (c *ExportComparison) Err() error {
+	breaking := c.BreakingChanges()
+	if len(breaking) == 0 {
+		return nil
+	}
+	lines := make([]string, len(breaking))
+	for i, change := range breaking {
+		lines[i] = change.String()
+	}
+	return fmt.Errorf("%d breaking export changes:\n%s", len(breaking), strings.Join(lines, "\n"))
+}
+
+func (linker *Linker) buildExportedTypes(codeModule *CodeModule, symbolMap map[string]uintptr) {
+	codeModule.exportedTypes = map[string]map[string]*_type{}
+	for _, pkg := range linker.pkgs {
+		typePrefix := TypePrefix + objabi.PathToPrefix(pkg.PkgPath) + "."
+		pkgTypes := map[string]*_type{}
+		for name := range pkg.Syms {
+			if !strings.HasPrefix(name, typePrefix) {
+				continue
+			}
+			typeName := strings.TrimPrefix(name, typePrefix)
+			// Skip methods' closure types, generic instantiations etc., only plain named types make up the exported API
+			if !token.IsExported(typeName) || strings.ContainsAny(typeName, ".[·") {
+				continue
+			}
+			addr, ok := symbolMap[name]
+			if !ok {
+				continue
+			}
+			if dup, ok := codeModule.deduplicatedTypes[name]; ok {
+				addr = dup
+			}
+			pkgTypes[typeName] = (*_type)(unsafe.Pointer(addr))
+		}
+		if len(pkgTypes) > 0 {
+			codeModule.exportedTypes[pkg.PkgPath] = pkgTypes
+		}
+	}
+}
+
+// CompareExports reports the differences between the exported API (functions, methods, variables and named types)
+// of oldModule and newModule, classifying each as breaking or not. A change is breaking if code (or state) depending
+// on the old module's exports could no longer be used against the new module, e.g. a removed function, a changed
+// signature, or a struct whose fields have changed. Additions (of exports, types and methods) are not considered breaking.
+// Only symbols which were reachable (and therefore loaded) in each module are compared.
+func CompareExports(oldModule, newModule *CodeModule) (*ExportComparison, error) {
+	if oldModule == nil || newModule == nil {
+		return nil, fmt.Errorf("can't compare exports of nil modules")
+	}
+	comparison := &ExportComparison{}
+	for _, pkgPath := range unionKeys(oldModule.SymbolsByPkg, newModule.SymbolsByPkg) {
+		oldSyms, inOld := oldModule.SymbolsByPkg[pkgPath]
+		newSyms, inNew := newModule.SymbolsByPkg[pkgPath]
+		switch {
+		case !inNew:
+			comparison.Changes = append(comparison.Changes, ExportChange{PkgPath: pkgPath, Change: ExportPackageRemoved, Breaking: true})
+			continue
+		case !inOld:
+			comparison.Changes = append(comparison.Changes, ExportChange{PkgPath: pkgPath, Change: ExportPackageAdded})
+			continue
+		}
+		for _, name := range unionKeys(oldSyms, newSyms) {
+			oldSym, inOld := oldSyms[name]
+			newSym, inNew := newSyms[name]
+			change := ExportChange{PkgPath: pkgPath, Name: name}
+			switch {
+			case !inNew:
+				change.Change = ExportRemoved
+				change.Breaking = true
+			case !inOld:
+				change.Change = ExportAdded
+			default:
+				oldT, newT := reflect.TypeOf(oldSym), reflect.TypeOf(newSym)
+				if (oldT.Kind() == reflect.Func) != (newT.Kind() == reflect.Func) {
+					change.Change = ExportKindChanged
+					change.Breaking = true
+					break
+				}
+				change.Differences = DiffTypes(oldT, newT)
+				if len(change.Differences) == 0 {
+					continue
+				}
+				change.Change = ExportVarChanged
+				if oldT.Kind() == reflect.Func {
+					change.Change = ExportFuncChanged
+				}
+				change.Breaking = true
+			}
+			comparison.Changes = append(comparison.Changes, change)
+		}
+	}
+
+	for _, pkgPath := range unionKeys(oldModule.exportedTypes, newModule.exportedTypes) {
+		oldTypes, inOld := oldModule.exportedTypes[pkgPath]
+		newTypes, inNew := newModule.exportedTypes[pkgPath]
+		if !inOld || !inNew {
+			// Already reported as a package addition or removal if it had any exported funcs or vars
+			continue
+		}
+		for _, name := range unionKeys(oldTypes, newTypes) {
+			oldT, inOld := oldTypes[name]
+			newT, inNew := newTypes[name]
+			change := ExportChange{PkgPath: pkgPath, Name: name}
+			switch {
+			case !inNew:
+				change.Change = ExportTypeRemoved
+				change.Breaking = true
+			case !inOld:
+				change.Change = ExportTypeAdded
+			default:
+				change = compareExportedType(pkgPath, name, AsRType(oldT), AsRType(newT))
+				if change.Change == "" {
+					continue
+				}
+			}
+			comparison.Changes = append(comparison.Changes, change)
+		}
+	}
+	return comparison, nil
+}
+
+func compareExportedType(pkgPath, name string, oldT, newT reflect.Type) ExportChange {
+	change := ExportChange{PkgPath: pkgPath, Name: name, Differences: DiffTypes(oldT, newT)}
+	if len(change.Differences) > 0 {
+		// Any difference typesEqual cares about (even a struct tag) prevents values being type asserted or converted across modules
+		change.Change = ExportTypeChanged
+		change.Breaking = true
+		return change
+	}
+	// typesEqual doesn't consider the method sets of concrete types, so check the exported methods (including those with pointer receivers)
+	oldPtr, newPtr := methodSetType(oldT), methodSetType(newT)
+	for i := 0; i < oldPtr.NumMethod(); i++ {
+		method := oldPtr.Method(i)
+		newMethod, ok := newPtr.MethodByName(method.Name)
+		if !ok {
+			change.Differences = append(change.Differences, TypeDifference{Path: "." + method.Name + "()", Reason: TypeDiffMethodSetChanged, Old: "method " + method.Name + " " + method.Type.String()})
+			change.Breaking = true
+			continue
+		}
+		if !methodSignaturesEqual(method.Type, newMethod.Type) {
+			change.Differences = append(change.Differences, TypeDifference{Path: "." + method.Name + "()", Reason: TypeDiffSignatureChanged, Old: method.Type.String(), New: newMethod.Type.String()})
+			change.Breaking = true
+		}
+	}
+	for i := 0; i < newPtr.NumMethod(); i++ {
+		method := newPtr.Method(i)
+		if _, ok := oldPtr.MethodByName(method.Name); !ok {
+			change.Differences = append(change.Differences, TypeDifference{Path: "." + method.Name + "()", Reason: TypeDiffMethodSetChanged, New: "method " + method.Name + " " + method.Type.String()})
+		}
+	}
+	if len(change.Differences) > 0 {
+		change.Change = ExportMethodSetChanged
+	}
+	return change
+}
+
+// methodSetType returns *t if its method set is available (i.e. the pointer type was linked, rather than synthesized by reflect), otherwise t
+func methodSetType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Interface {
+		return t
+	}
+	if ptr := reflect.PointerTo(t); ptr.NumMethod() >= t.NumMethod() {
+		return ptr
+	}
+	return t
+}
+
+// methodSignaturesEqual compares two method types by their parameter and result type names, ignoring the receiver
+func methodSignaturesEqual(a, b reflect.Type) bool {
+	if a.NumIn() != b.NumIn() || a.NumOut() != b.NumOut() || a.IsVariadic() != b.IsVariadic() {
+		return false
+	}
+	for i := 1; i < a.NumIn(); i++ {
+		if a.In(i).String() != b.In(i).String() {
+			return false
+		}
+	}
+	for i := 0; i < a.NumOut(); i++ {
+		if a.Out(i).String() != b.Out(i).String() {
+			return false
+		}
+	}
+	return true
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
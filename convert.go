@@ -38,7 +38,7 @@ func ConvertTypesAcrossModules(oldModule, newModule *CodeModule, oldValue interf
 	newT := fromRType(newType)
 	seen := map[_typePair]struct{}{}
 	if !typesEqual(oldT, newT, seen) {
-		return nil, fmt.Errorf("old type %T and new type %s are not equal", oldValue, newType)
+		return nil, fmt.Errorf("old type %T and new type %s are not equal:\n%s", oldValue, newType, formatTypeDifferences(DiffTypes(AsRType(oldT), newType)))
 	}
 
 	// Need to take data in old value and copy into new value one field at a time, but check that
@@ -46,9 +46,9 @@ func ConvertTypesAcrossModules(oldModule, newModule *CodeModule, oldValue interf
 	oldV := Indirect(ValueOf(&oldValue)).Elem()
 
 	cycleDetector := map[uintptr]*Value{}
-	typeHash := make(map[uint32][]*_type, len(newModule.module.typelinks))
-	buildModuleTypeHash(activeModules()[0], typeHash)
-	buildModuleTypeHash(newModule.module, typeHash)
+	typeHash := func(hash uint32) []*_type {
+		return typeIdx.lookup(hash, newModule.module)
+	}
 
 	cvt(oldModule, newModule, Value{oldV}, AsType(newT), nil, cycleDetector, typeHash)
 
@@ -87,7 +87,7 @@ func AsRType(_typ *_type) reflect.Type {
 
 var closureFuncRegex = regexp.MustCompile(`^.*\.func[0-9]+$`)
 
-func cvt(oldModule, newModule *CodeModule, oldValue Value, newType Type, oldValueBeforeElem *Value, cycleDetector map[uintptr]*Value, typeHash map[uint32][]*_type) {
+func cvt(oldModule, newModule *CodeModule, oldValue Value, newType Type, oldValueBeforeElem *Value, cycleDetector map[uintptr]*Value, typeHash func(hash uint32) []*_type) {
 	// By this point we're sure that types are structurally equal, but their *_type addresses might not be
 
 	kind := oldValue.Kind()
@@ -121,19 +121,17 @@ func cvt(oldModule, newModule *CodeModule, oldValue Value, newType Type, oldValu
 		oldTOuter := toType(oldValue.Type())
 		var newTypeInner *_type
 		var newTypeOuter *_type
-		types := typeHash[oldTInner.hash]
+		types := typeHash(oldTInner.hash)
 		for _, _typeNew := range types {
-			seen := map[_typePair]struct{}{}
-			if oldTInner == _typeNew || typesEqual(oldTInner, _typeNew, seen) {
+			if typesEqualCached(oldTInner, _typeNew) {
 				newTypeInner = _typeNew
 				break
 			}
 		}
 
-		types = typeHash[oldTOuter.hash]
+		types = typeHash(oldTOuter.hash)
 		for _, _typeNew := range types {
-			seen := map[_typePair]struct{}{}
-			if oldTOuter == _typeNew || typesEqual(oldTOuter, _typeNew, seen) {
+			if typesEqualCached(oldTOuter, _typeNew) {
 				newTypeOuter = _typeNew
 				break
 			}
@@ -180,8 +178,7 @@ func cvt(oldModule, newModule *CodeModule, oldValue Value, newType Type, oldValu
 					for _, n := range newModule.module.itablinks {
 						// Need to compare these types carefully
 						if oldItab.inter.typ.hash == n.inter.typ.hash && oldItab._type.hash == n._type.hash {
-							seen := map[_typePair]struct{}{}
-							if typesEqual(&oldItab.inter.typ, &n.inter.typ, seen) && typesEqual(oldItab._type, n._type, seen) {
+							if typesEqualCached(&oldItab.inter.typ, &n.inter.typ) && typesEqualCached(oldItab._type, n._type) {
 								newItab = n
 								break
 							}
@@ -273,8 +270,7 @@ func cvt(oldModule, newModule *CodeModule, oldValue Value, newType Type, oldValu
 									for _, n := range newModule.module.itablinks {
 										// Need to compare these types carefully
 										if oldItab.inter.typ.hash == n.inter.typ.hash && oldItab._type.hash == n._type.hash {
-											seen := map[_typePair]struct{}{}
-											if typesEqual(&oldItab.inter.typ, &n.inter.typ, seen) && typesEqual(oldItab._type, n._type, seen) {
+											if typesEqualCached(&oldItab.inter.typ, &n.inter.typ) && typesEqualCached(oldItab._type, n._type) {
 												newItab = n
 												break
 											}
@@ -298,13 +294,21 @@ func cvt(oldModule, newModule *CodeModule, oldValue Value, newType Type, oldValu
 										F uintptr
 										// ... <- variables which are captured by the closure would follow, but we can't know how many they are or what their types are - the best we can do is switch the function implementation and keep the variables the same
 									})(manipulation.ptr)
-									if runtime.GOARCH == "arm64" && runtime.GOOS == "darwin" {
+									// A closure without captured variables is read-only data of the old module
+									readOnly := oldModule.inReadOnlyData(uintptr(unsafe.Pointer(closure)))
+									if runtime.GOARCH == "arm64" && runtime.GOOS == "darwin" || readOnly {
 										err := mprotect.MprotectMakeWritable(mprotect.GetPage(uintptr(unsafe.Pointer(closure))))
 										if err != nil {
 											panic(fmt.Sprintf("failed to make page of closure writable: %s", err))
 										}
 									}
 									closure.F = entry
+									if readOnly {
+										err := mprotect.MprotectMakeReadOnly(mprotect.GetPage(uintptr(unsafe.Pointer(closure))))
+										if err != nil {
+											panic(fmt.Sprintf("failed to make page of closure read only: %s", err))
+										}
+									}
 									funcContainer = unsafe.Pointer(closure)
 									log.Printf("EVEN BIGGER WARNING - converting anonymous function %s by name - no guarantees that signatures, or the closed over variable sizes, or types will match. This is dangerous! \n", oldFName)
 								}
@@ -11,7 +11,10 @@ import (
 )
 
 func (linker *Linker) addDeferReturn(_func *_func) (err error) {
-	funcname := gostringnocopy(&linker.funcnametab[_func.nameoff])
+	return linker.setDeferReturn(_func, gostringnocopy(&linker.funcnametab[_func.nameoff]))
+}
+
+func (linker *Linker) setDeferReturn(_func *_func, funcname string) (err error) {
 	Func := linker.symMap[funcname].Func
 	if Func != nil && len(Func.FuncData) > dataindex.FUNCDATA_OpenCodedDeferInfo {
 		sym := linker.symMap[funcname]
@@ -0,0 +1,263 @@
+package goloader
+
+import (
+	"bufio"
+	"cmd/objfile/objabi"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DependencyOrigin is where a package in a DependencyGraph comes from
+type DependencyOrigin int
+
+const (
+	DependencyHost       DependencyOrigin = iota // Resolved from the host binary
+	DependencyModule                             // The package the linker was asked to load
+	DependencyRebuilt                            // Read by the linker as a dependency the host binary lacked
+	DependencyUnresolved                         // Needed, but neither read by the linker nor found in the host binary
+)
+
+func (o DependencyOrigin) String() string {
+	switch o {
+	case DependencyHost:
+		return "host"
+	case DependencyModule:
+		return "module"
+	case DependencyRebuilt:
+		return "rebuilt"
+	case DependencyUnresolved:
+		return "unresolved"
+	}
+	return "unknown"
+}
+
+func (o DependencyOrigin) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// DependencyKind is why one package in a DependencyGraph depends on another
+type DependencyKind int
+
+const (
+	DependencyImport    DependencyKind = iota // The package imports the other, see Autolib
+	DependencySymbolRef                       // The package's symbols refer to the other's
+)
+
+func (k DependencyKind) String() string {
+	switch k {
+	case DependencyImport:
+		return "import"
+	case DependencySymbolRef:
+		return "symbol"
+	}
+	return "unknown"
+}
+
+func (k DependencyKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// DependencyNode is a package in a DependencyGraph
+type DependencyNode struct {
+	Package    string           `json:"package"`
+	Origin     DependencyOrigin `json:"origin"`
+	Unresolved []string         `json:"unresolved,omitempty"` // Symbols referred to but found neither in the linker nor the host
+}
+
+// DependencyEdge is a dependency of one package in a DependencyGraph on another
+type DependencyEdge struct {
+	From    string         `json:"from"`
+	To      string         `json:"to"`
+	Kind    DependencyKind `json:"kind"`
+	Symbols []string       `json:"symbols,omitempty"` // For symbol references, those of To referred to by From
+}
+
+// DependencyGraph is the graph of packages read by a linker and the packages they depend on, see
+// Linker.DependencyGraph
+type DependencyGraph struct {
+	Nodes []DependencyNode `json:"nodes"` // Sorted by package
+	Edges []DependencyEdge `json:"edges"` // Sorted by From, To and Kind
+}
+
+// DependencyGraph returns the graph of the packages read by the linker, the packages they import, and the packages
+// whose symbols they refer to. Packages whose symbols can't be found in the linker or in symbolMap, the host binary's
+// symbols, are marked as unresolved along with those symbols, as reported by UnresolvedExternalSymbolUsers. The graph
+// can't be built once the linker is released.
+func (linker *Linker) DependencyGraph(symbolMap map[string]uintptr) (*DependencyGraph, error) {
+	if linker.released {
+		return nil, fmt.Errorf("can't graph the dependencies of a linker after Release")
+	}
+	graph := &DependencyGraph{}
+	if len(linker.pkgs) == 0 {
+		return graph, nil
+	}
+	nodes := map[string]*DependencyNode{}
+	node := func(pkgPath string, origin DependencyOrigin) *DependencyNode {
+		if n, ok := nodes[pkgPath]; ok {
+			return n
+		}
+		n := &DependencyNode{Package: pkgPath, Origin: origin}
+		nodes[pkgPath] = n
+		return n
+	}
+	type edgeKey struct {
+		from, to string
+		kind     DependencyKind
+	}
+	edges := map[edgeKey]map[string]struct{}{}
+	addEdge := func(from, to string, kind DependencyKind, symName string) {
+		key := edgeKey{from, to, kind}
+		if edges[key] == nil {
+			edges[key] = map[string]struct{}{}
+		}
+		if symName != "" {
+			edges[key][symName] = struct{}{}
+		}
+	}
+
+	mainPkg := linker.pkgs[len(linker.pkgs)-1]
+	for _, pkg := range linker.pkgs {
+		origin := DependencyRebuilt
+		if pkg == mainPkg {
+			origin = DependencyModule
+		}
+		node(pkg.PkgPath, origin)
+	}
+	pkgPath := linker.pkgPathResolver()
+	for _, pkgName := range linker.Autolib() {
+		pkg := linker.pkgsByName[pkgName]
+		if pkg == nil {
+			continue
+		}
+		for _, imported := range pkg.AutoLib {
+			node(imported, DependencyHost)
+			addEdge(pkg.PkgPath, imported, DependencyImport, "")
+		}
+	}
+
+	unresolved := linker.UnresolvedExternalSymbolUsers(symbolMap)
+	resolved := map[string]bool{}
+	for _, symbol := range linker.symMap {
+		if symbol.Offset == InvalidOffset || symbol.Pkg == "" {
+			continue
+		}
+		from := pkgPath(symbol.Pkg)
+		for _, reloc := range symbol.Reloc {
+			if reloc.Sym == nil || reloc.Sym.Pkg == "" {
+				continue
+			}
+			to := pkgPath(reloc.Sym.Pkg)
+			if to == from {
+				continue
+			}
+			n := node(to, DependencyHost)
+			if _, ok := unresolved[reloc.Sym.Name]; ok {
+				n.Unresolved = append(n.Unresolved, reloc.Sym.Name)
+			} else {
+				resolved[to] = true
+			}
+			addEdge(from, to, DependencySymbolRef, reloc.Sym.Name)
+		}
+	}
+
+	for _, n := range nodes {
+		if len(n.Unresolved) > 0 {
+			n.Unresolved = sortedUnique(n.Unresolved)
+			if n.Origin == DependencyHost && !resolved[n.Package] {
+				n.Origin = DependencyUnresolved
+			}
+		}
+		graph.Nodes = append(graph.Nodes, *n)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].Package < graph.Nodes[j].Package })
+	for key, symNames := range edges {
+		edge := DependencyEdge{From: key.from, To: key.to, Kind: key.kind}
+		for symName := range symNames {
+			edge.Symbols = append(edge.Symbols, symName)
+		}
+		sort.Strings(edge.Symbols)
+		graph.Edges = append(graph.Edges, edge)
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	return graph, nil
+}
+
+// pkgPathResolver returns a func mapping the escaped package prefixes which symbols name their packages by back to
+// import paths, where known from the packages read and their imports
+func (linker *Linker) pkgPathResolver() func(prefix string) string {
+	pathsByPrefix := map[string]string{}
+	for _, pkg := range linker.pkgs {
+		pathsByPrefix[objabi.PathToPrefix(pkg.PkgPath)] = pkg.PkgPath
+		for _, imported := range pkg.AutoLib {
+			pathsByPrefix[objabi.PathToPrefix(imported)] = imported
+		}
+	}
+	return func(prefix string) string {
+		if path, ok := pathsByPrefix[prefix]; ok {
+			return path
+		}
+		return prefix
+	}
+}
+
+func sortedUnique(names []string) []string {
+	sort.Strings(names)
+	unique := names[:1]
+	for _, name := range names[1:] {
+		if name != unique[len(unique)-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// WriteJSON writes the graph to w as JSON
+func (g *DependencyGraph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// WriteDOT writes the graph to w in Graphviz's DOT language. Imports are drawn as solid edges and symbol references as
+// dashed edges labelled with the number of symbols referred to, which are listed in the edge's tooltip.
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintln(bw, "digraph dependencies {")
+	_, _ = fmt.Fprintln(bw, "\trankdir=LR;")
+	_, _ = fmt.Fprintln(bw, "\tnode [shape=box, style=filled];")
+	colors := map[DependencyOrigin]string{
+		DependencyHost:       "lightgrey",
+		DependencyModule:     "lightblue",
+		DependencyRebuilt:    "orange",
+		DependencyUnresolved: "red",
+	}
+	for _, n := range g.Nodes {
+		color := colors[n.Origin]
+		if len(n.Unresolved) > 0 {
+			// Including packages only partly resolved from the host
+			color = "red"
+		}
+		_, _ = fmt.Fprintf(bw, "\t%q [fillcolor=%s, tooltip=%q];\n", n.Package, color, n.Origin.String())
+	}
+	for _, e := range g.Edges {
+		if e.Kind == DependencyImport {
+			_, _ = fmt.Fprintf(bw, "\t%q -> %q;\n", e.From, e.To)
+			continue
+		}
+		_, _ = fmt.Fprintf(bw, "\t%q -> %q [style=dashed, label=\"%d\", tooltip=%q];\n", e.From, e.To, len(e.Symbols), strings.Join(e.Symbols, "\n"))
+	}
+	_, _ = fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,302 @@
+package goloader
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// A Disassembler decodes the instructions of an ELF object's text, calling f with the address, size and Go assembly
+// syntax of each instruction in [start, end)
+type Disassembler interface {
+	Decode(start, end uint64, f func(pc, size uint64, text string))
+}
+
+// ErrNoDisassembler is returned by Disassemble when no disassembler has been registered (see RegisterDisassembler),
+// or the registered one can't disassemble with this version of Go
+var ErrNoDisassembler = errors.New("no disassembler available")
+
+var (
+	newDisassembler func(object []byte) (Disassembler, error)
+	disassembly     = make(map[*CodeModule]cachedDisassembler)
+	disassemblyLock sync.Mutex
+)
+
+type cachedDisassembler struct {
+	object []byte
+	d      Disassembler
+}
+
+// RegisterDisassembler sets the function Disassemble uses to decode a module's text, which is given to it as an ELF
+// object. It's called by importing github.com/eihigh/goloader/disasm, so that the disassemblers are only linked into
+// hosts which ask for them.
+func RegisterDisassembler(open func(object []byte) (Disassembler, error)) {
+	disassemblyLock.Lock()
+	defer disassemblyLock.Unlock()
+	newDisassembler = open
+	disassembly = make(map[*CodeModule]cachedDisassembler)
+}
+
+// Disassemble writes the named function's text as relocated in memory, in Go assembly syntax. Operands which refer
+// to the module, another loaded module or the host binary are shown as symbols, so the resolved target of each
+// relocation can be read off directly. Each instruction is preceded by its source line from the module's pcln tables,
+// and instructions in relocation epilogues added by the linker are marked as such. If the module was loaded with
+// WithRelocationRecords, each relocated instruction is also annotated with its relocation. A disassembler must have
+// been registered, see RegisterDisassembler.
+func (cm *CodeModule) Disassemble(w io.Writer, symbolName string) error {
+	var symbol *Symbol
+	for i := range cm.symbols {
+		if cm.symbols[i].Name == symbolName && cm.symbols[i].Kind == SymbolText {
+			symbol = &cm.symbols[i]
+			break
+		}
+	}
+	if symbol == nil {
+		return fmt.Errorf("no function %s in module", symbolName)
+	}
+	d, err := cm.disassembler()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	cm.disassembleFunc(bw, d, *symbol)
+	return bw.Flush()
+}
+
+// DisassembleAll is Disassemble for every function in the module in address order, followed by the trampolines and
+// GOT slots added for out of range relocations (see FarRefStats)
+func (cm *CodeModule) DisassembleAll(w io.Writer) error {
+	d, err := cm.disassembler()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, symbol := range cm.symbols {
+		if symbol.Kind == SymbolText {
+			cm.disassembleFunc(bw, d, symbol)
+		}
+	}
+	cm.disassembleFarRefs(bw, d)
+	return bw.Flush()
+}
+
+func (cm *CodeModule) disassembleFunc(w io.Writer, d Disassembler, symbol Symbol) {
+	file, _ := sourceLine(symbol.Addr)
+	_, _ = fmt.Fprintf(w, "TEXT %s(SB) %s\n", symbol.Name, file)
+	// The epilogue follows the function's own code, see patchableFunc
+	epilogue := symbol.Addr + symbol.Size
+	if f, ok := cm.funcs[symbol.Name]; ok {
+		epilogue = f.entry + uintptr(f.size)
+	}
+	// A relocation redirected by type deduplication is recorded again, so only its last record is current
+	relocs := map[uintptr]RelocRecord{}
+	for _, record := range cm.RelocRecords() {
+		if record.Symbol == symbol.Name {
+			relocs[record.Addr] = record
+		}
+	}
+	tw := tabwriter.NewWriter(w, 18, 8, 1, '\t', tabwriter.StripEscape)
+	d.Decode(uint64(symbol.Addr), uint64(symbol.Addr+symbol.Size), func(pc, size uint64, text string) {
+		file, line := sourceLine(uintptr(pc))
+		_, _ = fmt.Fprintf(tw, "  %s:%d\t%#x\t%x\t%s", filepath.Base(file), line, pc, cm.codeAt(uintptr(pc), int(size)), text)
+		if uintptr(pc) >= epilogue {
+			_, _ = fmt.Fprint(tw, "\t// relocation epilogue")
+		}
+		for addr := uintptr(pc); addr < uintptr(pc+size); addr++ {
+			if record, ok := relocs[addr]; ok {
+				_, _ = fmt.Fprintf(tw, "\t// %s %s", record.Type, farRefName(farRefKey{name: record.Target, add: record.Add}))
+				_, _ = fmt.Fprintf(tw, " (%s", record.Resolved)
+				if record.Overflow != RelocInRange {
+					_, _ = fmt.Fprintf(tw, " via %s", record.Overflow)
+				}
+				_, _ = fmt.Fprint(tw, ")")
+			}
+		}
+		_, _ = fmt.Fprintln(tw)
+	})
+	_ = tw.Flush()
+}
+
+func (cm *CodeModule) disassembleFarRefs(w io.Writer, d Disassembler) {
+	if cm.farRefs == nil || len(cm.farRefs.entries) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 18, 8, 1, '\t', tabwriter.StripEscape)
+	for _, key := range cm.farRefKeys() {
+		addr := uintptr(cm.codeBase + cm.farRefs.entries[key])
+		if key.kind == farRefGOTSlot {
+			_, _ = fmt.Fprintf(tw, "GOT %s\n", farRefName(key))
+			_, _ = fmt.Fprintf(tw, "  \t%#x\t%x\tDATA $%#x\n", addr, cm.codeAt(addr, farRefGOTSlotSize), cm.farRefTarget(addr))
+			continue
+		}
+		// Each trampoline is a jump through the target address stored at its end
+		_, _ = fmt.Fprintf(tw, "TRAMPOLINE %s\n", farRefName(key))
+		slot := addr + farRefTrampolineSize - PtrSize
+		if runtime.GOARCH == "amd64" {
+			slot = addr + uintptr(len(x86amd64JMPLcode))
+		}
+		d.Decode(uint64(addr), uint64(slot), func(pc, size uint64, text string) {
+			_, _ = fmt.Fprintf(tw, "  \t%#x\t%x\t%s\n", pc, cm.codeAt(uintptr(pc), int(size)), text)
+		})
+		_, _ = fmt.Fprintf(tw, "  \t%#x\t%x\tDATA $%#x\n", slot, cm.codeAt(slot, PtrSize), cm.farRefTarget(slot))
+	}
+	_ = tw.Flush()
+}
+
+// farRefKeys returns the keys of the module's trampolines and GOT slots in address order
+func (cm *CodeModule) farRefKeys() []farRefKey {
+	keys := make([]farRefKey, 0, len(cm.farRefs.entries))
+	for key := range cm.farRefs.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return cm.farRefs.entries[keys[i]] < cm.farRefs.entries[keys[j]] })
+	return keys
+}
+
+func farRefName(key farRefKey) string {
+	if key.add != 0 {
+		return fmt.Sprintf("%s+%d", key.name, key.add)
+	}
+	return key.name
+}
+
+func (cm *CodeModule) codeAt(addr uintptr, size int) []byte {
+	offset := int(addr) - cm.codeBase
+	return cm.codeByte[offset : offset+size]
+}
+
+func (cm *CodeModule) farRefTarget(slot uintptr) uint64 {
+	return hostByteOrder().Uint64(cm.codeAt(slot, PtrSize))
+}
+
+func sourceLine(pc uintptr) (string, int) {
+	f := runtime.FuncForPC(pc)
+	if f == nil {
+		return "?", 0
+	}
+	return f.FileLine(pc)
+}
+
+// disassembler returns a disassembler for a copy of the module's text. The registered disassembler is given the text
+// as an ELF object, along with the symbols its operands may refer to: the module's own, those of other loaded
+// modules, the host's (as given to Load) and a name for each trampoline and GOT slot. The disassembler is kept until
+// the object changes, as when functions are bound, patched or other modules come and go.
+func (cm *CodeModule) disassembler() (Disassembler, error) {
+	var machine elf.Machine
+	switch runtime.GOARCH {
+	case "amd64":
+		machine = elf.EM_X86_64
+	case "arm64":
+		machine = elf.EM_AARCH64
+	default:
+		return nil, fmt.Errorf("disassembly is not supported on %s", runtime.GOARCH)
+	}
+	object := cm.disassemblyObject(machine)
+
+	disassemblyLock.Lock()
+	defer disassemblyLock.Unlock()
+	if newDisassembler == nil {
+		return nil, fmt.Errorf("%w, import github.com/eihigh/goloader/disasm", ErrNoDisassembler)
+	}
+	if cached, ok := disassembly[cm]; ok && bytes.Equal(cached.object, object) {
+		return cached.d, nil
+	}
+	d, err := newDisassembler(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to disassemble module: %w", err)
+	}
+	disassembly[cm] = cachedDisassembler{object: object, d: d}
+	return d, nil
+}
+
+func (cm *CodeModule) disassemblyObject(machine elf.Machine) []byte {
+	textLen := cm.codeLen
+	if cm.farRefs != nil {
+		textLen = cm.farRefs.start + cm.farRefs.used
+	}
+	if cm.lazy != nil {
+		// Functions may be bound while they're copied
+		cm.lazy.mu.Lock()
+	}
+	text := append([]byte(nil), cm.codeByte[:textLen]...)
+	if cm.lazy != nil {
+		cm.lazy.mu.Unlock()
+	}
+
+	order := hostByteOrder()
+	symtab := &dwBuf{order: order, b: make([]byte, 24)}
+	strtab := &dwBuf{order: order, b: []byte{0}}
+	addSym := func(name string, addr, size uint64, typ elf.SymType) {
+		symtab.u32(uint32(len(strtab.b)))
+		symtab.u8(elf.ST_INFO(elf.STB_GLOBAL, typ))
+		symtab.u8(0)
+		symtab.u16(uint16(elf.SHN_ABS))
+		symtab.u64(addr)
+		symtab.u64(size)
+		strtab.str(name)
+	}
+	for _, module := range Modules() {
+		for _, symbol := range module.symbols {
+			typ := elf.STT_OBJECT
+			if symbol.Kind == SymbolText {
+				typ = elf.STT_FUNC
+			}
+			addSym(symbol.Name, uint64(symbol.Addr), uint64(symbol.Size), typ)
+		}
+	}
+	// Symbols are added in a fixed order, so an unchanged module gives the same object
+	if cm.farRefs != nil {
+		for _, key := range cm.farRefKeys() {
+			offset := cm.farRefs.entries[key]
+			if key.kind == farRefGOTSlot {
+				addSym(farRefName(key)+"@got", uint64(cm.codeBase+offset), farRefGOTSlotSize, elf.STT_OBJECT)
+			} else {
+				addSym(farRefName(key)+"@trampoline", uint64(cm.codeBase+offset), farRefTrampolineSize, elf.STT_FUNC)
+			}
+		}
+	}
+	// The host's symbols don't have sizes, so each is taken to extend to the next
+	type hostSym struct {
+		name string
+		addr uintptr
+	}
+	host := make([]hostSym, 0, len(cm.symPtr))
+	for name, addr := range cm.symPtr {
+		if addr != 0 && !cm.contains(addr) {
+			host = append(host, hostSym{name, addr})
+		}
+	}
+	sort.Slice(host, func(i, j int) bool {
+		if host[i].addr != host[j].addr {
+			return host[i].addr < host[j].addr
+		}
+		return host[i].name < host[j].name
+	})
+	for i, sym := range host {
+		size := uintptr(PtrSize)
+		if i+1 < len(host) && host[i+1].addr > sym.addr {
+			size = host[i+1].addr - sym.addr
+		}
+		addSym(sym.name, uint64(sym.addr), uint64(size), elf.STT_NOTYPE)
+	}
+
+	return writeELF(order, machine, []elfSection{
+		{name: ".text", typ: elf.SHT_PROGBITS, flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, addr: uint64(cm.codeBase), data: text, align: 16},
+		{name: ".symtab", typ: elf.SHT_SYMTAB, data: symtab.b, link: 3, info: 1, align: 8, entsize: 24},
+		{name: ".strtab", typ: elf.SHT_STRTAB, data: strtab.b, align: 1},
+	})
+}
+
+func forgetDisassembly(cm *CodeModule) {
+	disassemblyLock.Lock()
+	delete(disassembly, cm)
+	disassemblyLock.Unlock()
+}
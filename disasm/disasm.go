@@ -0,0 +1,13 @@
+// Package disasm registers the disassemblers of the toolchain's cmd/objfile copy (see the README) for
+// CodeModule.Disassemble. It's imported for its side effect, so that hosts which never disassemble don't link them in:
+//
+//	import _ "github.com/eihigh/goloader/disasm"
+package disasm
+
+import (
+	"github.com/eihigh/goloader"
+)
+
+func init() {
+	goloader.RegisterDisassembler(open)
+}
@@ -0,0 +1,48 @@
+//go:build go1.18 && !go1.22
+// +build go1.18,!go1.22
+
+package disasm
+
+import (
+	"cmd/objfile/objfile"
+	"fmt"
+	"os"
+
+	"github.com/eihigh/goloader"
+)
+
+type decoder struct {
+	d *objfile.Disasm
+}
+
+func (d decoder) Decode(start, end uint64, f func(pc, size uint64, text string)) {
+	d.d.Decode(start, end, nil, false, func(pc, size uint64, _ string, _ int, text string) {
+		f(pc, size, text)
+	})
+}
+
+// objfile only reads files, so the object is written out for as long as it takes to decode its symbols and text
+func open(object []byte) (goloader.Disassembler, error) {
+	f, err := os.CreateTemp("", "goloader-disasm-*.o")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object for disassembly: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(object)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write object for disassembly: %w", err)
+	}
+	file, err := objfile.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	d, err := file.Disasm()
+	if err != nil {
+		return nil, err
+	}
+	return decoder{d}, nil
+}
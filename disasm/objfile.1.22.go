@@ -0,0 +1,16 @@
+//go:build go1.22
+// +build go1.22
+
+package disasm
+
+import (
+	"fmt"
+
+	"github.com/eihigh/goloader"
+)
+
+// Since Go 1.22 the disassemblers live in cmd/internal/disasm, which takes cmd/internal/objfile's File and so can't be
+// used from the copy
+func open(object []byte) (goloader.Disassembler, error) {
+	return nil, fmt.Errorf("%w: cmd/objfile has no disassembler since Go 1.22", goloader.ErrNoDisassembler)
+}
@@ -0,0 +1,295 @@
+package goloader
+
+import (
+	"cmd/objfile/objabi"
+	"cmd/objfile/sys"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/eihigh/goloader/mmap"
+	"github.com/eihigh/goloader/obj"
+	"github.com/eihigh/goloader/objabi/reloctype"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+const (
+	farRefTrampoline = iota
+	farRefGOTSlot
+)
+
+const (
+	// JMP *[RIP+0] (amd64) or LDR X17, PC+8; BR X17 (arm64), followed by the 64 bit target address
+	farRefTrampolineSize = 16
+	farRefGOTSlotSize    = 8
+)
+
+type farRefKey struct {
+	kind int
+	name string
+	add  int
+}
+
+// farRefTable is an area of the code segment after the module's text (and outside any function) which holds the
+// trampolines and GOT slots for relocations whose targets are out of range of their instruction's PC relative offset.
+// Each entry is shared by all relocations to the same target, and is only allocated if one of them needs it.
+type farRefTable struct {
+	start         int // offset within the code segment
+	size          int // bytes reserved, enough for every candidate target to need an entry
+	used          int
+	entries       map[farRefKey]int
+	trampolines   int
+	gotSlots      int
+	epilogueBytes int
+}
+
+// hostTrampolines holds the trampolines within reach of the host binary's method offsets for methods of host types
+// which only a module mapped out of its range provides, see hostMethodTrampoline
+type hostTrampolines struct {
+	pages   [][]byte
+	used    int                 // Bytes used of the last page
+	entries map[uintptr]uintptr // Method entry -> trampoline
+}
+
+// FarRefStats describes how a module's relocations to targets beyond the reach of a PC relative instruction were resolved
+type FarRefStats struct {
+	Trampolines   int  // Jumps to out of range call targets, each shared by all calls to that target
+	GOTSlots      int  // Slots holding the address of an out of range target (or a GOT entry), each shared by all loads of it
+	ReservedBytes int  // Bytes reserved after the module's text for trampolines and GOT slots
+	UsedBytes     int  // Bytes of the reservation actually used
+	EpilogueBytes int  // Bytes of padding after individual instructions which can't use a shared entry (e.g. MOVQ/CMPL on amd64)
+	FarMapped     bool // The module couldn't be mapped within range of the host binary, so every reference to it is far
+}
+
+func (cm *CodeModule) FarRefStats() FarRefStats {
+	if cm.farRefs == nil {
+		return FarRefStats{}
+	}
+	return FarRefStats{
+		Trampolines:   cm.farRefs.trampolines,
+		GOTSlots:      cm.farRefs.gotSlots,
+		ReservedBytes: cm.farRefs.size,
+		UsedBytes:     cm.farRefs.used,
+		EpilogueBytes: cm.farRefs.epilogueBytes,
+		FarMapped:     cm.mappedAnywhere,
+	}
+}
+
+// ErrOutOfRangeOfHost is returned (wrapped) by Load when the host binary would have to refer to a module by an offset
+// which can't reach it, which only happens if the module was mapped below the host binary's text or out of range of it
+// altogether (see mapModule). This is the case when a method of a host type was dead code eliminated from the host, so
+// the type's method table must be pointed at the module's copy of the method instead, and not even a page for a
+// trampoline to the method could be mapped within reach of the host (see hostMethodTrampoline).
+var ErrOutOfRangeOfHost = errors.New("module is out of range of the host binary")
+
+// mapModule acquires the code and data mappings for a module, within PC relative range of the first module if possible.
+// If not, the module is mapped wherever the OS chooses instead, since every reference from its code to the host binary
+// or other modules can be redirected through the far reference table or a relocation epilogue, and every type offset
+// from its type descriptors to deduplicated types through its typemap. Only references between the module's own code
+// and data have to stay PC relative, so those two mappings must still be near each other. References the other way,
+// from the host's type descriptors to the module's methods, go through trampolines mapped near the host instead (see
+// hostMethodTrampoline), so Load only fails with ErrOutOfRangeOfHost if not even those can be.
+func (linker *Linker) mapModule(codeModule *CodeModule) (codeByte, dataByte []byte, err error) {
+	if linker.options.ForceTestMapAnywhere {
+		err = mmap.ErrNoMappingInRange
+	} else {
+		codeByte, err = Mmap(codeModule.maxCodeLength)
+		if err == nil {
+			dataByte, err = MmapData(codeModule.maxDataLength)
+			if err != nil {
+				_ = Munmap(codeByte)
+			}
+		}
+	}
+	if !errors.Is(err, mmap.ErrNoMappingInRange) {
+		return codeByte, dataByte, err
+	}
+	codeByte, dataByte, err2 := mmap.MmapAnywhere(codeModule.maxCodeLength, codeModule.maxDataLength)
+	if err2 != nil {
+		return nil, nil, fmt.Errorf("%w (and failed to map the module anywhere else: %v)", err, err2)
+	}
+	codeModule.mappedAnywhere = true
+	return codeByte, dataByte, nil
+}
+
+// pcrelOpcodeAt returns the opcode of the x86 instruction an R_PCREL relocation at relocOffset in code is applied to
+func pcrelOpcodeAt(code []byte, relocOffset int) byte {
+	if relocOffset < 2 {
+		// Only a 1 byte opcode fits before the offset, e.g. a CGo E9 JMP at the very beginning of a function
+		return code[relocOffset-1]
+	}
+	prefix := code[relocOffset-2 : relocOffset]
+	switch prefix[0] {
+	case x86amd64LEAcode, x86amd64MOVcode, x86amd64CMPLcode:
+		return prefix[0]
+	case x86amd64CALL2code:
+		// Only FF 15 is an indirect CALL through a rip-relative address, other FF bytes are the tail of an unrelated
+		// instruction before an E8 CALL or E9 JMP
+		if prefix[1] == 0x15 {
+			return prefix[0]
+		}
+	}
+	switch prefix[1] {
+	case x86amd64CALLcode, x86amd64JMPcode:
+		return prefix[1]
+	}
+	return prefix[0]
+}
+
+// farRefKind returns which kind of far reference table entry a relocation would use if its target is out of range
+func farRefKind(loc obj.Reloc, code []byte) (kind int, ok bool) {
+	switch loc.Type {
+	case reloctype.R_CALL, reloctype.R_CALL | reloctype.R_WEAK, reloctype.R_CALLARM64, reloctype.R_CALLARM64 | reloctype.R_WEAK:
+		return farRefTrampoline, true
+	case reloctype.R_GOTPCREL, reloctype.R_TLS_IE, reloctype.R_ARM64_GOTPCREL, reloctype.R_ARM64_TLS_IE, reloctype.R_ADDRARM64:
+		return farRefGOTSlot, true
+	case reloctype.R_PCREL:
+		switch pcrelOpcodeAt(code, loc.Offset) {
+		case x86amd64CALLcode, x86amd64JMPcode:
+			return farRefTrampoline, true
+		case x86amd64LEAcode, x86amd64CALL2code:
+			return farRefGOTSlot, true
+		}
+	}
+	return 0, false
+}
+
+// newFarRefTable reserves space after codeLen bytes of text for an entry for every distinct target which might need one
+func (linker *Linker) newFarRefTable(codeLen int) *farRefTable {
+	table := &farRefTable{start: alignof(codeLen, farRefTrampolineSize), entries: map[farRefKey]int{}}
+	candidates := map[farRefKey]struct{}{}
+	for _, sym := range linker.symMap {
+		if sym.Kind != symkind.STEXT {
+			continue
+		}
+		for _, loc := range sym.Reloc {
+			table.epilogueBytes += loc.EpilogueSize
+			if kind, ok := farRefKind(loc, linker.code); ok {
+				key := farRefKey{kind: kind, name: loc.Sym.Name, add: loc.Add}
+				if _, seen := candidates[key]; !seen {
+					candidates[key] = struct{}{}
+					if kind == farRefTrampoline {
+						table.size += farRefTrampolineSize
+					} else {
+						table.size += farRefGOTSlotSize
+					}
+				}
+			}
+		}
+	}
+	if linker.options.LazyBinding {
+		// The stack checks of functions bound on first call may need to reach either variant of the stub, see lazy.go
+		table.size += 2 * farRefTrampolineSize
+	}
+	return table
+}
+
+// farRef returns the offset within the code segment of the trampoline or GOT slot for loc's target, allocating it if
+// this is the first relocation to need it. The entry is (re)written with target each time, since deduplication of
+// type descriptors may relocate all references to a symbol again.
+func (linker *Linker) farRef(segment *segment, kind int, loc obj.Reloc, target uintptr) (int, error) {
+	table := segment.farRefs
+	key := farRefKey{kind: kind, name: loc.Sym.Name, add: loc.Add}
+	offset, ok := table.entries[key]
+	if !ok {
+		size := farRefGOTSlotSize
+		if kind == farRefTrampoline {
+			size = farRefTrampolineSize
+		}
+		if table.used+size > table.size {
+			return 0, fmt.Errorf("far reference table (%d bytes) is full while relocating %s to %s", table.size, objabi.RelocType(loc.Type), loc.Sym.Name)
+		}
+		offset = table.start + table.used
+		table.used += size
+		table.entries[key] = offset
+		if kind == farRefTrampoline {
+			table.trampolines++
+		} else {
+			table.gotSlots++
+		}
+		if linker.options.RelocationDebugWriter != nil {
+			entryType := "GOT SLOT  "
+			if kind == farRefTrampoline {
+				entryType = "TRAMPOLINE"
+			}
+			_, _ = fmt.Fprintf(linker.options.RelocationDebugWriter, "FAR REF %s Pos: 0x%08x, Addr: 0x%016x %s+%d\n",
+				entryType, segment.codeBase+offset, target, loc.Sym.Name, loc.Add)
+		}
+	}
+
+	linker.relocOverflow = RelocTrampoline
+	if kind == farRefGOTSlot {
+		linker.relocOverflow = RelocGOTSlot
+	}
+	code := segment.codeByte[offset:]
+	if kind == farRefGOTSlot {
+		putAddress(linker.Arch.ByteOrder, code, uint64(target))
+		return offset, nil
+	}
+	if err := putFarRefTrampoline(linker.Arch, code, target); err != nil {
+		return 0, fmt.Errorf("%w, can't relocate %s to %s", err, objabi.RelocType(loc.Type), loc.Sym.Name)
+	}
+	return offset, nil
+}
+
+// putFarRefTrampoline writes a trampoline to target at the start of code
+func putFarRefTrampoline(arch *sys.Arch, code []byte, target uintptr) error {
+	switch arch.Family {
+	case sys.AMD64:
+		copy(code, x86amd64JMPLcode)
+		putAddress(arch.ByteOrder, code[len(x86amd64JMPLcode):], uint64(target))
+	case sys.ARM64:
+		copy(code, arm64CALLCode)
+		putAddress(arch.ByteOrder, code[len(arm64CALLCode):], uint64(target))
+	default:
+		return fmt.Errorf("trampolines are not supported on %s", arch.Name)
+	}
+	return nil
+}
+
+// hostMethodTrampoline returns a trampoline to a method of a host type at entry in the module's text, which is out of
+// reach of the host's method offsets since the module was mapped out of its range. Trampolines are allocated a page at
+// a time from the mappings near the host binary, and are released along with the module.
+func (cm *CodeModule) hostMethodTrampoline(arch *sys.Arch, entry uintptr) (uintptr, error) {
+	if target, ok := cm.hostTrampolines.entries[entry]; ok {
+		return target, nil
+	}
+	pages := cm.hostTrampolines.pages
+	if len(pages) == 0 || cm.hostTrampolines.used+farRefTrampolineSize > len(pages[len(pages)-1]) {
+		page, err := Mmap(osPageSize)
+		if err != nil {
+			return 0, err
+		}
+		start := uintptr(unsafe.Pointer(&page[0]))
+		if start < firstmoduledata.text || uint64(start+uintptr(len(page))-firstmoduledata.text) >= 1<<32-1 {
+			_ = Munmap(page)
+			return 0, fmt.Errorf("no page was free within 4GB above the host binary's text at 0x%x", firstmoduledata.text)
+		}
+		cm.hostTrampolines.pages = append(pages, page)
+		cm.hostTrampolines.used = 0
+	}
+	page := cm.hostTrampolines.pages[len(cm.hostTrampolines.pages)-1]
+	code := page[cm.hostTrampolines.used : cm.hostTrampolines.used+farRefTrampolineSize]
+	if err := putFarRefTrampoline(arch, code, entry); err != nil {
+		return 0, err
+	}
+	cm.hostTrampolines.used += farRefTrampolineSize
+	if cm.hostTrampolines.entries == nil {
+		cm.hostTrampolines.entries = map[uintptr]uintptr{}
+	}
+	target := uintptr(unsafe.Pointer(&code[0]))
+	cm.hostTrampolines.entries[entry] = target
+	return target, nil
+}
+
+// releaseHostTrampolines unmaps the pages of the module's host method trampolines, once no host type refers to them
+func (cm *CodeModule) releaseHostTrampolines() error {
+	var err error
+	for _, page := range cm.hostTrampolines.pages {
+		if err2 := Munmap(page); err == nil {
+			err = err2
+		}
+	}
+	cm.hostTrampolines = hostTrampolines{}
+	return err
+}
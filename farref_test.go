@@ -0,0 +1,25 @@
+package goloader
+
+import "testing"
+
+func TestPcrelOpcodeAt(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		want byte
+	}{
+		{"call", []byte{0x90, 0x90, 0xE8, 0, 0, 0, 0}, x86amd64CALLcode},
+		{"jmp", []byte{0x90, 0x90, 0xE9, 0, 0, 0, 0}, x86amd64JMPcode},
+		{"indirect call", []byte{0x90, 0xFF, 0x15, 0, 0, 0, 0}, x86amd64CALL2code},
+		{"call after ff", []byte{0x90, 0xFF, 0xE8, 0, 0, 0, 0}, x86amd64CALLcode},
+		{"jmp after ff", []byte{0x90, 0xFF, 0xE9, 0, 0, 0, 0}, x86amd64JMPcode},
+		{"lea", []byte{0x48, 0x8D, 0x05, 0, 0, 0, 0}, x86amd64LEAcode},
+		{"jmp at start", []byte{0xE9, 0, 0, 0, 0}, x86amd64JMPcode},
+	}
+	for _, test := range tests {
+		relocOffset := len(test.code) - 4
+		if got := pcrelOpcodeAt(test.code, relocOffset); got != test.want {
+			t.Errorf("%s: pcrelOpcodeAt(% X, %d) = %#x, want %#x", test.name, test.code, relocOffset, got, test.want)
+		}
+	}
+}
@@ -29,6 +29,7 @@ func generategcdata(linker *Linker, codeModule *CodeModule, symbolMap map[string
 	}
 	if ptr, ok := symbolMap[typeName]; ok {
 		typ := (*_type)(adduintptr(ptr, 0))
+		codeModule.dataTypes = append(codeModule.dataTypes, dataType{addr: symbolMap[sym.Name], typ: typ})
 		nptr := int64(typ.ptrdata) / int64(linker.Arch.PtrSize)
 		if typ.kind&KindGCProg == 0 {
 			var mask []byte
@@ -47,9 +47,9 @@ func (linker *Linker) addInlineTree(_f *_func, symbol *obj.ObjSymbol) (err error
 			inlinedcall := obj.InitInlinedCall(inl, funcID, linker.namemap, linker.cutab)
 			copy2Slice(bytes[k*obj.InlinedCallSize:], uintptr(unsafe.Pointer(&inlinedcall)), obj.InlinedCallSize)
 		}
-		offset := len(linker.noptrdata)
-		linker.noptrdata = append(linker.noptrdata, bytes...)
-		bytearrayAlign(&linker.noptrdata, PtrSize)
+		offset := len(linker.rodata)
+		linker.rodata = append(linker.rodata, bytes...)
+		bytearrayAlign(&linker.rodata, PtrSize)
 		for _f.nfuncdata <= dataindex.FUNCDATA_InlTree {
 			sym.Func.FuncData = append(sym.Func.FuncData, uintptr(0))
 			_f.nfuncdata++
@@ -0,0 +1,149 @@
+package goloader
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/eihigh/goloader/obj"
+	"github.com/eihigh/goloader/objabi/reloctype"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// LoadInstance loads another instance of the packages in linker, which must already have been loaded as first.
+// The new instance has its own data and BSS, and runs its packages' init tasks again, so package level state isn't
+// shared between instances.
+//
+// Functions and type descriptors which behave the same in every instance, since they refer (directly or through
+// read-only data) only to the host binary and to other such symbols, are shared with first rather than loaded again:
+// the new instance's references resolve to first's copies, and its own pages for them are never written. So values of
+// those types can be passed between instances, and type assertions and interface conversions on them behave as if
+// they came from the same module. Anything referring to package level state isn't shared, and neither are types whose
+// methods aren't, so that methods reached through type descriptors (by reflection, or via itabs built at runtime)
+// always run the instance's own code. Values of such types are distinct types in each instance.
+//
+// first can't be unloaded while any other instance is loaded.
+func LoadInstance(linker *Linker, symPtr map[string]uintptr, first *CodeModule) (*CodeModule, error) {
+	if linker.released {
+		return nil, fmt.Errorf("can't load an instance of a linker after Release")
+	}
+	if first == nil || first.module == nil {
+		return nil, fmt.Errorf("can't load an instance of a module which isn't loaded")
+	}
+	if first.instanceOf != nil {
+		first = first.instanceOf
+	}
+	linker.sharedSyms = linker.instanceSharedSymbols(first)
+	instance, err := Load(linker, symPtr)
+	linker.sharedSyms = nil
+	if err != nil {
+		return nil, err
+	}
+	modulesLock.Lock()
+	instance.instanceOf = first
+	first.instances++
+	modulesLock.Unlock()
+	return instance, nil
+}
+
+// instanceSharedSymbols returns the functions and type descriptors of first which another instance can use instead of
+// its own copies. Everything else the linker lays out is local to each instance, and so is anything referring to a
+// local symbol, other than through the host binary. A local type's method offsets are relative to its own module's
+// text, so the methods of local types are local too.
+func (linker *Linker) instanceSharedSymbols(first *CodeModule) map[string]uintptr {
+	shared := map[string]uintptr{}
+	users := map[string][]string{}
+	for name, sym := range linker.symMap {
+		if sym.Offset == InvalidOffset || !linker.isSymbolReachable(name) {
+			continue
+		}
+		for _, loc := range sym.Reloc {
+			if loc.Size > 0 {
+				// Not one of the markers for the linker's dead code elimination, e.g. R_USEIFACE
+				users[loc.Sym.Name] = append(users[loc.Sym.Name], name)
+			}
+		}
+		if sym.Kind == symkind.STEXT {
+			// Functions bound on first call are patched in place, see lazy.go
+			if f, ok := first.funcs[name]; ok && !linker.options.LazyBinding {
+				shared[name] = f.entry
+			}
+		} else if isTypeSymbol(name) {
+			if addr, ok := first.dataSyms[name]; ok {
+				shared[name] = addr
+			}
+		}
+	}
+
+	var queue []string
+	local := map[string]bool{}
+	markLocal := func(name string) {
+		if !local[name] {
+			local[name] = true
+			delete(shared, name)
+			queue = append(queue, name)
+		}
+	}
+	for name, sym := range linker.symMap {
+		if _, ok := shared[name]; ok || sym.Offset == InvalidOffset || !linker.isSymbolReachable(name) ||
+			name == TLSNAME || strings.HasPrefix(name, TypeStringPrefix) {
+			continue
+		}
+		// Read-only data is only local if it refers to something which is
+		if sym.Kind == symkind.STEXT || isTypeSymbol(name) || sym.Kind != symkind.SRODATA {
+			markLocal(name)
+		}
+	}
+	for len(queue) > 0 {
+		name := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if isTypeSymbol(name) {
+			for _, loc := range linker.symMap[name].Reloc {
+				if loc.Type == reloctype.R_METHODOFF && loc.Sym.Kind == symkind.STEXT {
+					markLocal(loc.Sym.Name)
+				}
+			}
+		}
+		for _, user := range users[name] {
+			markLocal(user)
+		}
+	}
+	return shared
+}
+
+func isTypeSymbol(name string) bool {
+	return strings.HasPrefix(name, TypePrefix) && !strings.HasPrefix(name, TypeDoubleDotPrefix)
+}
+
+// typeOffTarget returns the address an offset relocation to sym (at addr) should resolve to. runtime.resolveTypeOff
+// only resolves offsets within the module holding them, so those to a type shared with another instance point at the
+// instance's own unused copy of it instead, which the module's typemap redirects to the shared one.
+func (linker *Linker) typeOffTarget(codeModule *CodeModule, sym *obj.Sym, addr uintptr) uintptr {
+	if _, shared := linker.sharedSyms[sym.Name]; !shared || sym.Kind == symkind.STEXT {
+		return addr
+	}
+	codeModule.module.typemap[typeOff(sym.Offset)] = (*_type)(unsafe.Pointer(addr))
+	linker.relocOverflow = RelocTypemap
+	return uintptr(codeModule.dataBase + sym.Offset)
+}
+
+// copySection copies section, which starts at off in the linker's layout, into the module's mapping dst. Symbols shared
+// with another instance are left out, so their pages are never committed.
+func (linker *Linker) copySection(dst, section []byte, off int, text bool) {
+	if len(linker.sharedSyms) == 0 {
+		copy(dst[off:], section)
+		return
+	}
+	for name, sym := range linker.symMap {
+		start := sym.Offset - off
+		if _, shared := linker.sharedSyms[name]; shared || sym.Offset == InvalidOffset || (sym.Kind == symkind.STEXT) != text ||
+			start < 0 || start >= len(section) {
+			continue
+		}
+		end := start + sym.Size
+		if end > len(section) {
+			end = len(section)
+		}
+		copy(dst[sym.Offset:], section[start:end])
+	}
+}
@@ -0,0 +1,184 @@
+package goloader
+
+import (
+	"sort"
+
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// SymbolKind is the section of a module a symbol was placed in
+type SymbolKind int
+
+const (
+	SymbolText SymbolKind = iota
+	SymbolData
+	SymbolBSS
+	SymbolRodata
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case SymbolText:
+		return "text"
+	case SymbolData:
+		return "data"
+	case SymbolBSS:
+		return "bss"
+	case SymbolRodata:
+		return "rodata"
+	}
+	return "unknown"
+}
+
+// Symbol describes a symbol placed in a module's text or data
+type Symbol struct {
+	Name    string
+	Package string
+	Kind    SymbolKind
+	Addr    uintptr
+	Size    uintptr // For functions, this includes any relocation epilogues
+}
+
+// PackageSize totals the sizes of a package's symbols in a module
+type PackageSize struct {
+	Package string
+	Text    uintptr
+	Data    uintptr
+	BSS     uintptr
+	Rodata  uintptr
+}
+
+func (s PackageSize) Total() uintptr {
+	return s.Text + s.Data + s.BSS + s.Rodata
+}
+
+// buildSymbols records every symbol placed in the module, sorted by address. Symbols resolved to the host binary or
+// another module aren't included.
+func (linker *Linker) buildSymbols(codeModule *CodeModule, symbolMap map[string]uintptr) {
+	codeStart := uintptr(codeModule.codeBase)
+	codeEnd := codeStart + uintptr(codeModule.codeLen)
+	dataStart := uintptr(codeModule.dataBase)
+	bssStart := dataStart + uintptr(codeModule.dataLen+codeModule.noptrdataLen)
+	rodataStart := dataStart + uintptr(codeModule.rodataOff)
+	dataEnd := rodataStart + uintptr(codeModule.rodataLen)
+	symbols := make([]Symbol, 0, len(linker.symMap))
+	for name, sym := range linker.symMap {
+		addr, ok := symbolMap[name]
+		if !ok || sym.Offset < 0 {
+			continue
+		}
+		symbol := Symbol{Name: name, Package: sym.Pkg, Addr: addr, Size: uintptr(sym.Size)}
+		switch {
+		case sym.Kind == symkind.STEXT:
+			if addr < codeStart || addr >= codeEnd {
+				continue
+			}
+			symbol.Kind = SymbolText
+		case addr >= dataStart && addr < bssStart:
+			symbol.Kind = SymbolData
+		case addr >= bssStart && addr < rodataStart:
+			symbol.Kind = SymbolBSS
+		case addr >= rodataStart && addr < dataEnd:
+			symbol.Kind = SymbolRodata
+		default:
+			continue
+		}
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Addr != symbols[j].Addr {
+			return symbols[i].Addr < symbols[j].Addr
+		}
+		return symbols[i].Name < symbols[j].Name
+	})
+	codeModule.symbols = symbols
+}
+
+// Symbols returns the symbols placed in the module's text and data, sorted by address
+func (cm *CodeModule) Symbols() []Symbol {
+	return append([]Symbol(nil), cm.symbols...)
+}
+
+// PackageSizes totals the sizes of the module's symbols by package, sorted by package path
+func (cm *CodeModule) PackageSizes() []PackageSize {
+	byPkg := map[string]*PackageSize{}
+	for _, symbol := range cm.symbols {
+		size := byPkg[symbol.Package]
+		if size == nil {
+			size = &PackageSize{Package: symbol.Package}
+			byPkg[symbol.Package] = size
+		}
+		switch symbol.Kind {
+		case SymbolText:
+			size.Text += symbol.Size
+		case SymbolData:
+			size.Data += symbol.Size
+		case SymbolBSS:
+			size.BSS += symbol.Size
+		case SymbolRodata:
+			size.Rodata += symbol.Size
+		}
+	}
+	sizes := make([]PackageSize, 0, len(byPkg))
+	for _, size := range byPkg {
+		sizes = append(sizes, *size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].Package < sizes[j].Package })
+	return sizes
+}
+
+// symbolAt returns the symbol containing addr, if any
+func (cm *CodeModule) symbolAt(addr uintptr) (Symbol, bool) {
+	i := sort.Search(len(cm.symbols), func(i int) bool { return cm.symbols[i].Addr > addr }) - 1
+	// Only the nearest symbol below addr, and any others at the same address, can contain it
+	for j := i; j >= 0 && cm.symbols[j].Addr == cm.symbols[i].Addr; j-- {
+		if symbol := cm.symbols[j]; addr < symbol.Addr+symbol.Size || addr == symbol.Addr {
+			return symbol, true
+		}
+	}
+	return Symbol{}, false
+}
+
+func (cm *CodeModule) contains(addr uintptr) bool {
+	codeStart, dataStart := uintptr(cm.codeBase), uintptr(cm.dataBase)
+	return (addr >= codeStart && addr < codeStart+uintptr(len(cm.codeByte))) ||
+		(addr >= dataStart && addr < dataStart+uintptr(len(cm.dataByte)))
+}
+
+// Modules returns the currently loaded modules, in order of their text addresses
+func Modules() []*CodeModule {
+	modulesLock.Lock()
+	loaded := make([]*CodeModule, 0, len(modules))
+	for cm := range modules {
+		loaded = append(loaded, cm)
+	}
+	modulesLock.Unlock()
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].codeBase < loaded[j].codeBase })
+	return loaded
+}
+
+// ModuleForPC returns the loaded module whose text contains pc, or nil
+func ModuleForPC(pc uintptr) *CodeModule {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+	for cm := range modules {
+		if pc >= uintptr(cm.codeBase) && pc < uintptr(cm.codeBase)+uintptr(len(cm.codeByte)) {
+			return cm
+		}
+	}
+	return nil
+}
+
+// SymbolForAddr maps a PC or data pointer back to the loaded module and symbol containing it. If addr is in a module
+// but not within any of its symbols (e.g. in padding or relocation trampolines), the module is still returned.
+func SymbolForAddr(addr uintptr) (*CodeModule, Symbol, bool) {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+	for cm := range modules {
+		if cm.contains(addr) {
+			symbol, ok := cm.symbolAt(addr)
+			return cm, symbol, ok
+		}
+	}
+	return nil, Symbol{}, false
+}
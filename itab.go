@@ -1,6 +1,7 @@
 package goloader
 
 import (
+	"cmd/objfile/sys"
 	"fmt"
 	"github.com/eihigh/goloader/mprotect"
 	"runtime"
@@ -111,7 +112,31 @@ imethods:
 	}
 }
 
-func (cm *CodeModule) patchTypeMethodOffsets(t *_type, u, prevU *uncommonType, patchedTypeMethodsIfn, patchedTypeMethodsTfn map[*_type]map[int]struct{}, patchedTypeMethodsMtyp map[*_type]map[int]typeOff) (err error) {
+// hostMethodTextOff is firstModuleTextOff for a method in the module's text, which if the module was mapped out of the
+// host's range is reached through a trampoline near the host instead
+func (cm *CodeModule) hostMethodTextOff(arch *sys.Arch, t *_type, entry uintptr) (textOff, error) {
+	off, err := firstModuleTextOff(t, entry)
+	if err == nil || !cm.mappedAnywhere {
+		return off, err
+	}
+	trampoline, err2 := cm.hostMethodTrampoline(arch, entry)
+	if err2 != nil {
+		return 0, fmt.Errorf("%w (and failed to map a trampoline within its range: %v)", err, err2)
+	}
+	return firstModuleTextOff(t, trampoline)
+}
+
+// firstModuleTextOff returns the offset of a method of t at entry from the firstmodule's text base, which the runtime
+// treats as unsigned, so only text from the firstmodule's text base up to 4GB above it can be reached
+func firstModuleTextOff(t *_type, entry uintptr) (textOff, error) {
+	if entry < firstmoduledata.text || uint64(entry-firstmoduledata.text) >= 1<<32-1 {
+		return 0, fmt.Errorf("%w: can't point a method of %s at 0x%x, since a method offset from the host binary's text at 0x%x can't reach it",
+			ErrOutOfRangeOfHost, _name(t.nameOff(t.str)), entry, firstmoduledata.text)
+	}
+	return textOff(entry - firstmoduledata.text), nil
+}
+
+func (cm *CodeModule) patchTypeMethodOffsets(arch *sys.Arch, t *_type, u, prevU *uncommonType, patchedTypeMethodsIfn, patchedTypeMethodsTfn map[*_type]map[int]struct{}, patchedTypeMethodsMtyp map[*_type]map[int]typeOff) (err error) {
 	// It's possible that a baked in type in the main module does not have all its methods reachable
 	// (i.e. some method offsets will be set to -1 via the linker's reachability analysis) whereas the
 	// new type will have them them all.
@@ -139,15 +164,18 @@ func (cm *CodeModule) patchTypeMethodOffsets(t *_type, u, prevU *uncommonType, p
 						if _, ok := patchedTypeMethodsIfn[t]; !ok {
 							patchedTypeMethodsIfn[t] = map[int]struct{}{}
 						}
+						// The JIT type's ifn would have been offset with respect to the new type's module's text base.
+						// Since we're manipulating the firstmodule's type's methods, we need to recompute the offset with respect to the firstmodule's text base
+						ifn, err2 := cm.hostMethodTextOff(arch, t, cm.module.text+uintptr(prevMethods[i].ifn))
+						if err2 != nil {
+							return err2
+						}
 						page := mprotect.GetPage(uintptr(unsafe.Pointer(&methods[i].ifn)))
 						err = mprotect.MprotectMakeWritable(page)
 						if err != nil {
 							return fmt.Errorf("failed to make page writeable while patching type %s %p: %w", _name(t.nameOff(t.str)), unsafe.Pointer(&methods[i].ifn), err)
 						}
-						// The JIT type's ifn would have been offset with respect to the new type's module's text base.
-						// Since we're manipulating the firstmodule's type's methods, we need to recompute the offset with respect to the firstmodule's text base
-						methodEntry := cm.module.text + uintptr(prevMethods[i].ifn)
-						methods[i].ifn = textOff(methodEntry - firstmoduledata.text)
+						methods[i].ifn = ifn
 						err = mprotect.MprotectMakeReadOnly(page)
 						if err != nil {
 							return fmt.Errorf("failed to make page read only while patching type %s: %w", _name(t.nameOff(t.str)), err)
@@ -160,16 +188,19 @@ func (cm *CodeModule) patchTypeMethodOffsets(t *_type, u, prevU *uncommonType, p
 						if _, ok := patchedTypeMethodsTfn[t]; !ok {
 							patchedTypeMethodsTfn[t] = map[int]struct{}{}
 						}
+						// The JIT type's tfn would have been offset with respect to the new type's module's text base.
+						// Since we're manipulating the firstmodule's type's methods, we need to recompute the offset with respect to the firstmodule's text base
+						tfn, err2 := cm.hostMethodTextOff(arch, t, cm.module.text+uintptr(prevMethods[i].tfn))
+						if err2 != nil {
+							return err2
+						}
 						page := mprotect.GetPage(uintptr(unsafe.Pointer(&methods[i].tfn)))
 						err = mprotect.MprotectMakeWritable(page)
 						if err != nil {
 							return fmt.Errorf("failed to make page writeable while patching type %s: %w", _name(t.nameOff(t.str)), err)
 						}
 
-						// The JIT type's tfn would have been offset with respect to the new type's module's text base.
-						// Since we're manipulating the firstmodule's type's methods, we need to recompute the offset with respect to the firstmodule's text base
-						methodEntry := cm.module.text + uintptr(prevMethods[i].tfn)
-						methods[i].tfn = textOff(methodEntry - firstmoduledata.text)
+						methods[i].tfn = tfn
 						err = mprotect.MprotectMakeReadOnly(page)
 						if err != nil {
 							return fmt.Errorf("failed to make page read only while patching type %s: %w", _name(t.nameOff(t.str)), err)
@@ -254,7 +285,7 @@ func sortInts(m map[int]struct{}) []int {
 	return sortedInts
 }
 
-func patchTypeMethodTextPtrs(codeBase uintptr, patchedTypeMethodsIfn, patchedTypeMethodsTfn map[*_type]map[int]struct{}) (err error) {
+func patchTypeMethodTextPtrs(codeBase uintptr, patchedTypeMethodsIfn, patchedTypeMethodsTfn map[*_type]map[int]struct{}) (patched int, err error) {
 	// Adjust the main module's itabs so that any missing methods now point to new module's text instead of "unreachable code".
 
 	firstModule := activeModules()[0]
@@ -268,7 +299,7 @@ func patchTypeMethodTextPtrs(codeBase uintptr, patchedTypeMethodsIfn, patchedTyp
 			if _, ok := writeablePages[&page[0]]; !ok {
 				err = mprotect.MprotectMakeWritable(page)
 				if err != nil {
-					return fmt.Errorf("failed to make page writeable while re-initing itab for type %s %p: %w", _name(itab._type.nameOff(itab._type.str)), unsafe.Pointer(&itab.fun[0]), err)
+					return patched, fmt.Errorf("failed to make page writeable while re-initing itab for type %s %p: %w", _name(itab._type.nameOff(itab._type.str)), unsafe.Pointer(&itab.fun[0]), err)
 				}
 				writeablePages[&page[0]] = struct{}{}
 			}
@@ -278,6 +309,7 @@ func patchTypeMethodTextPtrs(codeBase uintptr, patchedTypeMethodsIfn, patchedTyp
 			if tfnPatched {
 				itab.adjustMethods(codeBase, methodIndicesTfn, writeablePages)
 			}
+			patched++
 
 		}
 	}
@@ -285,10 +317,10 @@ func patchTypeMethodTextPtrs(codeBase uintptr, patchedTypeMethodsIfn, patchedTyp
 	for pageStart := range writeablePages {
 		err = mprotect.MprotectMakeReadOnly(mprotect.GetPage(uintptr(unsafe.Pointer(pageStart))))
 		if err != nil {
-			return fmt.Errorf("failed to make page %p read only while re-initing itab : %w", pageStart, err)
+			return patched, fmt.Errorf("failed to make page %p read only while re-initing itab : %w", pageStart, err)
 		}
 	}
-	return nil
+	return patched, nil
 }
 
 func (cm *CodeModule) revertPatchedTypeMethods() error {
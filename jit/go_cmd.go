@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -111,6 +112,17 @@ type PackageError struct {
 	Err         string   // the error itself
 }
 
+func (pkg *Package) goFilePaths() []string {
+	paths := make([]string, 0, len(pkg.GoFiles)+len(pkg.CgoFiles))
+	for _, file := range pkg.GoFiles {
+		paths = append(paths, filepath.Join(pkg.Dir, file))
+	}
+	for _, file := range pkg.CgoFiles {
+		paths = append(paths, filepath.Join(pkg.Dir, file))
+	}
+	return paths
+}
+
 func GoModDownload(goCmd, workDir string, verbose bool, args ...string) error {
 	if verbose {
 		args = append([]string{"-x"}, args...)
@@ -209,3 +221,38 @@ func GoList(goCmd, absPath, workDir string, verbose bool) (*Package, error) {
 	}
 	return &pkg, nil
 }
+
+func GoListDeps(goCmd, absPath, workDir string, verbose bool) ([]*Package, error) {
+	args := []string{"list", "-json", "-deps"}
+	if verbose {
+		args = append(args, "-x")
+	}
+	args = append(args, absPath)
+	golistCmd := exec.Command(goCmd, args...)
+	golistCmd.Dir = workDir
+
+	stdoutBuf, stdErrBuf := &bytes.Buffer{}, &bytes.Buffer{}
+
+	if verbose {
+		golistCmd.Stderr = io.MultiWriter(stdErrBuf, os.Stderr)
+	} else {
+		golistCmd.Stderr = stdErrBuf
+	}
+	golistCmd.Stdout = stdoutBuf
+
+	err := golistCmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run 'go list -json -deps %s': %w\nstderr:\n%s", absPath, err, stdErrBuf.String())
+	}
+	var pkgs []*Package
+	decoder := json.NewDecoder(stdoutBuf)
+	for decoder.More() {
+		pkg := &Package{}
+		err = decoder.Decode(pkg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response of 'go list -json -deps %s': %w\nstderr:\n%s", absPath, err, stdErrBuf.String())
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
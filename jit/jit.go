@@ -109,6 +109,14 @@ type BuildConfig struct {
 	SkipTypeDeduplicationForPackages []string
 	UnsafeBlindlyUseFirstmoduleTypes bool
 	Dynlink                          bool
+	NoWriteProtection                bool   // Leave text RWX and type descriptors writable after load - only useful for debugging, other data is never protected
+	LazyBinding                      bool   // Relocate each function on its first call rather than at load (amd64 only)
+	RegisterWithDebuggers            bool   // Describe loaded modules to debuggers through GDB's JIT interface
+	PerfMap                          bool   // Append loaded functions to /tmp/perf-<pid>.map
+	PerfJITDumpDir                   string // If set, record loaded functions in a jitdump in this directory for perf inject
+	RecordRelocations                bool   // Keep a record of every relocation applied, see CodeModule.RelocRecords
+	ReleaseLinker                    bool   // Release the unit's linker once it has loaded successfully, see LoadableUnit.ReleaseLinker
+	FunctionPatchSpace               int    // Bytes reserved after each loaded module for goloader.PatchFunctions
 }
 
 func mergeBuildFlags(extraBuildFlags []string, dynlink bool) []string {
@@ -173,7 +181,7 @@ func execBuild(config BuildConfig, workDir, outputFilePath string, targets []str
 	return nil
 }
 
-func resolveDependencies(config BuildConfig, workDir, buildDir string, outputFilePath, packageName string, pkg *Package, linkerOpts []goloader.LinkerOptFunc, stdLibPkgs map[string]struct{}) (*goloader.Linker, error) {
+func resolveDependencies(config BuildConfig, workDir, buildDir string, outputFilePath, packageName string, pkg *Package, linkerOpts []goloader.LinkerOptFunc, stdLibPkgs map[string]struct{}, stats *goloader.LoadStats, report *RebuildReport) (*goloader.Linker, error) {
 	// Now check whether all imported packages are available in the main binary, otherwise we need to build and load them too
 	linker, err := goloader.ReadObjs([]string{outputFilePath}, []string{packageName}, globalSymPtr, linkerOpts...)
 
@@ -181,8 +189,10 @@ func resolveDependencies(config BuildConfig, workDir, buildDir string, outputFil
 		return nil, fmt.Errorf("could not read symbols from object file '%s': %w", outputFilePath, err)
 	}
 
+	endPhase := stats.StartPhase("resolve dependencies")
+	defer func() { endPhase() }()
 	globalMutex.Lock()
-	externalSymbols := linker.UnresolvedExternalSymbols(globalSymPtr, config.SkipTypeDeduplicationForPackages, stdLibPkgs, config.UnsafeBlindlyUseFirstmoduleTypes)
+	externalSymbols, reasons := linker.UnresolvedExternalSymbolReasons(globalSymPtr, config.SkipTypeDeduplicationForPackages, stdLibPkgs, config.UnsafeBlindlyUseFirstmoduleTypes)
 	externalSymbolsWithoutSkip := linker.UnresolvedExternalSymbols(globalSymPtr, nil, stdLibPkgs, config.UnsafeBlindlyUseFirstmoduleTypes)
 	externalPackages := linker.UnresolvedPackageReferences(pkg.Deps)
 	globalMutex.Unlock()
@@ -205,7 +215,11 @@ func resolveDependencies(config BuildConfig, workDir, buildDir string, outputFil
 		if config.DebugLog {
 			log.Printf("%d unresolved external symbols missing from main binary, will attempt to build dependencies\n", len(externalSymbolsWithoutSkip))
 		}
-		errDeps := buildAndLoadDeps(config, workDir, buildDir, sortedDeps, externalSymbols, externalSymbolsWithoutSkip, seen, &depImportPaths, &depBinaries, 0, linkerOpts, stdLibPkgs)
+		endPhase()
+		errDeps := buildAndLoadDeps(config, workDir, buildDir, sortedDeps, externalSymbols, externalSymbolsWithoutSkip, reasons, seen, &depImportPaths, &depBinaries, 0, linkerOpts, stdLibPkgs, stats, report)
+		// The first linker was only needed to find what's missing, so drop its object state and archive mappings
+		stats.AddPhase("resolve dependencies", linker.Stats().Phase("read objects"))
+		_ = linker.Release()
 		if errDeps != nil {
 			return nil, errDeps
 		}
@@ -214,6 +228,7 @@ func resolveDependencies(config BuildConfig, workDir, buildDir string, outputFil
 		if err != nil {
 			return nil, fmt.Errorf("could not read symbols from dependency object files '%s': %w", depImportPaths, err)
 		}
+		endPhase = stats.StartPhase("resolve dependencies")
 
 		requiredBy := depsLinker.UnresolvedExternalSymbolUsers(globalSymPtr)
 		if len(requiredBy) > 0 {
@@ -222,11 +237,22 @@ func resolveDependencies(config BuildConfig, workDir, buildDir string, outputFil
 				unresolvedList = append(unresolvedList, fmt.Sprintf("%s     required by: \n    %s\n", symName, strings.Join(requiredByList, "\n    ")))
 			}
 			sort.Strings(unresolvedList)
+			_ = depsLinker.Release()
 			return nil, fmt.Errorf("still have %d unresolved external symbols despite building and linking dependencies...: \n%s", len(requiredBy), strings.Join(unresolvedList, "\n"))
 		}
-		linker.UnloadStrings()
 		linker = depsLinker
 	}
+
+	stats.PackagesBuilt = len(depImportPaths) - 1
+	built := map[string]struct{}{}
+	for _, dep := range depImportPaths {
+		built[dep] = struct{}{}
+	}
+	for _, dep := range pkg.Deps {
+		if _, ok := built[dep]; !ok {
+			stats.PackagesReused++
+		}
+	}
 	return linker, nil
 }
 
@@ -248,8 +274,10 @@ func unescapeSymName(name string) string {
 	return name
 }
 
-func getMissingDeps(sortedDeps []string, unresolvedSymbols, unresolvedSymbolsWithoutSkip map[string]*obj.Sym, seen map[string]struct{}, debug bool) map[string]struct{} {
-	var missingDeps = map[string]struct{}{}
+// getMissingDeps returns the packages among sortedDeps which must be built to provide unresolvedSymbols, each with the
+// symbols it provides
+func getMissingDeps(sortedDeps []string, unresolvedSymbols, unresolvedSymbolsWithoutSkip map[string]*obj.Sym, seen map[string]struct{}, debug bool) map[string][]string {
+	var missingDeps = map[string][]string{}
 	unresolvedSymbolNames := make([]string, 0, len(unresolvedSymbols))
 	for symName := range unresolvedSymbols {
 		unresolvedSymbolNames = append(unresolvedSymbolNames, symName)
@@ -268,7 +296,10 @@ func getMissingDeps(sortedDeps []string, unresolvedSymbols, unresolvedSymbolsWit
 							log.Printf("main binary contains partial package '%s', but not symbol %s\n", dep, symName)
 						}
 					}
-					missingDeps[dep] = struct{}{}
+					if syms := missingDeps[dep]; len(syms) == 0 || syms[len(syms)-1] != symNameEscaped {
+						// sortedDeps may list a package twice
+						missingDeps[dep] = append(syms, symNameEscaped)
+					}
 				}
 			}
 		}
@@ -313,20 +344,28 @@ func buildAndLoadDeps(config BuildConfig,
 	workDir, buildDir string,
 	sortedDeps []string,
 	unresolvedSymbols, unresolvedSymbolsWithoutSkip map[string]*obj.Sym,
+	reasons map[string]goloader.RebuildReason,
 	seen map[string]struct{},
 	builtPackageImportPaths, buildPackageFilePaths *[]string,
 	depth int,
 	linkerOpts []goloader.LinkerOptFunc,
-	stdLibPkgs map[string]struct{}) error {
+	stdLibPkgs map[string]struct{},
+	stats *goloader.LoadStats,
+	report *RebuildReport) error {
 	const maxRecursionDepth = 150
 	if depth > maxRecursionDepth {
 		return fmt.Errorf("failed to buildAndLoadDeps: recursion depth %d exceeded maximum of %d", depth, maxRecursionDepth)
 	}
+	endPhase := stats.StartPhase("resolve dependencies")
+	defer func() { endPhase() }()
 	missingDeps := getMissingDeps(sortedDeps, unresolvedSymbols, unresolvedSymbolsWithoutSkip, seen, config.DebugLog)
 
 	if len(missingDeps) == 0 {
 		return nil
 	}
+	report.add(missingDeps, reasons, depth)
+	endPhase()
+	endPhase = stats.StartPhase("go build")
 	wg := sync.WaitGroup{}
 	var errs []error
 	var errsMutex sync.Mutex
@@ -398,6 +437,7 @@ func buildAndLoadDeps(config BuildConfig,
 		}
 	}
 	wg.Wait()
+	endPhase()
 	if len(errs) > 0 {
 		var extra string
 		if len(errs) > 1 {
@@ -405,6 +445,7 @@ func buildAndLoadDeps(config BuildConfig,
 		}
 		return fmt.Errorf("got %d during build of dependencies: %w%s", len(errs), errs[0], extra)
 	}
+	endPhase = stats.StartPhase("resolve dependencies")
 
 	linker, err := goloader.ReadObjs(*buildPackageFilePaths, *builtPackageImportPaths, globalSymPtr, linkerOpts...)
 	if err != nil {
@@ -412,13 +453,13 @@ func buildAndLoadDeps(config BuildConfig,
 	}
 
 	globalMutex.Lock()
-	nextUnresolvedSymbols := linker.UnresolvedExternalSymbols(globalSymPtr, nil, stdLibPkgs, config.UnsafeBlindlyUseFirstmoduleTypes)
+	nextUnresolvedSymbols, nextReasons := linker.UnresolvedExternalSymbolReasons(globalSymPtr, nil, stdLibPkgs, config.UnsafeBlindlyUseFirstmoduleTypes)
 	nextUnresolvedPackages := linker.UnresolvedPackageReferences(sortedDeps)
 	globalMutex.Unlock()
 
 	sortedDeps = append(sortedDeps, nextUnresolvedPackages...)
 	addCGoSymbols(nextUnresolvedSymbols)
-	linker.UnloadStrings()
+	_ = linker.Release()
 
 	if len(nextUnresolvedSymbols) > 0 {
 		var newSortedDeps []string
@@ -443,7 +484,8 @@ func buildAndLoadDeps(config BuildConfig,
 			}
 			log.Printf("Still have %d unresolved symbols \n[\n  %s\n]\n after building dependencies. Recursing further to build: \n[\n  %s\n]\n", len(nextUnresolvedSymbols), strings.Join(missingSyms, ",\n  "), strings.Join(missingList, ",\n  "))
 		}
-		return buildAndLoadDeps(config, workDir, buildDir, newSortedDeps, nextUnresolvedSymbols, nextUnresolvedSymbols, seen, builtPackageImportPaths, buildPackageFilePaths, depth+1, linkerOpts, stdLibPkgs)
+		endPhase()
+		return buildAndLoadDeps(config, workDir, buildDir, newSortedDeps, nextUnresolvedSymbols, nextUnresolvedSymbols, nextReasons, seen, builtPackageImportPaths, buildPackageFilePaths, depth+1, linkerOpts, stdLibPkgs, stats, report)
 	}
 	return nil
 }
@@ -465,6 +507,27 @@ func (config *BuildConfig) linkerOpts() []goloader.LinkerOptFunc {
 	if len(config.SkipTypeDeduplicationForPackages) > 0 {
 		linkerOpts = append(linkerOpts, goloader.WithSkipTypeDeduplicationForPackages(config.SkipTypeDeduplicationForPackages))
 	}
+	if config.NoWriteProtection {
+		linkerOpts = append(linkerOpts, goloader.WithNoWriteProtection())
+	}
+	if config.LazyBinding {
+		linkerOpts = append(linkerOpts, goloader.WithLazyBinding())
+	}
+	if config.RegisterWithDebuggers {
+		linkerOpts = append(linkerOpts, goloader.WithDebuggerRegistration())
+	}
+	if config.PerfMap {
+		linkerOpts = append(linkerOpts, goloader.WithPerfMap())
+	}
+	if config.PerfJITDumpDir != "" {
+		linkerOpts = append(linkerOpts, goloader.WithPerfJITDump(config.PerfJITDumpDir))
+	}
+	if config.RecordRelocations {
+		linkerOpts = append(linkerOpts, goloader.WithRelocationRecords())
+	}
+	if config.FunctionPatchSpace > 0 {
+		linkerOpts = append(linkerOpts, goloader.WithFunctionPatchSpace(config.FunctionPatchSpace))
+	}
 	return linkerOpts
 }
 
@@ -490,6 +553,10 @@ func BuildGoFiles(config BuildConfig, pathToGoFile string, extraFiles ...string)
 		return nil, fmt.Errorf("failed to patch gc: %w", err)
 	}
 
+	stats := &goloader.LoadStats{}
+	report := &RebuildReport{}
+	endPhase := stats.StartPhase("go list")
+	defer func() { endPhase() }()
 	if config.DebugLog {
 		log.Printf("Executing 'go list -json -x %s'\n", absPath)
 	}
@@ -559,23 +626,37 @@ func BuildGoFiles(config BuildConfig, pathToGoFile string, extraFiles ...string)
 	h.Write([]byte(strings.Join(files, "|")))
 	outputFilePath := filepath.Join(buildDir, hex.EncodeToString(h.Sum(nil))+".a")
 
+	endPhase()
+	endPhase = stats.StartPhase("go build")
 	err = execBuild(config, workDir, outputFilePath, files)
 	if err != nil {
 		return nil, err
 	}
+	endPhase()
 
 	linkerOpts := config.linkerOpts()
+	endPhase = stats.StartPhase("go list")
 	stdLibPkgs := GoListStd(config.GoBinary)
+	endPhase()
+
+	linker, err := resolveDependencies(config, workDir, buildDir, outputFilePath, pkg.ImportPath, pkg, linkerOpts, stdLibPkgs, stats, report)
+	if err != nil {
+		return nil, err
+	}
 
-	linker, err := resolveDependencies(config, workDir, buildDir, outputFilePath, pkg.ImportPath, pkg, linkerOpts, stdLibPkgs)
+	noMigrate, err := goloader.NoMigrateGlobals(pkg.ImportPath, files)
 	if err != nil {
 		return nil, err
 	}
 
 	return &LoadableUnit{
-		Linker:     linker,
-		ImportPath: pkg.ImportPath,
-		Package:    pkg,
+		Linker:           linker,
+		ImportPath:       pkg.ImportPath,
+		Package:          pkg,
+		NoMigrateGlobals: noMigrate,
+		Stats:            *stats,
+		RebuildReport:    *report,
+		ReleaseLinker:    config.ReleaseLinker,
 	}, nil
 }
 
@@ -615,6 +696,10 @@ func BuildGoText(config BuildConfig, goText string) (*LoadableUnit, error) {
 		return nil, fmt.Errorf("failed to patch gc: %w", err)
 	}
 
+	stats := &goloader.LoadStats{}
+	report := &RebuildReport{}
+	endPhase := stats.StartPhase("go list")
+	defer func() { endPhase() }()
 	if config.DebugLog {
 		log.Printf("Executing 'go list -json -x %s'\n", tmpFilePath)
 	}
@@ -661,22 +746,36 @@ func BuildGoText(config BuildConfig, goText string) (*LoadableUnit, error) {
 
 	outputFilePath := filepath.Join(buildDir, hexHash+".a")
 
+	endPhase()
+	endPhase = stats.StartPhase("go build")
 	err = execBuild(config, "", outputFilePath, []string{tmpFilePath})
 	if err != nil {
 		return nil, err
 	}
+	endPhase()
 
 	linkerOpts := config.linkerOpts()
+	endPhase = stats.StartPhase("go list")
 	stdLibPkgs := GoListStd(config.GoBinary)
-	linker, err := resolveDependencies(config, "", buildDir, outputFilePath, pkg.ImportPath, pkg, linkerOpts, stdLibPkgs)
+	endPhase()
+	linker, err := resolveDependencies(config, "", buildDir, outputFilePath, pkg.ImportPath, pkg, linkerOpts, stdLibPkgs, stats, report)
+	if err != nil {
+		return nil, err
+	}
+
+	noMigrate, err := goloader.NoMigrateGlobals(pkg.ImportPath, []string{tmpFilePath})
 	if err != nil {
 		return nil, err
 	}
 
 	return &LoadableUnit{
-		Linker:     linker,
-		ImportPath: pkg.ImportPath,
-		Package:    pkg,
+		Linker:           linker,
+		ImportPath:       pkg.ImportPath,
+		Package:          pkg,
+		NoMigrateGlobals: noMigrate,
+		Stats:            *stats,
+		RebuildReport:    *report,
+		ReleaseLinker:    config.ReleaseLinker,
 	}, nil
 }
 
@@ -701,6 +800,10 @@ func BuildGoPackage(config BuildConfig, pathToGoPackage string) (*LoadableUnit,
 		return nil, fmt.Errorf("failed to patch gc: %w", err)
 	}
 
+	stats := &goloader.LoadStats{}
+	report := &RebuildReport{}
+	endPhase := stats.StartPhase("go list")
+	defer func() { endPhase() }()
 	if config.DebugLog {
 		log.Printf("Executing 'go list -json -x %s'\n", absPath)
 	}
@@ -780,22 +883,36 @@ func BuildGoPackage(config BuildConfig, pathToGoPackage string) (*LoadableUnit,
 	outputFilePath := filepath.Join(rootBuildDir, hexHash+".a")
 
 	importPath := pkg.ImportPath
+	endPhase()
+	endPhase = stats.StartPhase("go build")
 	err = execBuild(config, absPath, outputFilePath, []string{absPath})
 	if err != nil {
 		return nil, err
 	}
+	endPhase()
 
 	linkerOpts := config.linkerOpts()
+	endPhase = stats.StartPhase("go list")
 	stdLibPkgs := GoListStd(config.GoBinary)
-	linker, err := resolveDependencies(config, absPath, rootBuildDir, outputFilePath, importPath, pkg, linkerOpts, stdLibPkgs)
+	endPhase()
+	linker, err := resolveDependencies(config, absPath, rootBuildDir, outputFilePath, importPath, pkg, linkerOpts, stdLibPkgs, stats, report)
+	if err != nil {
+		return nil, err
+	}
+
+	noMigrate, err := goloader.NoMigrateGlobals(importPath, pkg.goFilePaths())
 	if err != nil {
 		return nil, err
 	}
 
 	return &LoadableUnit{
-		Linker:     linker,
-		ImportPath: importPath,
-		Package:    pkg,
+		Linker:           linker,
+		ImportPath:       importPath,
+		Package:          pkg,
+		NoMigrateGlobals: noMigrate,
+		Stats:            *stats,
+		RebuildReport:    *report,
+		ReleaseLinker:    config.ReleaseLinker,
 	}, nil
 }
 
@@ -813,6 +930,10 @@ func BuildGoPackageRemote(config BuildConfig, goPackage string, version string)
 		return nil, fmt.Errorf("failed to get current working director: %w", err)
 	}
 
+	stats := &goloader.LoadStats{}
+	report := &RebuildReport{}
+	endPhase := stats.StartPhase("go list")
+	defer func() { endPhase() }()
 	stdLibPkgs := GoListStd(config.GoBinary)
 	_, isStdLibPkg := stdLibPkgs[goPackage]
 
@@ -908,19 +1029,31 @@ func BuildGoPackageRemote(config BuildConfig, goPackage string, version string)
 	outputFilePath := filepath.Join(rootBuildDir, hexHash+".a")
 
 	importPath := pkg.ImportPath
+	endPhase()
+	endPhase = stats.StartPhase("go build")
 	err = execBuild(config, workDir, outputFilePath, []string{goPackage})
 	if err != nil {
 		return nil, err
 	}
+	endPhase()
 	linkerOpts := config.linkerOpts()
-	linker, err := resolveDependencies(config, workDir, rootBuildDir, outputFilePath, importPath, pkg, linkerOpts, stdLibPkgs)
+	linker, err := resolveDependencies(config, workDir, rootBuildDir, outputFilePath, importPath, pkg, linkerOpts, stdLibPkgs, stats, report)
+	if err != nil {
+		return nil, err
+	}
+
+	noMigrate, err := goloader.NoMigrateGlobals(importPath, pkg.goFilePaths())
 	if err != nil {
 		return nil, err
 	}
 
 	return &LoadableUnit{
-		Linker:     linker,
-		ImportPath: importPath,
-		Package:    pkg,
+		Linker:           linker,
+		ImportPath:       importPath,
+		Package:          pkg,
+		NoMigrateGlobals: noMigrate,
+		Stats:            *stats,
+		RebuildReport:    *report,
+		ReleaseLinker:    config.ReleaseLinker,
 	}, nil
 }
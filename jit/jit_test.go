@@ -2,7 +2,11 @@ package jit_test
 
 import (
 	"bytes"
+	"context"
+	"debug/dwarf"
+	"debug/elf"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,6 +15,7 @@ import (
 	"net/http/httptest"
 	_ "net/http/pprof"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -25,8 +30,10 @@ import (
 	"unsafe"
 
 	"github.com/eihigh/goloader"
+	_ "github.com/eihigh/goloader/disasm"
 	"github.com/eihigh/goloader/jit"
 	"github.com/eihigh/goloader/jit/testdata/common"
+	"github.com/eihigh/goloader/jit/testdata/test_host_methods/host"
 	"github.com/eihigh/goloader/jit/testdata/test_issue55/p"
 	"github.com/eihigh/goloader/jit/testdata/test_type_mismatch"
 	"github.com/eihigh/goloader/jit/testdata/test_type_mismatch/typedef"
@@ -1436,6 +1443,10 @@ func TestProtobuf(t *testing.T) {
 	}
 }
 
+func BenchmarkLoadProtobuf(b *testing.B) {
+	benchmarkLoadMemory(b, baseConfig, "./testdata/test_protobuf")
+}
+
 // This test is commented to avoid adding protobuf as a dependency to the jit package purely for a test
 // TODO - split out all tests into a separate package/module so they can add dependencies more freely
 
@@ -1512,6 +1523,111 @@ func TestK8s(t *testing.T) {
 	}
 }
 
+func BenchmarkLoadK8s(b *testing.B) {
+	if runtime.GOOS == "windows" {
+		b.Skip("k8s requires golang/x/sys/windows to init")
+	}
+	conf := baseConfig
+	conf.UnsafeBlindlyUseFirstmoduleTypes = true
+	benchmarkLoadMemory(b, conf, "./testdata/test_k8s")
+}
+
+func BenchmarkReadObjsK8s(b *testing.B) {
+	files, pkgPaths := exportedArchives(b, "./testdata/test_k8s")
+	symPtr := map[string]uintptr{}
+	if err := goloader.RegSymbol(symPtr, map[string]struct{}{}); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, parallelism := range []int{1, 0} {
+		name := "Sequential"
+		if parallelism == 0 {
+			name = "Parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				linker, err := goloader.ReadObjs(files, pkgPaths, symPtr, goloader.WithReadParallelism(parallelism))
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = linker.Release()
+			}
+		})
+	}
+}
+
+// exportedArchives returns the archives of pkg and all its dependencies, dependencies first, as compiled into the build cache
+func exportedArchives(tb testing.TB, pkg string) (files, pkgPaths []string) {
+	out, err := exec.Command("go", "list", "-export", "-deps", "-json", pkg).Output()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		var p jit.Package
+		if err = decoder.Decode(&p); err != nil {
+			tb.Fatal(err)
+		}
+		if p.Export != "" {
+			files = append(files, p.Export)
+			pkgPaths = append(pkgPaths, p.ImportPath)
+		}
+	}
+	return files, pkgPaths
+}
+
+func TestReadObjsDeterministic(t *testing.T) {
+	files, pkgPaths := exportedArchives(t, "./testdata/test_json_marshal")
+	symPtr := map[string]uintptr{}
+	if err := goloader.RegSymbol(symPtr, map[string]struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	sequential, err := goloader.ReadObjs(files, pkgPaths, symPtr, goloader.WithReadParallelism(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sequential.Release()
+	for i := 0; i < 3; i++ {
+		parallel, err := goloader.ReadObjs(files, pkgPaths, symPtr, goloader.WithReadParallelism(8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sequential.SymbolOrder(), parallel.SymbolOrder()) {
+			t.Errorf("symbol order of parallel read differs from sequential read")
+		}
+		_ = parallel.Release()
+	}
+}
+
+// benchmarkLoadMemory reports the heap held by a built but not yet loaded package's linker, and the heap still held
+// once it has been loaded and the linker released
+func benchmarkLoadMemory(b *testing.B, conf jit.BuildConfig, pkg string) {
+	b.ReportAllocs()
+	conf.ReleaseLinker = true
+	var base, built, loaded runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&base)
+		loadable, err := jit.BuildGoPackage(conf, pkg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&built)
+		module, err := loadable.Load()
+		if err != nil {
+			b.Fatal(err)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&loaded)
+		if err = module.Unload(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(int64(built.HeapInuse)-int64(base.HeapInuse))/(1<<20), "linker-heap-MB")
+	b.ReportMetric(float64(int64(loaded.HeapInuse)-int64(base.HeapInuse))/(1<<20), "loaded-heap-MB")
+}
+
 func TestGCGlobals(t *testing.T) {
 	conf := baseConfig
 
@@ -1542,144 +1658,1312 @@ func TestGCGlobals(t *testing.T) {
 	}
 }
 
-func TestPprofIssue75(t *testing.T) {
+func TestMigrateGlobals(t *testing.T) {
 	conf := baseConfig
 
 	data := testData{
-		files: []string{"./testdata/test_pprof/test.go"},
-		pkg:   "./testdata/test_pprof",
+		files: []string{"./testdata/test_migrate_globals/test.go"},
+		pkg:   "./testdata/test_migrate_globals",
 	}
 	testNames := []string{"BuildGoFiles", "BuildGoPackage", "BuildGoText"}
 	for _, testName := range testNames {
 		t.Run(testName, func(t *testing.T) {
-			module, symbols := buildLoadable(t, conf, testName, data)
+			module1, symbols1 := buildLoadable(t, conf, testName, data)
+			increment1 := symbols1["Increment"].(func() int)
+			put1 := symbols1["Put"].(func(key, value string))
+			for i := 0; i < 3; i++ {
+				increment1()
+			}
+			put1("a", "first")
+			put1("a", "second")
 
-			server := http.Server{
-				Addr: ":6060",
+			module2, symbols2 := buildLoadable(t, conf, testName, data)
+			report, err := goloader.MigrateGlobals(module1, module2, goloader.MigrateOptions{})
+			if err != nil {
+				t.Fatal(err)
 			}
-			defer server.Close()
-			go func() {
-				log.Println(server.ListenAndServe())
-			}()
+			if len(report.Incompatible) > 0 {
+				t.Fatalf("expected no incompatible globals, got %v", report.Incompatible)
+			}
+			if len(report.Skipped) != 1 || !strings.HasSuffix(report.Skipped[0], ".generation") {
+				t.Fatalf("expected only generation to be skipped, got %v", report.Skipped)
+			}
+			err = module1.Unload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			runtime.GC()
 
-			keepRequesting := atomic.Value{}
-			keepRequesting.Store(true)
-			requestCount := 0
-			go func() {
-				r, err := http.NewRequest("GET", "http://localhost:6060/debug/pprof/heap?debug=1", nil)
-				if err != nil {
-					t.Fatal(err)
-				}
-				for keepRequesting.Load() == true {
-					respRec := httptest.NewRecorder()
-					http.DefaultServeMux.ServeHTTP(respRec, r)
-					if respRec.Code != 200 {
-						t.Errorf("Got a non-200 response: %d %s", respRec.Code, respRec.Body.String())
-					}
-					requestCount++
-				}
-			}()
-			testFunc := symbols["TestPprofIssue75"].(func() int)
-			for i := 0; i < 1000; i++ {
-				testFunc()
+			increment2 := symbols2["Increment"].(func() int)
+			get2 := symbols2["Get"].(func(key string) string)
+			generation2 := symbols2["Generation"].(func() int)
+			if counter := increment2(); counter != 4 {
+				t.Errorf("expected migrated counter to be 4, got %d", counter)
 			}
-			keepRequesting.Store(false)
-			fmt.Println(requestCount)
-			err := module.Unload()
+			if generation := generation2(); generation != 1 {
+				t.Errorf("expected unmigrated generation to be 1, got %d", generation)
+			}
+			if got := get2("a"); got != "second (2 updates)" {
+				t.Errorf("expected migrated cache entry 'second (2 updates)', got '%s'", got)
+			}
+			err = module2.Unload()
 			if err != nil {
 				t.Fatal(err)
 			}
-			time.Sleep(time.Millisecond * 100)
 		})
 	}
 }
 
-func TestJson(t *testing.T) {
+func TestCompareExports(t *testing.T) {
 	conf := baseConfig
 
 	data := testData{
-		files: []string{"./testdata/test_json_marshal/test.go"},
-		pkg:   "./testdata/test_json_marshal",
+		files: []string{"./testdata/test_compare_exports/test.go"},
+		pkg:   "./testdata/test_compare_exports",
 	}
-	testNames := []string{"BuildGoFiles", "BuildGoPackage", "BuildGoText"}
-	for _, testName := range testNames {
-		t.Run(testName, func(t *testing.T) {
-			module, symbols := buildLoadable(t, conf, testName, data)
 
-			testFunc := symbols["TestJSONMarshal"].(func() string)
+	originalFile, err := os.ReadFile("./testdata/test_compare_exports/test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFile, err := os.ReadFile("./testdata/test_compare_exports/test_v2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.WriteFile("./testdata/test_compare_exports/test.go", originalFile, 0655)
+	}()
 
-			if testFunc() != "1" {
-				t.Fatalf("expected \"1\" but got %s", testFunc())
-			}
-			err := module.Unload()
-			if err != nil {
-				t.Fatal(err)
-			}
-		})
+	module1, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	defer module1.Unload()
+
+	same, err := goloader.CompareExports(module1, module1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(same.Changes) != 0 {
+		t.Errorf("expected no changes comparing a module with itself, got %v", same.Changes)
+	}
+
+	err = os.WriteFile("./testdata/test_compare_exports/test.go", newFile, 0655)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module2, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	defer module2.Unload()
+
+	comparison, err := goloader.CompareExports(module1, module2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparison.Compatible() || comparison.Err() == nil {
+		t.Fatal("expected breaking changes")
+	}
+	t.Log(comparison.Err())
+
+	expected := map[string]struct {
+		change   string
+		breaking bool
+	}{
+		"Added":     {goloader.ExportAdded, false},
+		"Removed":   {goloader.ExportRemoved, true},
+		"NewConfig": {goloader.ExportFuncChanged, true},
+		"Default":   {goloader.ExportVarChanged, true},
+		"Config":    {goloader.ExportTypeChanged, true},
+		"Tagged":    {goloader.ExportTypeChanged, true},
+	}
+	for _, change := range comparison.Changes {
+		if !strings.HasSuffix(change.PkgPath, "test_compare_exports") {
+			continue
+		}
+		want, ok := expected[change.Name]
+		if !ok {
+			continue
+		}
+		delete(expected, change.Name)
+		if change.Change != want.change || change.Breaking != want.breaking {
+			t.Errorf("%s: expected %s (breaking %t), got %s (breaking %t)", change.Name, want.change, want.breaking, change.Change, change.Breaking)
+		}
+	}
+	for name := range expected {
+		t.Errorf("expected a change to be reported for %s", name)
 	}
 }
 
-func TestTypeMismatch(t *testing.T) {
+func TestPatchFunctions(t *testing.T) {
 	conf := baseConfig
-	conf.UnsafeBlindlyUseFirstmoduleTypes = false // If set to true, this test should fail (fault)
+	conf.FunctionPatchSpace = 1 << 16
 
 	data := testData{
-		files: []string{"./testdata/test_type_mismatch/test.go"},
-		pkg:   "./testdata/test_type_mismatch",
+		files: []string{"./testdata/test_patch_functions/test.go"},
+		pkg:   "./testdata/test_patch_functions",
 	}
-	// If the test shows a type deduplication bug, there will be a hard fault due to out of heap memory access
-	// We still want to run the deferred file writes to restore the testdata, so we SetPanicOnFault
-	debug.SetPanicOnFault(true)
 
-	originalFileType, err := os.ReadFile("./testdata/test_type_mismatch/typedef/typedef.go")
+	originalFile, err := os.ReadFile("./testdata/test_patch_functions/test.go")
 	if err != nil {
 		t.Fatal(err)
 	}
-	newFileType, err := os.ReadFile("./testdata/test_type_mismatch/typedef/different_type.txt")
+	newFile, err := os.ReadFile("./testdata/test_patch_functions/test_v2.txt")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		_ = os.WriteFile("./testdata/test_type_mismatch/typedef/typedef.go", originalFileType, 0655)
+		_ = os.WriteFile("./testdata/test_patch_functions/test.go", originalFile, 0655)
 	}()
 
 	module, symbols := buildLoadable(t, conf, "BuildGoPackage", data)
-	bakedIn := test_type_mismatch.New()
-	testFunc := symbols["New"].(func() *typedef.Thing)
-	dynamic := testFunc()
+	defer module.Unload()
 
-	if reflect.TypeOf(bakedIn) != reflect.TypeOf(dynamic) {
-		t.Errorf("expected types to be the same %p, %p", reflect.TypeOf(bakedIn), reflect.TypeOf(dynamic))
+	compute := symbols["Compute"].(func(int) int)
+	calls := symbols["Calls"].(func() int)
+	where := symbols["Where"].(func() string)
+	describe := reflect.ValueOf(symbols["Describe"])
+	callDescribe := func() string {
+		shape := reflect.New(describe.Type().In(0)).Elem()
+		shape.Field(0).SetString("square")
+		shape.Field(1).SetInt(4)
+		return describe.Call([]reflect.Value{shape})[0].String()
 	}
 
-	err = module.Unload()
+	if result := compute(2); result != 4 {
+		t.Fatalf("expected 4, got %d", result)
+	}
+	if result := callDescribe(); result != "square has 4 sides" {
+		t.Fatalf("expected 'square has 4 sides', got '%s'", result)
+	}
+
+	err = os.WriteFile("./testdata/test_patch_functions/test.go", newFile, 0655)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadable, err := jit.BuildGoPackage(conf, data.pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := goloader.PatchFunctions(module, loadable.Linker)
 	if err != nil {
 		t.Fatal(err)
 	}
+	sort.Strings(patch.Functions)
+	if len(patch.Functions) != 3 || !strings.HasSuffix(patch.Functions[0], ".Compute") || !strings.HasSuffix(patch.Functions[1], ".Describe") ||
+		!strings.HasSuffix(patch.Functions[2], ".Where") {
+		t.Fatalf("expected Compute, Describe and Where to be patched, got %v", patch.Functions)
+	}
 
-	// Replace the file with a new, incompatible type
-	err = os.WriteFile("./testdata/test_type_mismatch/typedef/typedef.go", newFileType, 0655)
+	// Existing function values should now reach the new bodies, sharing the module's package variables
+	if result := compute(2); result != 6 {
+		t.Errorf("expected patched result 6, got %d", result)
+	}
+	if result := calls(); result != 2 {
+		t.Errorf("expected patched function to share state, got %d calls", result)
+	}
+	if result := callDescribe(); result != "a square with 4 sides (call 2)" {
+		t.Errorf("expected 'a square with 4 sides (call 2)', got '%s'", result)
+	}
+	// The new bodies should be in the module's function tables
+	if result := where(); !strings.HasPrefix(result, "patched ") || !strings.HasSuffix(result, ".Where") {
+		t.Errorf("expected the patched Where to find itself, got '%s'", result)
+	}
+
+	err = patch.Revert()
 	if err != nil {
 		t.Fatal(err)
 	}
+	if result := compute(2); result != 4 {
+		t.Errorf("expected reverted result 4, got %d", result)
+	}
+	if result := calls(); result != 3 {
+		t.Errorf("expected 3 calls, got %d", result)
+	}
+	if result := where(); strings.HasPrefix(result, "patched ") || !strings.HasSuffix(result, ".Where") {
+		t.Errorf("expected the original Where to find itself, got '%s'", result)
+	}
+}
 
-	module2, symbols2 := buildLoadable(t, conf, "BuildGoPackage", data)
+func TestWatch(t *testing.T) {
+	conf := baseConfig
 
-	testFunc2, ok := symbols2["New"].(func() *typedef.Thing)
+	originalFile, err := os.ReadFile("./testdata/test_watch/test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFile, err := os.ReadFile("./testdata/test_watch/test_v2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	brokenFile, err := os.ReadFile("./testdata/test_watch/test_broken.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.WriteFile("./testdata/test_watch/test.go", originalFile, 0655)
+	}()
 
-	if ok {
-		t.Error("expected function type to be different")
-		dynamic2 := testFunc2() // This will probably hard fault due to the incorrect type being used in a relocation
+	type reload struct {
+		old, new *goloader.CodeModule
+	}
+	reloads := make(chan reload, 10)
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- jit.Watch(ctx, conf, "./testdata/test_watch", func(old, new *goloader.CodeModule) error {
+			reloads <- reload{old, new}
+			return nil
+		}, jit.WithPollInterval(50*time.Millisecond), jit.WithDebounce(100*time.Millisecond), jit.WithErrorHandler(func(err error) {
+			errs <- err
+		}))
+	}()
+	defer func() {
+		cancel()
+		if err := <-watchErr; err != context.Canceled {
+			t.Errorf("expected watch to end with context.Canceled, got %v", err)
+		}
+	}()
 
-		if reflect.TypeOf(bakedIn) == reflect.TypeOf(dynamic2) {
-			t.Errorf("expected types to be different %p, %p", reflect.TypeOf(bakedIn), reflect.TypeOf(dynamic2))
+	version := func(module *goloader.CodeModule) int {
+		for pkgPath, syms := range module.SymbolsByPkg {
+			if strings.HasSuffix(pkgPath, "test_watch") {
+				return syms["Version"].(func() int)()
+			}
 		}
-	} else {
-		testFunc3 := reflect.ValueOf(symbols2["New"])
-		result := testFunc3.Call(nil)
+		t.Fatal("could not find Version symbol")
+		return 0
+	}
+	waitForReload := func() reload {
+		select {
+		case r := <-reloads:
+			return r
+		case err := <-errs:
+			t.Fatalf("unexpected watch error: %s", err)
+		case <-time.After(2 * time.Minute):
+			t.Fatal("timed out waiting for reload")
+		}
+		return reload{}
+	}
 
-		if reflect.TypeOf(bakedIn) == result[0].Type() {
-			t.Errorf("expected types to be different %p, %p", reflect.TypeOf(bakedIn), result[0].Type())
+	initial := waitForReload()
+	if initial.old != nil {
+		t.Fatal("expected no old module for the initial build")
+	}
+	if v := version(initial.new); v != 1 {
+		t.Fatalf("expected version 1, got %d", v)
+	}
+
+	err = os.WriteFile("./testdata/test_watch/test.go", brokenFile, 0655)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		t.Log(err)
+	case r := <-reloads:
+		t.Fatalf("expected broken build not to reload, got new module %p", r.new)
+	case <-time.After(2 * time.Minute):
+		t.Fatal("timed out waiting for build error")
+	}
+	// The running version should be untouched
+	if v := version(initial.new); v != 1 {
+		t.Fatalf("expected version 1 to still be running, got %d", v)
+	}
+
+	err = os.WriteFile("./testdata/test_watch/test.go", newFile, 0655)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := waitForReload()
+	if updated.old != initial.new {
+		t.Errorf("expected old module to be the initial module")
+	}
+	if v := version(updated.new); v != 2 {
+		t.Errorf("expected version 2, got %d", v)
+	}
+}
+
+func mappingPermissions(t *testing.T, addr uintptr) string {
+	maps, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(maps), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		start, _ := strconv.ParseUint(bounds[0], 16, 64)
+		end, _ := strconv.ParseUint(bounds[1], 16, 64)
+		if uint64(addr) >= start && uint64(addr) < end {
+			return fields[1]
+		}
+	}
+	t.Fatalf("no mapping found for address 0x%x", addr)
+	return ""
+}
+
+func TestWriteProtection(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("checks page permissions via /proc/self/maps")
+	}
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, baseConfig, "BuildGoPackage", data)
+	defer module.Unload()
+	textStart, _ := module.TextAddr()
+	dataStart, _ := module.DataAddr()
+	if perms := mappingPermissions(t, textStart); perms != "r-xp" {
+		t.Errorf("expected text to be mapped r-xp, got %s", perms)
+	}
+	if perms := mappingPermissions(t, dataStart); perms != "rw-p" {
+		t.Errorf("expected data to be mapped rw-p, got %s", perms)
+	}
+	// Funcdata and the rest of the read-only data are protected along with the type descriptors
+	var funcdata int
+	for _, symbol := range module.Symbols() {
+		if symbol.Kind == goloader.SymbolRodata && !strings.HasPrefix(symbol.Name, goloader.TypePrefix) {
+			funcdata++
+			if perms := mappingPermissions(t, symbol.Addr); perms != "r--p" {
+				t.Errorf("expected %s to be mapped r--p, got %s", symbol.Name, perms)
+			}
+		}
+	}
+	if funcdata == 0 {
+		t.Errorf("expected read-only data other than type descriptors")
+	}
+	// So are the function tables, which a runtime.Func points into
+	if perms := mappingPermissions(t, uintptr(unsafe.Pointer(runtime.FuncForPC(module.Syms[pkg+".Add"])))); perms != "r--p" {
+		t.Errorf("expected function tables to be mapped r--p, got %s", perms)
+	}
+	addFunc := goloader.CastToFuncUnsafe[func(a, b int) int](module.Syms[pkg+".Add"])
+	if result := addFunc(5, 6); result != 11 {
+		t.Errorf("expected %d, got %d", 11, result)
+	}
+
+	conf := baseConfig
+	conf.NoWriteProtection = true
+	unprotected, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	defer unprotected.Unload()
+	textStart, _ = unprotected.TextAddr()
+	if perms := mappingPermissions(t, textStart); perms != "rwxp" {
+		t.Errorf("expected unprotected text to be mapped rwxp, got %s", perms)
+	}
+
+	if runtime.GOARCH == "amd64" {
+		// Binding a function on its first call only makes its own pages writable, and only until it's bound
+		conf = baseConfig
+		conf.LazyBinding = true
+		lazy, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+		defer lazy.Unload()
+		addFunc := goloader.CastToFuncUnsafe[func(a, b int) int](lazy.Syms[pkg+".Add"])
+		if result := addFunc(5, 6); result != 11 {
+			t.Errorf("expected %d, got %d", 11, result)
+		}
+		if stats := lazy.LazyBindingStats(); stats.Bound == 0 {
+			t.Errorf("expected Add to be bound on its first call, got %+v", stats)
+		}
+		textStart, textEnd := lazy.TextAddr()
+		for addr := textStart; addr < textEnd; addr += uintptr(os.Getpagesize()) {
+			if perms := mappingPermissions(t, addr); perms != "r-xp" {
+				t.Errorf("expected text at 0x%x to be mapped r-xp after binding, got %s", addr, perms)
+			}
+		}
+	}
+}
+
+func TestFarRefTrampolines(t *testing.T) {
+	// Route every eligible relocation through the shared trampolines and GOT slots, as if the targets were out of range
+	t.Setenv("GOLOADER_FORCE_TEST_RELOCATION_EPILOGUES", "1")
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, baseConfig, "BuildGoPackage", data)
+	defer module.Unload()
+	addFunc := goloader.CastToFuncUnsafe[func(a, b int) int](module.Syms[pkg+".Add"])
+	if result := addFunc(5, 6); result != 11 {
+		t.Errorf("expected %d, got %d", 11, result)
+	}
+	handleBytesFunc := goloader.CastToFuncUnsafe[func(input any) ([]byte, error)](module.Syms[pkg+".HandleBytes"])
+	bytesOut, err := handleBytesFunc([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytesOut, []byte{1, 2, 3}) {
+		t.Errorf("expected %v, got %v", []byte{1, 2, 3}, bytesOut)
+	}
+
+	stats := module.FarRefStats()
+	t.Logf("%+v", stats)
+	if stats.Trampolines == 0 || stats.GOTSlots == 0 {
+		t.Errorf("expected trampolines and GOT slots to be used, got %+v", stats)
+	}
+	if stats.UsedBytes > stats.ReservedBytes {
+		t.Errorf("used %d bytes of far reference table, but only %d were reserved", stats.UsedBytes, stats.ReservedBytes)
+	}
+}
+
+func TestMapAnywhere(t *testing.T) {
+	// Map the module wherever the OS chooses, as if nothing was free within 32 bits of the host binary
+	t.Setenv("GOLOADER_FORCE_TEST_MAP_ANYWHERE", "1")
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, baseConfig, "BuildGoPackage", data)
+	defer module.Unload()
+	if !module.FarRefStats().FarMapped {
+		t.Fatalf("expected module to be mapped out of range of the host binary")
+	}
+	addFunc := goloader.CastToFuncUnsafe[func(a, b int) int](module.Syms[pkg+".Add"])
+	if result := addFunc(5, 6); result != 11 {
+		t.Errorf("expected %d, got %d", 11, result)
+	}
+	handleBytesFunc := goloader.CastToFuncUnsafe[func(input any) ([]byte, error)](module.Syms[pkg+".HandleBytes"])
+	bytesOut, err := handleBytesFunc([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytesOut, []byte{1, 2, 3}) {
+		t.Errorf("expected %v, got %v", []byte{1, 2, 3}, bytesOut)
+	}
+
+	// The method type offsets of impl can't reach the host's func() ([]byte, error), so only resolve to it through the
+	// module's typemap, which json relies on to find that impl implements json.Marshaler
+	data = testData{
+		files: []string{"./testdata/test_json_marshal/test.go"},
+		pkg:   "./testdata/test_json_marshal",
+	}
+	jsonModule, _ := buildLoadable(t, baseConfig, "BuildGoPackage", data)
+	defer jsonModule.Unload()
+	marshal := goloader.CastToFuncUnsafe[func() string](jsonModule.Syms["github.com/eihigh/goloader/jit/testdata/test_json_marshal.TestJSONMarshal"])
+	if result := marshal(); result != "1" {
+		t.Errorf("expected impl's MarshalJSON to be used, got %s", result)
+	}
+}
+
+func TestMapAnywhereHostMethods(t *testing.T) {
+	// The host's method table for *host.Counter must point at the module's Incr, which a method offset from the host
+	// binary's text can't reach once the module is mapped out of its range
+	t.Setenv("GOLOADER_FORCE_TEST_MAP_ANYWHERE", "1")
+	data := testData{
+		files: []string{"./testdata/test_host_methods/test.go"},
+		pkg:   "./testdata/test_host_methods",
+	}
+
+	module, symbols := buildLoadable(t, baseConfig, "BuildGoPackage", data)
+	defer func() {
+		if err := module.Unload(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if !module.FarRefStats().FarMapped {
+		t.Fatalf("expected module to be mapped out of range of the host binary")
+	}
+	incr := symbols["Incr"].(func(v interface{}) int)
+	if result := incr(&host.Counter{N: 1}); result != 2 {
+		t.Errorf("expected %d, got %d", 2, result)
+	}
+}
+
+func TestLargeBSS(t *testing.T) {
+	data := testData{
+		files: []string{"./testdata/test_large_bss/test.go"},
+		pkg:   "./testdata/test_large_bss",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_large_bss"
+
+	module, _ := buildLoadable(t, baseConfig, "BuildGoPackage", data)
+	defer module.Unload()
+	fill := goloader.CastToFuncUnsafe[func(b byte) int](module.Syms[pkg+".Fill"])
+	if sum := fill(1); sum != 0 {
+		t.Errorf("expected BSS to be zeroed, got sum %d", sum)
+	}
+	if sum := fill(2); sum != 64<<20 {
+		t.Errorf("expected sum %d, got %d", 64<<20, sum)
+	}
+
+	// Pointers held in BSS must still be scanned by the GC
+	keep := goloader.CastToFuncUnsafe[func(i int) *int](module.Syms[pkg+".Keep"])
+	for i := 0; i < 1000; i++ {
+		keep(i)
+	}
+	runtime.GC()
+	runtime.GC()
+	for i := 0; i < 1000; i++ {
+		if v := *keep(i); v != i {
+			t.Errorf("expected %d, got %d", i, v)
+		}
+	}
+}
+
+func TestLazyBinding(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("lazy binding is only supported on amd64")
+	}
+	conf := baseConfig
+	conf.LazyBinding = true
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	defer module.Unload()
+	before := module.LazyBindingStats()
+	if before.Deferred == 0 {
+		t.Fatalf("expected some functions to be left unbound, got %+v", before)
+	}
+
+	// Race several goroutines to the first call of each function, including one which panics and recovers
+	handleBytesFunc := goloader.CastToFuncUnsafe[func(input any) ([]byte, error)](module.Syms[pkg+".HandleBytes"])
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bytesOut, err := handleBytesFunc([]byte{1, 2, 3})
+			if err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(bytesOut, []byte{1, 2, 3}) {
+				t.Errorf("expected %v, got %v", []byte{1, 2, 3}, bytesOut)
+			}
+			if _, err = handleBytesFunc("not bytes"); err == nil {
+				t.Errorf("expected an error from a failed type assertion")
+			}
+			runtime.GC()
+		}()
+	}
+	wg.Wait()
+
+	after := module.LazyBindingStats()
+	t.Logf("before: %+v after: %+v", before, after)
+	if after.Bound <= before.Bound || after.Bound > after.Deferred {
+		t.Errorf("expected the called functions to have been bound, got %+v", after)
+	}
+}
+
+func TestLoadInstances(t *testing.T) {
+	conf := baseConfig
+	loadable, err := jit.BuildGoPackage(conf, "./testdata/test_instances")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := loadable.LoadInstances(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_instances"
+
+	for i, instance := range instances {
+		inits := goloader.CastToFuncUnsafe[func() int](instance.Syms[pkg+".Inits"])
+		if got := inits(); got != 1 {
+			t.Errorf("expected instance %d to have run its init function once, got %d", i, got)
+		}
+		incr := goloader.CastToFuncUnsafe[func() int](instance.Syms[pkg+".Incr"])
+		for j := 1; j <= i+1; j++ {
+			if got := incr(); got != j {
+				t.Errorf("expected instance %d's counter to be %d, got %d", i, j, got)
+			}
+		}
+	}
+
+	// Values created by one instance should satisfy type assertions in another
+	newCounter := goloader.CastToFuncUnsafe[func(n int) interface{}](instances[0].Syms[pkg+".NewCounter"])
+	counterValue := goloader.CastToFuncUnsafe[func(v interface{}) int](instances[2].Syms[pkg+".CounterValue"])
+	if got := counterValue(newCounter(42)); got != 42 {
+		t.Errorf("expected a value passed between instances to keep its type, got %d", got)
+	}
+	if instances[2].Syms[pkg+".CounterValue"] != instances[0].Syms[pkg+".CounterValue"] {
+		t.Errorf("expected a function independent of package state to be shared between instances")
+	}
+	if instances[2].Syms[pkg+".Incr"] == instances[0].Syms[pkg+".Incr"] {
+		t.Errorf("expected a function using package state not to be shared between instances")
+	}
+
+	// Methods reached through type descriptors should see their own instance's state
+	for i, instance := range instances {
+		newTally := goloader.CastToFuncUnsafe[func() interface{}](instance.Syms[pkg+".NewTally"])
+		tally, ok := newTally().(interface{ Count() int })
+		if !ok {
+			t.Fatalf("expected instance %d's Tally to have a Count method", i)
+		}
+		if got := tally.Count(); got != i+1 {
+			t.Errorf("expected instance %d's Tally to count %d, got %d", i, i+1, got)
+		}
+	}
+
+	if err = instances[0].Unload(); err == nil {
+		t.Errorf("expected unloading the first instance to fail while others share its types")
+	}
+	for i := len(instances) - 1; i >= 0; i-- {
+		if err = instances[i].Unload(); err != nil {
+			t.Errorf("failed to unload instance %d: %v", i, err)
+		}
+	}
+}
+
+func TestReleaseLinker(t *testing.T) {
+	conf := baseConfig
+	conf.ReleaseLinker = true
+	loadable, err := jit.BuildGoPackage(conf, "./testdata/test_simple_func")
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, err := loadable.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := module.Unload(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err = loadable.Load(); err == nil {
+		t.Errorf("expected loading a unit again to fail once its linker is released")
+	}
+	if _, err = goloader.LoadInstance(loadable.Linker, jit.GlobalSymPtr(), module); err == nil {
+		t.Errorf("expected loading an instance to fail once the linker is released")
+	}
+}
+
+func TestDebuggerRegistration(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("debugger registration is only supported on amd64 and arm64")
+	}
+	conf := baseConfig
+	conf.RegisterWithDebuggers = true
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	object := module.DebugObject()
+	if object == nil {
+		t.Fatal("expected the module to have been registered with debuggers")
+	}
+	f, err := elf.NewFile(bytes.NewReader(object))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := module.Syms[pkg+".HandleBytes"]
+	found := false
+	for _, sym := range syms {
+		if sym.Name == pkg+".HandleBytes" {
+			found = true
+			if addr := f.Sections[sym.Section].Addr + sym.Value; addr != uint64(entry) {
+				t.Errorf("expected symbol at %x, got %x", entry, addr)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected a symbol for %s.HandleBytes", pkg)
+	}
+
+	dwarfData, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := dwarfData.Reader().Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := unit.Val(dwarf.AttrName).(string); name != pkg {
+		t.Errorf("expected the compile unit to be named after %s, got %q", pkg, name)
+	}
+	lines, err := dwarfData.LineReader(unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line dwarf.LineEntry
+	if err = lines.SeekPC(uint64(entry), &line); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(line.File.Name, "test_simple_func/test.go") || line.Line != 12 {
+		t.Errorf("expected HandleBytes to start at test_simple_func/test.go:12, got %s:%d", line.File.Name, line.Line)
+	}
+	if f.Section(".debug_frame") == nil {
+		t.Errorf("expected frame info in the debug object")
+	}
+
+	if err = module.Unload(); err != nil {
+		t.Fatal(err)
+	}
+	if module.DebugObject() != nil {
+		t.Errorf("expected the module to have been unregistered")
+	}
+}
+
+func TestPerfMap(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("perf maps are only used on linux")
+	}
+	conf := baseConfig
+	conf.PerfMap = true
+	conf.PerfJITDumpDir = t.TempDir()
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	perfMap := fmt.Sprintf("/tmp/perf-%d.map", os.Getpid())
+	defer os.Remove(perfMap)
+	line := fmt.Sprintf("%x ", module.Syms[pkg+".HandleBytes"])
+	mapData, err := os.ReadFile(perfMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(mapData), "\n"+line) && !strings.HasPrefix(string(mapData), line) {
+		t.Errorf("expected a perf map line starting %q, got:\n%s", line, mapData)
+	}
+
+	dump, err := os.ReadFile(filepath.Join(conf.PerfJITDumpDir, fmt.Sprintf("jit-%d.dump", os.Getpid())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump) < 4 || *(*uint32)(unsafe.Pointer(&dump[0])) != 0x4A695444 {
+		t.Errorf("expected a jitdump header, got %x", dump)
+	}
+	if !bytes.Contains(dump, []byte(pkg+".HandleBytes\x00")) {
+		t.Errorf("expected a jitdump record for %s.HandleBytes", pkg)
+	}
+
+	if err = module.Unload(); err != nil {
+		t.Fatal(err)
+	}
+	mapData, err = os.ReadFile(perfMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(mapData), pkg+".HandleBytes") {
+		t.Errorf("expected the module's functions to be removed from the perf map on unload, got:\n%s", mapData)
+	}
+}
+
+func TestModuleIntrospection(t *testing.T) {
+	conf := baseConfig
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	found := false
+	for _, loaded := range goloader.Modules() {
+		found = found || loaded == module
+	}
+	if !found {
+		t.Errorf("expected the module to be listed as loaded")
+	}
+
+	entry := module.Syms[pkg+".HandleBytes"]
+	var handleBytes goloader.Symbol
+	for _, symbol := range module.Symbols() {
+		if symbol.Name == pkg+".HandleBytes" {
+			handleBytes = symbol
+		}
+	}
+	if handleBytes.Addr != entry || handleBytes.Kind != goloader.SymbolText || handleBytes.Package != pkg || handleBytes.Size == 0 {
+		t.Errorf("unexpected symbol for HandleBytes: %+v", handleBytes)
+	}
+	if goloader.ModuleForPC(entry+1) != module {
+		t.Errorf("expected %x to be in the module's text", entry+1)
+	}
+	if cm, symbol, ok := goloader.SymbolForAddr(entry + handleBytes.Size - 1); !ok || cm != module || symbol.Name != handleBytes.Name {
+		t.Errorf("expected %x to be in HandleBytes, got %v %+v", entry+handleBytes.Size-1, ok, symbol)
+	}
+	var pkgSize goloader.PackageSize
+	for _, size := range module.PackageSizes() {
+		if size.Package == pkg {
+			pkgSize = size
+		}
+	}
+	if pkgSize.Text < handleBytes.Size {
+		t.Errorf("expected %s's text to be at least %d bytes, got %+v", pkg, handleBytes.Size, pkgSize)
+	}
+
+	if err := module.Unload(); err != nil {
+		t.Fatal(err)
+	}
+	if goloader.ModuleForPC(entry) != nil {
+		t.Errorf("expected no module to contain %x after unloading", entry)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	conf := baseConfig
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	defer func() {
+		if err := module.Unload(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var buf bytes.Buffer
+	err := module.Disassemble(&buf, pkg+".HandleBytes")
+	if errors.Is(err, goloader.ErrNoDisassembler) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"TEXT " + pkg + ".HandleBytes(SB)", "test.go:12", "runtime."} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected disassembly to contain %q, got:\n%s", want, out)
+		}
+	}
+	if err := module.Disassemble(&buf, pkg+".NoSuchFunc"); err == nil {
+		t.Errorf("expected an error disassembling a missing function")
+	}
+
+	buf.Reset()
+	if err := module.DisassembleAll(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "TEXT "+pkg+".Add(SB)") {
+		t.Errorf("expected the whole module's disassembly to include Add, got:\n%s", buf.String())
+	}
+}
+
+func TestRelocRecords(t *testing.T) {
+	conf := baseConfig
+	conf.RecordRelocations = true
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+	pkg := "github.com/eihigh/goloader/jit/testdata/test_simple_func"
+
+	module, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	defer func() {
+		if err := module.Unload(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	records := module.RelocRecords()
+	resolved := map[goloader.RelocTarget]int{}
+	for _, record := range records {
+		if record.Symbol != pkg+".HandleBytes" {
+			continue
+		}
+		resolved[record.Resolved]++
+		if record.Phase == goloader.RelocPhaseRelocate && record.Addr != module.Syms[pkg+".HandleBytes"]+uintptr(record.Offset) {
+			t.Errorf("expected relocation at offset %d of HandleBytes to be at %x, got %x", record.Offset, module.Syms[pkg+".HandleBytes"]+uintptr(record.Offset), record.Addr)
+		}
+	}
+	if resolved[goloader.RelocTargetHost] == 0 || resolved[goloader.RelocTargetSelf] == 0 {
+		t.Errorf("expected HandleBytes to have relocations to both the host and its own module, got %v", resolved)
+	}
+
+	var buf bytes.Buffer
+	if err := module.WriteRelocRecords(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(records) {
+		t.Fatalf("expected %d JSON lines, got %d", len(records), len(lines))
+	}
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first["phase"] != "relocate" || first["symbol"] == "" || first["type"] == "" || first["resolved"] == "" {
+		t.Errorf("unexpected first record: %s", lines[0])
+	}
+}
+
+func TestVerify(t *testing.T) {
+	conf := baseConfig
+	data := testData{
+		files: []string{"./testdata/test_simple_func/test.go"},
+		pkg:   "./testdata/test_simple_func",
+	}
+
+	module, _ := buildLoadable(t, conf, "BuildGoPackage", data)
+	defer func() {
+		if err := module.Unload(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for _, violation := range module.Verify() {
+		t.Errorf("unexpected violation: %s", violation)
+	}
+}
+
+func TestLoadStats(t *testing.T) {
+	conf := baseConfig
+	loadable, err := jit.BuildGoPackage(conf, "./testdata/test_simple_func")
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, err := loadable.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := module.Unload(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	stats := loadable.Stats
+	for _, phase := range []string{"go list", "go build", "read objects", "relocate", "build module", "deduplicate types", "initialize"} {
+		if stats.Phase(phase) <= 0 {
+			t.Errorf("expected time to be spent in phase %q, got %v", phase, stats.Phases)
+		}
+	}
+	if stats.PackagesBuilt != 0 || stats.PackagesReused == 0 {
+		t.Errorf("expected all dependencies to be reused from the host, got %d built and %d reused", stats.PackagesBuilt, stats.PackagesReused)
+	}
+	if stats.Symbols == 0 || stats.CodeBytes == 0 || stats.DataBytes == 0 || len(stats.Relocations) == 0 {
+		t.Errorf("expected symbols, code, data and relocations to be counted, got %+v", stats)
+	}
+	if moduleStats := module.Stats(); moduleStats.Phase("go build") != 0 || moduleStats.Phase("relocate") != stats.Phase("relocate") {
+		t.Errorf("expected module stats to cover only reading objects and loading, got %v", moduleStats.Phases)
+	}
+}
+
+func TestRebuildReport(t *testing.T) {
+	conf := baseConfig
+	loadable, err := jit.BuildGoPackage(conf, "./testdata/test_protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = loadable.Linker.Release() }()
+
+	// The host doesn't link protobuf, so its packages can only be rebuilt for missing symbols
+	pkg := loadable.RebuildReport.Package("google.golang.org/protobuf/types/known/timestamppb")
+	if pkg == nil {
+		t.Fatalf("expected timestamppb to be rebuilt, got %+v", loadable.RebuildReport)
+	}
+	if pkg.Depth != 0 || len(pkg.Reasons) != 1 || pkg.Reasons[0] != goloader.RebuildUnresolvedSymbol {
+		t.Errorf("expected timestamppb to be rebuilt for the unit's unresolved symbols, got %+v", *pkg)
+	}
+	found := false
+	for _, symbol := range pkg.Symbols {
+		found = found || symbol.Name == "google.golang.org/protobuf/types/known/timestamppb.New"
+	}
+	if !found {
+		t.Errorf("expected timestamppb.New to trigger the rebuild, got %+v", pkg.Symbols)
+	}
+	if len(loadable.RebuildReport.Packages) != loadable.Stats.PackagesBuilt {
+		t.Errorf("expected %d rebuilt packages to be reported, got %d", loadable.Stats.PackagesBuilt, len(loadable.RebuildReport.Packages))
+	}
+}
+
+func TestDependencyGraph(t *testing.T) {
+	conf := baseConfig
+	loadable, err := jit.BuildGoPackage(conf, "./testdata/test_protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = loadable.Linker.Release() }()
+
+	graph, err := loadable.DependencyGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const timestamppb = "google.golang.org/protobuf/types/known/timestamppb"
+	origins := map[string]goloader.DependencyOrigin{}
+	for _, node := range graph.Nodes {
+		origins[node.Package] = node.Origin
+		if len(node.Unresolved) > 0 {
+			t.Errorf("expected all symbols to be resolved, got %s missing %v", node.Package, node.Unresolved)
+		}
+	}
+	expected := map[string]goloader.DependencyOrigin{
+		loadable.ImportPath: goloader.DependencyModule,
+		timestamppb:         goloader.DependencyRebuilt,
+		"fmt":               goloader.DependencyHost,
+	}
+	for pkg, origin := range expected {
+		if origins[pkg] != origin {
+			t.Errorf("expected %s to come from %s, got %s", pkg, origin, origins[pkg])
+		}
+	}
+
+	var imports, refs bool
+	for _, edge := range graph.Edges {
+		if edge.From != loadable.ImportPath || edge.To != timestamppb {
+			continue
+		}
+		switch edge.Kind {
+		case goloader.DependencyImport:
+			imports = true
+		case goloader.DependencySymbolRef:
+			for _, symbol := range edge.Symbols {
+				refs = refs || symbol == timestamppb+".New"
+			}
+		}
+	}
+	if !imports || !refs {
+		t.Errorf("expected the unit to import timestamppb and refer to timestamppb.New, got import %v and reference %v", imports, refs)
+	}
+
+	var dot, js bytes.Buffer
+	if err = graph.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dot.String(), "digraph") || !strings.Contains(dot.String(), strconv.Quote(timestamppb)) {
+		t.Errorf("unexpected DOT output:\n%s", dot.String())
+	}
+	if err = graph.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Nodes []struct {
+			Package string `json:"package"`
+			Origin  string `json:"origin"`
+		} `json:"nodes"`
+	}
+	if err = json.Unmarshal(js.Bytes(), &decoded); err != nil || len(decoded.Nodes) != len(graph.Nodes) {
+		t.Errorf("expected JSON output with %d nodes, got %d (%v)", len(graph.Nodes), len(decoded.Nodes), err)
+	}
+
+	if err = loadable.Linker.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadable.DependencyGraph(); err == nil {
+		t.Errorf("expected graphing a released linker to fail")
+	}
+}
+
+func TestSizeReport(t *testing.T) {
+	conf := baseConfig
+	loadable, err := jit.BuildGoPackage(conf, "./testdata/test_protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = loadable.Linker.Release() }()
+
+	report, err := loadable.Linker.SizeReport(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total goloader.SizeBreakdown
+	var protobufText int
+	for _, pkg := range report.Packages {
+		total.Text += pkg.Text
+		total.Pcln += pkg.Pcln
+		if strings.HasPrefix(pkg.Package, "google.golang.org/protobuf/") {
+			protobufText += pkg.Text
+		}
+	}
+	if total.Text != report.Total.Text || total.Pcln != report.Total.Pcln || report.Total.Total() == 0 {
+		t.Errorf("expected package sizes to add up to the total %+v, got %+v", report.Total, total)
+	}
+	if protobufText == 0 {
+		t.Errorf("expected the rebuilt protobuf packages to have text")
+	}
+	for _, symbol := range report.Symbols[:5] {
+		if len(symbol.Roots) == 0 || len(symbol.Path) == 0 {
+			t.Errorf("expected %s to be explained, got roots %v and path %v", symbol.Name, symbol.Roots, symbol.Path)
+			continue
+		}
+		if symbol.Path[len(symbol.Path)-1] != symbol.Name || !strings.HasPrefix(symbol.Path[0], loadable.ImportPath+".") {
+			t.Errorf("expected a path from the unit's package to %s, got %v", symbol.Name, symbol.Path)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = json.NewEncoder(&buf).Encode(report); err != nil {
+		t.Fatal(err)
+	}
+	var saved goloader.SizeReport
+	if err = json.Unmarshal(buf.Bytes(), &saved); err != nil {
+		t.Fatal(err)
+	}
+	diff := goloader.DiffSizeReports(&saved, report)
+	if len(diff.Packages) != 0 || len(diff.Symbols) != 0 || diff.Total.Change() != 0 {
+		t.Errorf("expected no difference from a saved copy of the report, got %+v", diff)
+	}
+	saved.Symbols = saved.Symbols[1:]
+	diff = goloader.DiffSizeReports(&saved, report)
+	if len(diff.Symbols) != 1 || diff.Symbols[0].Name != report.Symbols[0].Name || diff.Symbols[0].Change() != report.Symbols[0].Total() {
+		t.Errorf("expected only %s to be added, got %+v", report.Symbols[0].Name, diff.Symbols)
+	}
+	buf.Reset()
+	if err = report.WriteText(&buf, 10); err != nil || !strings.Contains(buf.String(), report.Symbols[0].Name) {
+		t.Errorf("expected the largest symbol in the text report, got %v:\n%s", err, buf.String())
+	}
+
+	if err = loadable.Linker.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadable.Linker.SizeReport(0); err == nil {
+		t.Errorf("expected reporting the sizes of a released linker to fail")
+	}
+}
+
+func TestPprofIssue75(t *testing.T) {
+	conf := baseConfig
+
+	data := testData{
+		files: []string{"./testdata/test_pprof/test.go"},
+		pkg:   "./testdata/test_pprof",
+	}
+	testNames := []string{"BuildGoFiles", "BuildGoPackage", "BuildGoText"}
+	for _, testName := range testNames {
+		t.Run(testName, func(t *testing.T) {
+			module, symbols := buildLoadable(t, conf, testName, data)
+
+			server := http.Server{
+				Addr: ":6060",
+			}
+			defer server.Close()
+			go func() {
+				log.Println(server.ListenAndServe())
+			}()
+
+			keepRequesting := atomic.Value{}
+			keepRequesting.Store(true)
+			requestCount := 0
+			go func() {
+				r, err := http.NewRequest("GET", "http://localhost:6060/debug/pprof/heap?debug=1", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for keepRequesting.Load() == true {
+					respRec := httptest.NewRecorder()
+					http.DefaultServeMux.ServeHTTP(respRec, r)
+					if respRec.Code != 200 {
+						t.Errorf("Got a non-200 response: %d %s", respRec.Code, respRec.Body.String())
+					}
+					requestCount++
+				}
+			}()
+			testFunc := symbols["TestPprofIssue75"].(func() int)
+			for i := 0; i < 1000; i++ {
+				testFunc()
+			}
+			keepRequesting.Store(false)
+			fmt.Println(requestCount)
+			err := module.Unload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond * 100)
+		})
+	}
+}
+
+func TestJson(t *testing.T) {
+	conf := baseConfig
+
+	data := testData{
+		files: []string{"./testdata/test_json_marshal/test.go"},
+		pkg:   "./testdata/test_json_marshal",
+	}
+	testNames := []string{"BuildGoFiles", "BuildGoPackage", "BuildGoText"}
+	for _, testName := range testNames {
+		t.Run(testName, func(t *testing.T) {
+			module, symbols := buildLoadable(t, conf, testName, data)
+
+			testFunc := symbols["TestJSONMarshal"].(func() string)
+
+			if testFunc() != "1" {
+				t.Fatalf("expected \"1\" but got %s", testFunc())
+			}
+			err := module.Unload()
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTypeMismatch(t *testing.T) {
+	conf := baseConfig
+	conf.UnsafeBlindlyUseFirstmoduleTypes = false // If set to true, this test should fail (fault)
+
+	data := testData{
+		files: []string{"./testdata/test_type_mismatch/test.go"},
+		pkg:   "./testdata/test_type_mismatch",
+	}
+	// If the test shows a type deduplication bug, there will be a hard fault due to out of heap memory access
+	// We still want to run the deferred file writes to restore the testdata, so we SetPanicOnFault
+	debug.SetPanicOnFault(true)
+
+	originalFileType, err := os.ReadFile("./testdata/test_type_mismatch/typedef/typedef.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFileType, err := os.ReadFile("./testdata/test_type_mismatch/typedef/different_type.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.WriteFile("./testdata/test_type_mismatch/typedef/typedef.go", originalFileType, 0655)
+	}()
+
+	module, symbols := buildLoadable(t, conf, "BuildGoPackage", data)
+	bakedIn := test_type_mismatch.New()
+	testFunc := symbols["New"].(func() *typedef.Thing)
+	dynamic := testFunc()
+
+	if reflect.TypeOf(bakedIn) != reflect.TypeOf(dynamic) {
+		t.Errorf("expected types to be the same %p, %p", reflect.TypeOf(bakedIn), reflect.TypeOf(dynamic))
+	}
+
+	err = module.Unload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the file with a new, incompatible type
+	err = os.WriteFile("./testdata/test_type_mismatch/typedef/typedef.go", newFileType, 0655)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module2, symbols2 := buildLoadable(t, conf, "BuildGoPackage", data)
+
+	testFunc2, ok := symbols2["New"].(func() *typedef.Thing)
+
+	if ok {
+		t.Error("expected function type to be different")
+		dynamic2 := testFunc2() // This will probably hard fault due to the incorrect type being used in a relocation
+
+		if reflect.TypeOf(bakedIn) == reflect.TypeOf(dynamic2) {
+			t.Errorf("expected types to be different %p, %p", reflect.TypeOf(bakedIn), reflect.TypeOf(dynamic2))
+		}
+	} else {
+		testFunc3 := reflect.ValueOf(symbols2["New"])
+		result := testFunc3.Call(nil)
+
+		if reflect.TypeOf(bakedIn) == result[0].Type() {
+			t.Errorf("expected types to be different %p, %p", reflect.TypeOf(bakedIn), result[0].Type())
+		}
+
+		diffs := goloader.DiffTypes(reflect.TypeOf(bakedIn), result[0].Type())
+		if len(diffs) != 1 || diffs[0].Path != ".C" || diffs[0].Reason != goloader.TypeDiffLenChanged {
+			t.Errorf("expected a single array length difference at .C, got %v", diffs)
 		}
 	}
 
@@ -1,15 +1,33 @@
 package jit
 
 import (
+	"errors"
 	"fmt"
 	"github.com/eihigh/goloader"
 )
 
 type LoadableUnit struct {
-	Linker     *goloader.Linker
-	ImportPath string
-	Module     *goloader.CodeModule
-	Package    *Package
+	Linker           *goloader.Linker
+	ImportPath       string
+	Module           *goloader.CodeModule
+	Package          *Package
+	NoMigrateGlobals []string           // Package level variables marked with goloader.NoMigrateMarker
+	Stats            goloader.LoadStats // Of building the unit, and once loaded of its first (or only) module
+	RebuildReport    RebuildReport      // Dependencies built from source because the host binary lacked them
+	// ReleaseLinker releases Linker once Load or LoadInstances succeeds, dropping its parsed objects and archive mappings
+	// rather than keeping them alive for as long as the unit is. The unit can't be loaded or inspected again after that.
+	ReleaseLinker bool
+}
+
+// DependencyGraph returns the graph of the unit's packages and their dependencies, see goloader.Linker.DependencyGraph.
+// It fails once the unit's linker is released, see ReleaseLinker.
+func (l *LoadableUnit) DependencyGraph() (*goloader.DependencyGraph, error) {
+	if l == nil || l.Linker == nil {
+		return nil, fmt.Errorf("can't graph nil LoadableUnit")
+	}
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+	return l.Linker.DependencyGraph(globalSymPtr)
 }
 
 func (l *LoadableUnit) Load() (module *goloader.CodeModule, err error) {
@@ -18,10 +36,67 @@ func (l *LoadableUnit) Load() (module *goloader.CodeModule, err error) {
 	}
 	module, err = goloader.Load(l.Linker, globalSymPtr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load linker: %w", err)
+		return nil, fmt.Errorf("failed to load linker: %w", explainLoadError(err))
+	}
+	if l.ReleaseLinker {
+		_ = l.Linker.Release()
 	}
 
+	module.ExcludeFromMigration(l.NoMigrateGlobals...)
 	l.Module = module
+	l.Stats.Merge(module.Stats())
 
 	return module, nil
 }
+
+// LoadInstances loads n instances of the unit, each with its own package level state, but sharing the text and types
+// which don't depend on it, so that values of those types can be passed between them (see goloader.LoadInstance). The unit is only read and built once, and l.Module is set to
+// the first instance, which must be unloaded after the others.
+func (l *LoadableUnit) LoadInstances(n int) (modules []*goloader.CodeModule, err error) {
+	if l == nil || l.Linker == nil {
+		return nil, fmt.Errorf("can't load nil LoadableUnit")
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("can't load %d instances", n)
+	}
+	first, err := goloader.Load(l.Linker, globalSymPtr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load linker: %w", explainLoadError(err))
+	}
+	modules = append(modules, first)
+	for len(modules) < n {
+		instance, err := goloader.LoadInstance(l.Linker, globalSymPtr, first)
+		if err != nil {
+			err = fmt.Errorf("failed to load instance %d: %w", len(modules), explainLoadError(err))
+			for i := len(modules) - 1; i >= 0; i-- {
+				if err2 := modules[i].Unload(); err2 != nil {
+					err = fmt.Errorf("%w (and failed to unload instance %d: %v)", err, i, err2)
+				}
+			}
+			return nil, err
+		}
+		modules = append(modules, instance)
+	}
+
+	for _, module := range modules {
+		module.ExcludeFromMigration(l.NoMigrateGlobals...)
+	}
+	l.Module = first
+	l.Stats.Merge(first.Stats())
+	if l.ReleaseLinker {
+		_ = l.Linker.Release()
+	}
+	return modules, nil
+}
+
+// explainLoadError adds the likely cause and remedy to errors which don't make them obvious
+func explainLoadError(err error) error {
+	if errors.Is(err, goloader.ErrOutOfRangeOfHost) {
+		// Building or loading the unit again won't help, since the address space near the host binary is still taken
+		return fmt.Errorf("%w (no address space was free near the host binary, see mmap.ArenaUsage, so the unit was "+
+			"mapped out of its range, but the unit calls methods of host types which the host's linker removed as "+
+			"unused, and not even a page for trampolines to the unit's copies could be mapped above the host's text. "+
+			"Using the methods in the host binary keeps them)", err)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package jit
+
+import (
+	"sort"
+
+	"github.com/eihigh/goloader"
+)
+
+// RebuildReport lists the dependencies of a LoadableUnit which were built from source because the host binary couldn't
+// provide them, and the symbols which made each necessary. Including the packages with the most triggering symbols in
+// the host binary is usually what avoids the rebuilds.
+type RebuildReport struct {
+	Packages []RebuiltPackage `json:"packages"` // In the order they were found to be missing
+}
+
+// RebuiltPackage is a dependency which was built and loaded along with a LoadableUnit
+type RebuiltPackage struct {
+	ImportPath string                   `json:"importPath"`
+	Depth      int                      `json:"depth"`   // 0 if needed by the unit itself, otherwise by a package rebuilt at Depth-1
+	Reasons    []goloader.RebuildReason `json:"reasons"` // Those of Symbols, without duplicates
+	Symbols    []RebuildSymbol          `json:"symbols"` // Sorted by name
+}
+
+// RebuildSymbol is a symbol which the host binary couldn't provide, forcing the package defining it to be rebuilt
+type RebuildSymbol struct {
+	Name   string                 `json:"name"`
+	Reason goloader.RebuildReason `json:"reason"`
+}
+
+// Package returns the named rebuilt package, or nil if it wasn't rebuilt
+func (r *RebuildReport) Package(importPath string) *RebuiltPackage {
+	for i := range r.Packages {
+		if r.Packages[i].ImportPath == importPath {
+			return &r.Packages[i]
+		}
+	}
+	return nil
+}
+
+// add records missing deps, each with the unresolved symbols which triggered its rebuild
+func (r *RebuildReport) add(missingDeps map[string][]string, reasons map[string]goloader.RebuildReason, depth int) {
+	depNames := make([]string, 0, len(missingDeps))
+	for dep := range missingDeps {
+		depNames = append(depNames, dep)
+	}
+	sort.Strings(depNames)
+	for _, dep := range depNames {
+		pkg := r.Package(dep)
+		if pkg == nil {
+			r.Packages = append(r.Packages, RebuiltPackage{ImportPath: dep, Depth: depth})
+			pkg = &r.Packages[len(r.Packages)-1]
+		}
+		for _, symName := range missingDeps[dep] {
+			reason := reasons[symName]
+			pkg.Symbols = append(pkg.Symbols, RebuildSymbol{Name: unescapeSymName(symName), Reason: reason})
+			seenReason := false
+			for _, existing := range pkg.Reasons {
+				seenReason = seenReason || existing == reason
+			}
+			if !seenReason {
+				pkg.Reasons = append(pkg.Reasons, reason)
+			}
+		}
+		sort.Slice(pkg.Symbols, func(i, j int) bool { return pkg.Symbols[i].Name < pkg.Symbols[j].Name })
+		sort.Slice(pkg.Reasons, func(i, j int) bool { return pkg.Reasons[i] < pkg.Reasons[j] })
+	}
+}
@@ -0,0 +1,24 @@
+package test_compare_exports
+
+type Config struct {
+	Name    string `json:"name"`
+	Retries int    `json:"retries"`
+}
+
+type Tagged struct {
+	ID string `json:"id"`
+}
+
+var Default = Config{Name: "default", Retries: 3}
+
+func NewConfig(name string) *Config {
+	return &Config{Name: name, Retries: Default.Retries}
+}
+
+func Describe(t *Tagged) string {
+	return t.ID
+}
+
+func Removed() int {
+	return 1
+}
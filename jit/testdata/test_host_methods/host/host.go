@@ -0,0 +1,11 @@
+package host
+
+// Counter is only built by the host binary, which never calls Incr, so its linker removes Incr as unused
+type Counter struct {
+	N int
+}
+
+func (c *Counter) Incr() int {
+	c.N++
+	return c.N
+}
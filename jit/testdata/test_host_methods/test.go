@@ -0,0 +1,11 @@
+package test_host_methods
+
+type incrementer interface {
+	Incr() int
+}
+
+// Incr calls v's Incr through an itab built from the host's type descriptor, whose method table only the module can
+// fill in
+func Incr(v interface{}) int {
+	return v.(incrementer).Incr()
+}
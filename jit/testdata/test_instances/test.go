@@ -0,0 +1,44 @@
+package test_instances
+
+type Counter struct {
+	N int
+}
+
+var count int
+var inits int
+
+func init() {
+	inits++
+}
+
+func Incr() int {
+	count++
+	return count
+}
+
+func Inits() int {
+	return inits
+}
+
+func NewCounter(n int) interface{} {
+	return &Counter{N: n}
+}
+
+func CounterValue(v interface{}) int {
+	c, ok := v.(*Counter)
+	if !ok {
+		return -1
+	}
+	return c.N
+}
+
+// Tally's method uses package level state, so each instance should have its own Tally type
+type Tally struct{}
+
+func (Tally) Count() int {
+	return count
+}
+
+func NewTally() interface{} {
+	return Tally{}
+}
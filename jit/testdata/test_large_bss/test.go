@@ -0,0 +1,21 @@
+package test_large_bss
+
+var buf [64 << 20]byte
+var ptrs [1 << 16]*int
+
+func Fill(b byte) int {
+	sum := 0
+	for i := range buf {
+		sum += int(buf[i])
+		buf[i] = b
+	}
+	return sum
+}
+
+func Keep(i int) *int {
+	if ptrs[i] == nil {
+		ptrs[i] = new(int)
+		*ptrs[i] = i
+	}
+	return ptrs[i]
+}
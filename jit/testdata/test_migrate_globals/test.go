@@ -0,0 +1,43 @@
+package test_migrate_globals
+
+import "fmt"
+
+type entry struct {
+	value   string
+	updates int
+}
+
+var counter int
+var cache = map[string]*entry{}
+var describe = func(e *entry) string { return fmt.Sprintf("%s (%d updates)", e.value, e.updates) }
+
+//goloader:nomigrate
+var generation int
+
+func Increment() int {
+	counter++
+	generation++
+	return counter
+}
+
+func Put(key, value string) {
+	e, ok := cache[key]
+	if !ok {
+		e = &entry{}
+		cache[key] = e
+	}
+	e.value = value
+	e.updates++
+}
+
+func Get(key string) string {
+	e, ok := cache[key]
+	if !ok {
+		return ""
+	}
+	return describe(e)
+}
+
+func Generation() int {
+	return generation
+}
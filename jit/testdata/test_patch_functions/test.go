@@ -0,0 +1,32 @@
+package test_patch_functions
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var calls int
+
+type Shape struct {
+	Name  string
+	Sides int
+}
+
+func Compute(x int) int {
+	calls++
+	return x * 2
+}
+
+func Describe(s Shape) string {
+	return fmt.Sprintf("%s has %d sides", s.Name, s.Sides)
+}
+
+func Calls() int {
+	return calls
+}
+
+// Where reports the function the runtime finds for its own PC
+func Where() string {
+	pc, _, _, _ := runtime.Caller(0)
+	return runtime.FuncForPC(pc).Name()
+}
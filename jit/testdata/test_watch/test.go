@@ -0,0 +1,5 @@
+package test_watch
+
+func Version() int {
+	return 1
+}
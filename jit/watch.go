@@ -0,0 +1,211 @@
+package jit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eihigh/goloader"
+)
+
+type watchOptions struct {
+	pollInterval time.Duration
+	debounce     time.Duration
+	onError      func(err error)
+}
+
+type WatchOption func(options *watchOptions)
+
+// WithPollInterval sets how often the watched files are checked for changes (default 500ms)
+func WithPollInterval(interval time.Duration) WatchOption {
+	return func(options *watchOptions) {
+		options.pollInterval = interval
+	}
+}
+
+// WithDebounce sets how long the watched files must be unchanged before a rebuild is started,
+// so that a burst of saves (e.g. from a formatter or a branch switch) only triggers a single rebuild (default 300ms)
+func WithDebounce(quietPeriod time.Duration) WatchOption {
+	return func(options *watchOptions) {
+		options.debounce = quietPeriod
+	}
+}
+
+// WithErrorHandler sets the function called with any error from rebuilding, loading or swapping a new version.
+// By default, errors are logged.
+func WithErrorHandler(onError func(err error)) WatchOption {
+	return func(options *watchOptions) {
+		options.onError = onError
+	}
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watch builds and loads the package at pathToGoPackage, then watches its source files (and those of any
+// dependencies not in the module cache or GOROOT) for changes. After each change it rebuilds the package with
+// BuildGoPackage, loads it, and calls onReload with the currently running module and the new one, which should
+// migrate any state across (e.g. with goloader.MigrateGlobals) and swap references to the new module's symbols.
+// Once onReload returns nil, the old module is unloaded. onReload is first called with a nil old module for the
+// initial build.
+//
+// If a rebuild, load or onReload fails, the error is passed to the handler set by WithErrorHandler, the new module
+// (if any) is unloaded, and the previous version keeps running until the next change.
+// Watch blocks until ctx is done, returning ctx.Err(), or until the initial build fails.
+// The last successfully loaded module is left loaded when Watch returns.
+func Watch(ctx context.Context, config BuildConfig, pathToGoPackage string, onReload func(old, new *goloader.CodeModule) error, opts ...WatchOption) error {
+	options := watchOptions{
+		pollInterval: 500 * time.Millisecond,
+		debounce:     300 * time.Millisecond,
+		onError: func(err error) {
+			log.Printf("goloader/jit watch: %s\n", err)
+		},
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	absPath, err := filepath.Abs(pathToGoPackage)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path at %s: %w", pathToGoPackage, err)
+	}
+	if config.GoBinary == "" {
+		config.GoBinary = "go"
+	}
+
+	current, err := reloadPackage(config, absPath, nil, onReload)
+	if err != nil {
+		return err
+	}
+	files, err := watchedFiles(config, absPath)
+	if err != nil {
+		return err
+	}
+	snapshot := statFiles(files)
+
+	ticker := time.NewTicker(options.pollInterval)
+	defer ticker.Stop()
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		latest := statFiles(files)
+		if !sameFileStates(snapshot, latest) {
+			snapshot = latest
+			lastChange = time.Now()
+			continue
+		}
+		if lastChange.IsZero() || time.Since(lastChange) < options.debounce {
+			continue
+		}
+		lastChange = time.Time{}
+
+		module, err := reloadPackage(config, absPath, current, onReload)
+		if err != nil {
+			options.onError(err)
+			continue
+		}
+		current = module
+		// Imports may have changed, so the set of local dependencies might have too
+		newFiles, err := watchedFiles(config, absPath)
+		if err != nil {
+			options.onError(err)
+			continue
+		}
+		files = newFiles
+		snapshot = statFiles(files)
+	}
+}
+
+func reloadPackage(config BuildConfig, absPath string, old *goloader.CodeModule, onReload func(old, new *goloader.CodeModule) error) (*goloader.CodeModule, error) {
+	loadable, err := BuildGoPackage(config, absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s: %w", absPath, err)
+	}
+	// The unit is never loaded again, so drop its linker even if loading fails
+	defer func() { _ = loadable.Linker.Release() }()
+	module, err := loadable.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", absPath, err)
+	}
+	err = onReload(old, module)
+	if err != nil {
+		err = fmt.Errorf("reload of %s was rejected: %w", absPath, err)
+		if err2 := module.Unload(); err2 != nil {
+			err = fmt.Errorf("%w (and failed to unload the new module: %v)", err, err2)
+		}
+		return nil, err
+	}
+	if old != nil {
+		err = old.Unload()
+		if err != nil {
+			// The new module is now in use, so the swap still succeeded
+			log.Printf("goloader/jit watch: failed to unload previous version of %s: %s\n", absPath, err)
+		}
+	}
+	return module, nil
+}
+
+// watchedFiles returns the source files and directories of the package at absPath and of all its dependencies
+// which are local to the machine (i.e. in the main module or replaced with a local directory), plus go.mod/go.sum
+func watchedFiles(config BuildConfig, absPath string) ([]string, error) {
+	pkgs, err := GoListDeps(config.GoBinary, absPath, absPath, config.DebugLog)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.Goroot || pkg.Module == nil {
+			continue
+		}
+		local := pkg.Module.Main || (pkg.Module.Replace != nil && pkg.Module.Replace.Version == "")
+		if !local {
+			continue
+		}
+		// The directory's own mtime changes when files are added, removed or renamed
+		files = append(files, pkg.Dir)
+		for _, group := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.HFiles, pkg.SFiles, pkg.SysoFiles, pkg.EmbedFiles} {
+			for _, file := range group {
+				files = append(files, filepath.Join(pkg.Dir, file))
+			}
+		}
+		if pkg.Module.GoMod != "" {
+			files = append(files, pkg.Module.GoMod, filepath.Join(filepath.Dir(pkg.Module.GoMod), "go.sum"))
+		}
+	}
+	return files, nil
+}
+
+func statFiles(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			// Deleted files are recorded as a zero state, so their removal (or reappearance) counts as a change
+			states[file] = fileState{}
+			continue
+		}
+		states[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return states
+}
+
+func sameFileStates(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, state := range a {
+		other, ok := b[file]
+		if !ok || !state.modTime.Equal(other.modTime) || state.size != other.size {
+			return false
+		}
+	}
+	return true
+}
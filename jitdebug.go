@@ -0,0 +1,764 @@
+package goloader
+
+import (
+	"cmd/objfile/sys"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/eihigh/goloader/obj"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// Loaded modules are described to debuggers through GDB's JIT interface: each module gets an in-memory ELF object with
+// a symbol table, and DWARF line, frame and inlining info derived from the same pcln tables the runtime uses. The object
+// is linked into the list hung off __jit_debug_descriptor before __jit_debug_register_code is called, which debuggers
+// set a breakpoint on, so that they can symbolise and set breakpoints in module text.
+// See https://sourceware.org/gdb/current/onlinedocs/gdb.html/JIT-Interface.html
+
+// jitCodeEntry is GDB's struct jit_code_entry
+type jitCodeEntry struct {
+	next        uintptr
+	prev        uintptr
+	symfileAddr uintptr
+	symfileSize uint64
+}
+
+// jitDescriptor is GDB's struct jit_descriptor
+type jitDescriptor struct {
+	version       uint32
+	actionFlag    uint32
+	relevantEntry uintptr
+	firstEntry    uintptr
+}
+
+const (
+	jitNoAction = iota
+	jitRegisterFn
+	jitUnregisterFn
+)
+
+var (
+	jitDebugLock    sync.Mutex
+	jitDebugEntries = map[*jitCodeEntry][]byte{} // Keeps registered entries and their objects alive, since the descriptor's list is invisible to the GC
+)
+
+// registerWithDebuggers builds the module's debug object and registers it through the JIT interface
+func (linker *Linker) registerWithDebuggers(codeModule *CodeModule, symbolMap map[string]uintptr) {
+	if !linker.options.RegisterWithDebuggers {
+		return
+	}
+	desc := jitDebugDescriptor()
+	symfile := linker.buildDebugObject(codeModule, symbolMap)
+	if desc == nil || symfile == nil {
+		return
+	}
+	entry := &jitCodeEntry{symfileAddr: uintptr(unsafe.Pointer(&symfile[0])), symfileSize: uint64(len(symfile))}
+	entryAddr := uintptr(unsafe.Pointer(entry))
+	jitDebugLock.Lock()
+	defer jitDebugLock.Unlock()
+	jitDebugEntries[entry] = symfile
+	entry.next = desc.firstEntry
+	if desc.firstEntry != 0 {
+		(*jitCodeEntry)(unsafe.Pointer(desc.firstEntry)).prev = entryAddr
+	}
+	desc.firstEntry = entryAddr
+	desc.relevantEntry = entryAddr
+	desc.actionFlag = jitRegisterFn
+	jitDebugRegisterCode()
+	desc.actionFlag = jitNoAction
+	codeModule.debugEntry = entry
+}
+
+func (cm *CodeModule) unregisterFromDebuggers() {
+	entry := cm.debugEntry
+	if entry == nil {
+		return
+	}
+	desc := jitDebugDescriptor()
+	entryAddr := uintptr(unsafe.Pointer(entry))
+	jitDebugLock.Lock()
+	defer jitDebugLock.Unlock()
+	if entry.prev != 0 {
+		(*jitCodeEntry)(unsafe.Pointer(entry.prev)).next = entry.next
+	} else {
+		desc.firstEntry = entry.next
+	}
+	if entry.next != 0 {
+		(*jitCodeEntry)(unsafe.Pointer(entry.next)).prev = entry.prev
+	}
+	desc.relevantEntry = entryAddr
+	desc.actionFlag = jitUnregisterFn
+	jitDebugRegisterCode()
+	desc.actionFlag = jitNoAction
+	desc.relevantEntry = 0
+	delete(jitDebugEntries, entry)
+	cm.debugEntry = nil
+}
+
+// DebugObject returns the in-memory ELF object describing the module to debuggers, or nil if it wasn't loaded with
+// WithDebuggerRegistration. Writing it to a file allows it to be loaded into debuggers which don't implement GDB's
+// JIT interface, e.g. with gdb's add-symbol-file.
+func (cm *CodeModule) DebugObject() []byte {
+	if cm.debugEntry == nil {
+		return nil
+	}
+	jitDebugLock.Lock()
+	defer jitDebugLock.Unlock()
+	return jitDebugEntries[cm.debugEntry]
+}
+
+// DWARF constants not provided by debug/dwarf
+const (
+	dwFormAddr        = 0x01
+	dwFormData1       = 0x0b
+	dwFormData2       = 0x05
+	dwFormData4       = 0x06
+	dwFormData8       = 0x07
+	dwFormString      = 0x08
+	dwFormRef4        = 0x13
+	dwFormSecOffset   = 0x17
+	dwFormFlagPresent = 0x19
+
+	dwLangGo     = 0x16
+	dwInlInlined = 1
+
+	dwLnsCopy        = 1
+	dwLnsAdvancePC   = 2
+	dwLnsAdvanceLine = 3
+	dwLnsSetFile     = 4
+	dwLneEndSequence = 1
+	dwLneSetAddress  = 2
+
+	dwCFAAdvanceLoc4       = 0x04
+	dwCFAOffsetExtended    = 0x05
+	dwCFASameValue         = 0x08
+	dwCFADefCFA            = 0x0c
+	dwCFAOffsetExtendedSf  = 0x11
+	dwCFADefCFAOffsetSf    = 0x13
+	dwCFAValOffset         = 0x14
+	dwCFADataAlignment     = -4
+	dwCFACodeAlignment     = 1
+	dwCIEVersion           = 3
+	dwInfoVersion          = 4
+	dwLineVersion          = 2
+	dwLineBase             = -4
+	dwLineRange            = 10
+	dwLineOpcodeBase       = 10
+	dwAbbrevCompileUnit    = 1
+	dwAbbrevSubprogram     = 2
+	dwAbbrevAbstractFunc   = 3
+	dwAbbrevInlinedRoutine = 4
+)
+
+// moduleFunc is a function in a module's text, as described to debuggers and profilers
+type moduleFunc struct {
+	name  string
+	entry uintptr
+	size  uintptr // Including any relocation epilogues
+	info  *obj.FuncInfo
+}
+
+// moduleFuncs returns the module's functions in address order, at the same entries buildModule puts into ftab
+func (linker *Linker) moduleFuncs(symbolMap map[string]uintptr) []moduleFunc {
+	funcs := make([]moduleFunc, 0, len(linker._func))
+	for _, _func := range linker._func {
+		name := gostringnocopy(&linker.funcnametab[_func.nameoff])
+		objsym, sym := linker.objsymbolMap[name], linker.symMap[name]
+		addr, ok := symbolMap[name]
+		if _, shared := linker.sharedSyms[name]; shared || objsym == nil || objsym.Func == nil || sym == nil || !ok {
+			continue
+		}
+		funcs = append(funcs, moduleFunc{name: name, entry: addr, size: uintptr(sym.Size), info: objsym.Func})
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].entry < funcs[j].entry })
+	return funcs
+}
+
+// pcRun is a range of PCs (relative to a function's entry) over which a pcvalue table has the same value
+type pcRun struct {
+	start, end uintptr
+	val        int32
+}
+
+func decodePCRuns(table []byte) []pcRun {
+	if len(table) == 0 {
+		return nil
+	}
+	var runs []pcRun
+	var pc, start uintptr
+	val := int32(-1)
+	p, ok := step(table, &pc, &val, true)
+	for ok {
+		runs = append(runs, pcRun{start: start, end: pc, val: val})
+		start = pc
+		p, ok = step(p, &pc, &val, false)
+	}
+	return runs
+}
+
+func pcRunValue(runs []pcRun, pc uintptr) int32 {
+	i := sort.Search(len(runs), func(i int) bool { return runs[i].end > pc })
+	if i == len(runs) || runs[i].start > pc {
+		return -1
+	}
+	return runs[i].val
+}
+
+// dwBuf is a buffer of ELF or DWARF data in the target's byte order
+type dwBuf struct {
+	order binary.ByteOrder
+	b     []byte
+}
+
+func (w *dwBuf) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *dwBuf) u16(v uint16) {
+	var buf [2]byte
+	w.order.PutUint16(buf[:], v)
+	w.b = append(w.b, buf[:]...)
+}
+
+func (w *dwBuf) u32(v uint32) {
+	var buf [4]byte
+	w.order.PutUint32(buf[:], v)
+	w.b = append(w.b, buf[:]...)
+}
+
+func (w *dwBuf) u64(v uint64) {
+	var buf [8]byte
+	w.order.PutUint64(buf[:], v)
+	w.b = append(w.b, buf[:]...)
+}
+
+func (w *dwBuf) uleb(v uint64) {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		w.b = append(w.b, c)
+		if c&0x80 == 0 {
+			return
+		}
+	}
+}
+
+func (w *dwBuf) sleb(v int64) {
+	for {
+		c := byte(v & 0x7f)
+		sign := c & 0x40
+		v >>= 7
+		if (v != -1 || sign == 0) && (v != 0 || sign != 0) {
+			c |= 0x80
+		}
+		w.b = append(w.b, c)
+		if c&0x80 == 0 {
+			return
+		}
+	}
+}
+
+func (w *dwBuf) str(s string) {
+	w.b = append(w.b, s...)
+	w.b = append(w.b, 0)
+}
+
+func (w *dwBuf) align(n int) {
+	for len(w.b)%n != 0 {
+		w.b = append(w.b, 0)
+	}
+}
+
+// debugObjectBuilder accumulates the DWARF describing a module's functions
+type debugObjectBuilder struct {
+	linker   *Linker
+	order    binary.ByteOrder
+	funcs    []moduleFunc
+	files    []string       // DWARF file names, numbered from 1
+	fileNums map[uint32]int // DWARF file numbers by filetab offset
+}
+
+// buildDebugObject returns an ELF object describing the module's functions and data, or nil if the architecture
+// isn't supported
+func (linker *Linker) buildDebugObject(codeModule *CodeModule, symbolMap map[string]uintptr) []byte {
+	var machine elf.Machine
+	switch linker.Arch.Family {
+	case sys.AMD64:
+		machine = elf.EM_X86_64
+	case sys.ARM64:
+		machine = elf.EM_AARCH64
+	default:
+		return nil
+	}
+	b := &debugObjectBuilder{linker: linker, order: linker.Arch.ByteOrder, funcs: linker.moduleFuncs(symbolMap), fileNums: map[uint32]int{}}
+
+	codeBase := uintptr(codeModule.codeBase)
+	dataBase := uintptr(codeModule.dataBase)
+	unitName := "goloader"
+	if len(linker.pkgs) > 0 {
+		// The module's own package is read last, after any dependencies rebuilt for it
+		unitName = linker.pkgs[len(linker.pkgs)-1].PkgPath
+	}
+	// The line program goes last, since it lists every file the others refer to
+	info := b.buildInfo(unitName, codeBase, uintptr(codeModule.codeLen))
+	frame := b.buildFrames()
+	line := b.buildLines()
+
+	// Symbols are relative to their section, while DWARF addresses are absolute, since the object's sections are
+	// placed at their addresses and it has no relocations
+	symtab := &dwBuf{order: b.order, b: make([]byte, 24)}
+	strtab := &dwBuf{order: b.order, b: []byte{0}}
+	addSym := func(name string, value, size uint64, typ elf.SymType, section uint16) {
+		symtab.u32(uint32(len(strtab.b)))
+		symtab.u8(elf.ST_INFO(elf.STB_GLOBAL, typ))
+		symtab.u8(0)
+		symtab.u16(section)
+		symtab.u64(value)
+		symtab.u64(size)
+		strtab.str(name)
+	}
+	for _, f := range b.funcs {
+		addSym(f.name, uint64(f.entry-codeBase), uint64(f.size), elf.STT_FUNC, 1)
+	}
+	var dataNames []string
+	for name, sym := range linker.symMap {
+		addr, ok := symbolMap[name]
+		if sym.Kind == symkind.STEXT || sym.Offset < 0 || !ok || addr < dataBase || addr >= dataBase+uintptr(codeModule.sumDataLen) {
+			continue
+		}
+		dataNames = append(dataNames, name)
+	}
+	sort.Strings(dataNames)
+	for _, name := range dataNames {
+		addSym(name, uint64(symbolMap[name]-dataBase), uint64(linker.symMap[name].Size), elf.STT_OBJECT, 2)
+	}
+
+	return writeELF(b.order, machine, []elfSection{
+		{name: ".text", typ: elf.SHT_NOBITS, flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, addr: uint64(codeBase), size: uint64(codeModule.codeLen), align: 16},
+		{name: ".data", typ: elf.SHT_NOBITS, flags: elf.SHF_ALLOC | elf.SHF_WRITE, addr: uint64(dataBase), size: uint64(codeModule.sumDataLen), align: 16},
+		{name: ".symtab", typ: elf.SHT_SYMTAB, data: symtab.b, link: 4, info: 1, align: 8, entsize: 24},
+		{name: ".strtab", typ: elf.SHT_STRTAB, data: strtab.b, align: 1},
+		{name: ".debug_abbrev", typ: elf.SHT_PROGBITS, data: debugAbbrevs(), align: 1},
+		{name: ".debug_info", typ: elf.SHT_PROGBITS, data: info, align: 1},
+		{name: ".debug_line", typ: elf.SHT_PROGBITS, data: line, align: 1},
+		{name: ".debug_frame", typ: elf.SHT_PROGBITS, data: frame, align: 8},
+	})
+}
+
+// fileNum returns the DWARF file number of the file at the given index in a function's compilation unit, or 0 if
+// there's no such file
+func (b *debugObjectBuilder) fileNum(f *moduleFunc, index int32) int {
+	cuIndex := f.info.CUOffset + int(index)
+	if index < 0 || cuIndex >= len(b.linker.cutab) || int(b.linker.cutab[cuIndex]) >= len(b.linker.filetab) {
+		return 0
+	}
+	off := b.linker.cutab[cuIndex]
+	num, ok := b.fileNums[off]
+	if !ok {
+		b.files = append(b.files, gostringnocopy(&b.linker.filetab[off]))
+		num = len(b.files)
+		b.fileNums[off] = num
+	}
+	return num
+}
+
+func debugAbbrevs() []byte {
+	abbrevs := &dwBuf{}
+	add := func(code int, tag dwarf.Tag, children bool, attrForms ...int) {
+		abbrevs.uleb(uint64(code))
+		abbrevs.uleb(uint64(tag))
+		if children {
+			abbrevs.u8(1)
+		} else {
+			abbrevs.u8(0)
+		}
+		for _, attrForm := range attrForms {
+			abbrevs.uleb(uint64(attrForm))
+		}
+		abbrevs.u8(0)
+		abbrevs.u8(0)
+	}
+	add(dwAbbrevCompileUnit, dwarf.TagCompileUnit, true,
+		int(dwarf.AttrName), dwFormString,
+		int(dwarf.AttrLanguage), dwFormData2,
+		int(dwarf.AttrProducer), dwFormString,
+		int(dwarf.AttrLowpc), dwFormAddr,
+		int(dwarf.AttrHighpc), dwFormData8,
+		int(dwarf.AttrStmtList), dwFormSecOffset)
+	add(dwAbbrevSubprogram, dwarf.TagSubprogram, true,
+		int(dwarf.AttrName), dwFormString,
+		int(dwarf.AttrLowpc), dwFormAddr,
+		int(dwarf.AttrHighpc), dwFormData8,
+		int(dwarf.AttrExternal), dwFormFlagPresent)
+	add(dwAbbrevAbstractFunc, dwarf.TagSubprogram, false,
+		int(dwarf.AttrName), dwFormString,
+		int(dwarf.AttrInline), dwFormData1)
+	add(dwAbbrevInlinedRoutine, dwarf.TagInlinedSubroutine, true,
+		int(dwarf.AttrAbstractOrigin), dwFormRef4,
+		int(dwarf.AttrLowpc), dwFormAddr,
+		int(dwarf.AttrHighpc), dwFormData8,
+		int(dwarf.AttrCallFile), dwFormData4,
+		int(dwarf.AttrCallLine), dwFormData4)
+	abbrevs.u8(0)
+	return abbrevs.b
+}
+
+// buildInfo writes a single compilation unit covering the module's text, with a subprogram for each function
+func (b *debugObjectBuilder) buildInfo(unitName string, codeBase, codeLen uintptr) []byte {
+	info := &dwBuf{order: b.order}
+	info.u32(0) // unit_length, filled in below
+	info.u16(dwInfoVersion)
+	info.u32(0) // debug_abbrev_offset
+	info.u8(8)  // address_size
+	info.uleb(dwAbbrevCompileUnit)
+	info.str(unitName)
+	info.u16(dwLangGo)
+	info.str("goloader")
+	info.u64(uint64(codeBase))
+	info.u64(uint64(codeLen))
+	info.u32(0) // stmt_list
+
+	// Abstract instances of inlined functions, which their inlined subroutines refer to
+	abstracts := map[string]uint32{}
+	for _, f := range b.funcs {
+		for _, call := range f.info.InlTree {
+			if _, ok := abstracts[call.Func]; !ok {
+				abstracts[call.Func] = uint32(len(info.b))
+				info.uleb(dwAbbrevAbstractFunc)
+				info.str(call.Func)
+				info.u8(dwInlInlined)
+			}
+		}
+	}
+	for i := range b.funcs {
+		f := &b.funcs[i]
+		info.uleb(dwAbbrevSubprogram)
+		info.str(f.name)
+		info.u64(uint64(f.entry))
+		info.u64(uint64(f.size))
+		b.writeInlinedCalls(info, f, abstracts)
+		info.u8(0) // End of children
+	}
+	info.u8(0)
+	b.order.PutUint32(info.b, uint32(len(info.b)-4))
+	return info.b
+}
+
+// writeInlinedCalls writes f's inline tree as nested inlined subroutines, one for each contiguous range of PCs
+// covered by each inlined call
+func (b *debugObjectBuilder) writeInlinedCalls(info *dwBuf, f *moduleFunc, abstracts map[string]uint32) {
+	tree := f.info.InlTree
+	runs := decodePCRuns(f.info.PCInline)
+	if len(tree) == 0 || len(runs) == 0 {
+		return
+	}
+	fileRuns, lineRuns := decodePCRuns(f.info.PCFile), decodePCRuns(f.info.PCLine)
+	type openCall struct {
+		index  int
+		start  uintptr
+		highPC int // Offset in info of the call's DW_AT_high_pc
+	}
+	var open []openCall
+	closeTo := func(depth int, pc uintptr) {
+		for len(open) > depth {
+			call := open[len(open)-1]
+			b.order.PutUint64(info.b[call.highPC:], uint64(pc-call.start))
+			info.u8(0) // End of children
+			open = open[:len(open)-1]
+		}
+	}
+	var chain []int
+	end := uintptr(0)
+	for _, run := range runs {
+		if run.start >= f.size {
+			break
+		}
+		end = run.end
+		// The calls enclosing this run, outermost first
+		chain = chain[:0]
+		for index := int(run.val); index >= 0 && index < len(tree) && len(chain) < len(tree); index = int(tree[index].Parent) {
+			chain = append(chain, index)
+		}
+		for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+			chain[i], chain[j] = chain[j], chain[i]
+		}
+		depth := 0
+		for depth < len(open) && depth < len(chain) && open[depth].index == chain[depth] {
+			depth++
+		}
+		closeTo(depth, run.start)
+		for _, index := range chain[depth:] {
+			call := tree[index]
+			// The call site is the position of the parent PC, as the runtime finds it
+			parentPC := uintptr(call.ParentPC)
+			callLine := pcRunValue(lineRuns, parentPC)
+			if callLine < 0 {
+				callLine = 0
+			}
+			info.uleb(dwAbbrevInlinedRoutine)
+			info.u32(abstracts[call.Func])
+			info.u64(uint64(f.entry + run.start))
+			open = append(open, openCall{index: index, start: run.start, highPC: len(info.b)})
+			info.u64(0)
+			info.u32(uint32(b.fileNum(f, pcRunValue(fileRuns, parentPC))))
+			info.u32(uint32(callLine))
+		}
+	}
+	if end > f.size {
+		end = f.size
+	}
+	closeTo(0, end)
+}
+
+// buildFrames writes a CIE describing the frame on entry to any function, and an FDE for each function from its pcsp
+// table, as cmd/link does
+func (b *debugObjectBuilder) buildFrames() []byte {
+	frame := &dwBuf{order: b.order}
+	writeEntry := func(entry *dwBuf) {
+		// Entries are padded with DW_CFA_nop to a multiple of the address size
+		entry.align(8)
+		frame.u32(uint32(len(entry.b) + 4))
+		frame.b = append(frame.b, entry.b...)
+		frame.align(8)
+	}
+	hasLR := b.linker.Arch.Family == sys.ARM64
+	spReg, raReg := uint64(7), uint64(16)
+	if hasLR {
+		spReg, raReg = 31, 30
+	}
+
+	cie := &dwBuf{order: b.order}
+	cie.u32(0xffffffff) // CIE_id
+	cie.u8(dwCIEVersion)
+	cie.u8(0) // No augmentation
+	cie.uleb(dwCFACodeAlignment)
+	cie.sleb(dwCFADataAlignment)
+	cie.uleb(raReg)
+	cie.u8(dwCFADefCFA)
+	cie.uleb(spReg)
+	if hasLR {
+		// The return address is in LR, and the caller's SP is the CFA
+		cie.uleb(0)
+		cie.u8(dwCFASameValue)
+		cie.uleb(raReg)
+		cie.u8(dwCFAValOffset)
+		cie.uleb(spReg)
+		cie.uleb(0)
+	} else {
+		// The return address is just below the CFA
+		cie.uleb(PtrSize)
+		cie.u8(dwCFAOffsetExtended)
+		cie.uleb(raReg)
+		cie.uleb(uint64(-PtrSize / dwCFADataAlignment))
+	}
+	writeEntry(cie)
+
+	for _, f := range b.funcs {
+		runs := decodePCRuns(f.info.PCSP)
+		if len(runs) == 0 {
+			continue
+		}
+		fde := &dwBuf{order: b.order}
+		fde.u32(0) // CIE_pointer
+		fde.u64(uint64(f.entry))
+		fde.u64(uint64(f.size))
+		var pc uintptr
+		for _, run := range runs {
+			if run.start >= f.size {
+				break
+			}
+			if run.start > pc {
+				fde.u8(dwCFAAdvanceLoc4)
+				fde.u32(uint32(run.start - pc))
+				pc = run.start
+			}
+			cfa := int64(run.val)
+			if hasLR {
+				// Once the frame is allocated, the prologue has saved LR at its bottom
+				if run.val > 0 {
+					fde.u8(dwCFAOffsetExtendedSf)
+					fde.uleb(raReg)
+					fde.sleb(-cfa / dwCFADataAlignment)
+				} else {
+					fde.u8(dwCFASameValue)
+					fde.uleb(raReg)
+				}
+			} else {
+				cfa += PtrSize
+			}
+			fde.u8(dwCFADefCFAOffsetSf)
+			fde.sleb(cfa / dwCFADataAlignment)
+		}
+		writeEntry(fde)
+	}
+	return frame.b
+}
+
+// buildLines writes a line number program with a sequence for each function, from its pcfile and pcline tables
+func (b *debugObjectBuilder) buildLines() []byte {
+	program := &dwBuf{order: b.order}
+	for i := range b.funcs {
+		f := &b.funcs[i]
+		fileRuns, lineRuns := decodePCRuns(f.info.PCFile), decodePCRuns(f.info.PCLine)
+		if len(fileRuns) == 0 || len(lineRuns) == 0 {
+			continue
+		}
+		program.u8(0)
+		program.uleb(1 + 8)
+		program.u8(dwLneSetAddress)
+		program.u64(uint64(f.entry))
+		file, line, pc := 1, int64(1), uintptr(0)
+		for fi, li := 0, 0; fi < len(fileRuns) && li < len(lineRuns); {
+			start := fileRuns[fi].start
+			if lineRuns[li].start > start {
+				start = lineRuns[li].start
+			}
+			if start >= f.size {
+				break
+			}
+			if num, newLine := b.fileNum(f, fileRuns[fi].val), int64(lineRuns[li].val); num > 0 && newLine > 0 {
+				if num != file {
+					program.u8(dwLnsSetFile)
+					program.uleb(uint64(num))
+					file = num
+				}
+				if newLine != line {
+					program.u8(dwLnsAdvanceLine)
+					program.sleb(newLine - line)
+					line = newLine
+				}
+				if start != pc {
+					program.u8(dwLnsAdvancePC)
+					program.uleb(uint64(start - pc))
+					pc = start
+				}
+				program.u8(dwLnsCopy)
+			}
+			switch {
+			case fileRuns[fi].end < lineRuns[li].end:
+				fi++
+			case fileRuns[fi].end > lineRuns[li].end:
+				li++
+			default:
+				fi++
+				li++
+			}
+		}
+		if f.size > pc {
+			program.u8(dwLnsAdvancePC)
+			program.uleb(uint64(f.size - pc))
+		}
+		program.u8(0)
+		program.uleb(1)
+		program.u8(dwLneEndSequence)
+	}
+
+	header := &dwBuf{order: b.order}
+	header.u8(1) // minimum_instruction_length
+	header.u8(1) // default_is_stmt
+	header.u8(uint8(dwLineBase & 0xff))
+	header.u8(dwLineRange)
+	header.u8(dwLineOpcodeBase)
+	header.b = append(header.b, 0, 1, 1, 1, 1, 0, 0, 0, 1) // standard_opcode_lengths
+	header.u8(0)                                           // No include_directories
+	for _, file := range b.files {
+		header.str(file)
+		header.uleb(0) // Directory
+		header.uleb(0) // Modification time
+		header.uleb(0) // Length
+	}
+	header.u8(0)
+
+	line := &dwBuf{order: b.order}
+	line.u32(uint32(2 + 4 + len(header.b) + len(program.b)))
+	line.u16(dwLineVersion)
+	line.u32(uint32(len(header.b)))
+	line.b = append(line.b, header.b...)
+	line.b = append(line.b, program.b...)
+	return line.b
+}
+
+type elfSection struct {
+	name    string
+	typ     elf.SectionType
+	flags   elf.SectionFlag
+	addr    uint64
+	size    uint64 // Only for SHT_NOBITS, otherwise the length of data
+	data    []byte
+	link    uint32
+	info    uint32
+	align   uint64
+	entsize uint64
+}
+
+// writeELF writes a 64 bit relocatable ELF object with the given sections, following the null section
+func writeELF(order binary.ByteOrder, machine elf.Machine, sections []elfSection) []byte {
+	const ehsize, shentsize = 64, 64
+	sections = append([]elfSection{{}}, sections...)
+	sections = append(sections, elfSection{name: ".shstrtab", typ: elf.SHT_STRTAB, align: 1})
+	shstrtab := &dwBuf{b: []byte{0}}
+	names := make([]uint32, len(sections))
+	for i := 1; i < len(sections); i++ {
+		names[i] = uint32(len(shstrtab.b))
+		shstrtab.str(sections[i].name)
+	}
+	sections[len(sections)-1].data = shstrtab.b
+
+	out := &dwBuf{order: order, b: make([]byte, ehsize)}
+	offsets := make([]uint64, len(sections))
+	for i := range sections {
+		if sections[i].typ != elf.SHT_NOBITS && sections[i].align > 1 {
+			out.align(int(sections[i].align))
+		}
+		offsets[i] = uint64(len(out.b))
+		if sections[i].typ != elf.SHT_NOBITS {
+			out.b = append(out.b, sections[i].data...)
+		}
+	}
+	out.align(8)
+	shoff := len(out.b)
+	for i, section := range sections {
+		size := uint64(len(section.data))
+		if section.typ == elf.SHT_NOBITS {
+			size = section.size
+		}
+		out.u32(names[i])
+		out.u32(uint32(section.typ))
+		out.u64(uint64(section.flags))
+		out.u64(section.addr)
+		out.u64(offsets[i])
+		out.u64(size)
+		out.u32(section.link)
+		out.u32(section.info)
+		out.u64(section.align)
+		out.u64(section.entsize)
+	}
+
+	header := &dwBuf{order: order, b: append([]byte(elf.ELFMAG), byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT), byte(elf.ELFOSABI_NONE))}
+	if order == binary.BigEndian {
+		header.b[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	}
+	header.align(16)
+	header.u16(uint16(elf.ET_REL))
+	header.u16(uint16(machine))
+	header.u32(uint32(elf.EV_CURRENT))
+	header.u64(0) // e_entry
+	header.u64(0) // e_phoff
+	header.u64(uint64(shoff))
+	header.u32(0) // e_flags
+	header.u16(ehsize)
+	header.u16(0) // e_phentsize
+	header.u16(0) // e_phnum
+	header.u16(shentsize)
+	header.u16(uint16(len(sections)))
+	header.u16(uint16(len(sections) - 1))
+	copy(out.b, header.b)
+	return out.b
+}
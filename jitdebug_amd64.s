@@ -0,0 +1,19 @@
+#include "textflag.h"
+
+// __jit_debug_register_code and __jit_debug_descriptor are found by name by debuggers implementing GDB's JIT interface,
+// which set a breakpoint on the function to be told of each change to the descriptor's list of objects, see jitdebug.go
+TEXT __jit_debug_register_code(SB), NOSPLIT, $0-0
+	RET
+
+DATA __jit_debug_descriptor+0(SB)/4, $1 // version
+GLOBL __jit_debug_descriptor(SB), NOPTR, $24
+
+// func jitDebugRegisterCode()
+TEXT ·jitDebugRegisterCode(SB), NOSPLIT, $0-0
+	JMP __jit_debug_register_code(SB)
+
+// func jitDebugDescriptor() *jitDescriptor
+TEXT ·jitDebugDescriptor(SB), NOSPLIT, $0-8
+	LEAQ __jit_debug_descriptor(SB), AX
+	MOVQ AX, ret+0(FP)
+	RET
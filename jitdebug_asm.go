@@ -0,0 +1,8 @@
+//go:build amd64 || arm64
+// +build amd64 arm64
+
+package goloader
+
+// Implemented in jitdebug_$GOARCH.s
+func jitDebugRegisterCode()
+func jitDebugDescriptor() *jitDescriptor
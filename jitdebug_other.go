@@ -0,0 +1,11 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package goloader
+
+// Registration with debuggers is only implemented on 64 bit architectures
+func jitDebugRegisterCode() {}
+
+func jitDebugDescriptor() *jitDescriptor {
+	return nil
+}
@@ -0,0 +1,256 @@
+package goloader
+
+import (
+	"cmd/objfile/sys"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/eihigh/goloader/obj"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// Lazy binding (see WithLazyBinding) leaves a module's functions unrelocated at load, and relocates each on its first
+// call. Rather than routing calls through a separate stub, each function's own stack check is used as the stub: its
+// comparison of SP against g.stackguard0 is pointed at g.stack.hi instead, so that it always fails, and its call to
+// runtime.morestack is relocated to lazyBindMorestack. By the time that's called the function's register arguments
+// have been spilled to its argument area (which its stack maps describe), so the binder is free to run ordinary Go
+// code, grow the stack or GC. lazyBindMorestack then returns into the function's morestack path, which reloads the
+// arguments and jumps back to the now bound entry. Since pcln tables are built for every function at load as usual,
+// tracebacks through functions which are still unbound work too.
+//
+// Functions without a stack check (NOSPLIT functions, including assembly) are always bound at load.
+
+const (
+	stackHiOffset     = PtrSize     // Offset of g.stack.hi
+	stackguardOffset  = 2 * PtrSize // Offset of g.stackguard0
+	stackCheckMaxSkip = 32          // How far into a function its stack check may start
+)
+
+// lazyFunc is a function which is bound on its first call
+type lazyFunc struct {
+	binder          *lazyBinder
+	symbol          *obj.Sym
+	stackCheckReloc int     // Index in symbol.Reloc of the stack check's call to runtime.morestack
+	guardOffset     int     // Offset within the code segment of the stack check's displacement of g.stackguard0
+	retPC           uintptr // Return address of the stack check's call
+	stub            *obj.Sym
+	stubAddr        uintptr
+	bound           bool
+}
+
+// lazyBinder relocates a module's functions on their first call
+type lazyBinder struct {
+	mu        sync.Mutex
+	linker    *Linker // A shallow copy, which keeps the symbols and their relocations once the original is released
+	module    *CodeModule
+	symbolMap map[string]uintptr
+	funcs     map[string]*lazyFunc
+	bound     int
+}
+
+// LazyBindingStats describes how many of a module's functions were left to be bound on first call, and how many of
+// them have been called since
+type LazyBindingStats struct {
+	Deferred int
+	Bound    int
+}
+
+var (
+	lazyStubsLock sync.Mutex
+	lazyStubs     = map[uintptr]*lazyFunc{} // Keyed by the return address of each unbound function's call to its stub
+
+	lazyStubOnce                 sync.Once
+	lazyStubCtxt, lazyStubNoctxt *obj.Sym
+	lazyStubAddrs                = map[*obj.Sym]uintptr{}
+)
+
+// lazyStubFor returns the stub to call in place of the runtime.morestack variant morestack, or nil if lazy binding
+// isn't supported
+func lazyStubFor(morestack string) (*obj.Sym, uintptr) {
+	lazyStubOnce.Do(func() {
+		if len(lazyBindStubs) == 0 {
+			return
+		}
+		// The stack check calls its target directly, so must reach the assembly itself rather than an ABI wrapper
+		abiInternalPCs := make([]uintptr, len(lazyBindStubs))
+		for i, stub := range lazyBindStubs {
+			abiInternalPCs[i] = reflect.ValueOf(stub).Pointer()
+		}
+		abi0PCs := FuncPCsABI0(abiInternalPCs)
+		for i := range abi0PCs {
+			if abi0PCs[i] == 0 {
+				abi0PCs[i] = abiInternalPCs[i]
+			}
+		}
+		lazyStubCtxt = &obj.Sym{Name: "goloader.lazyBindMorestack", Kind: symkind.STEXT, Offset: InvalidOffset}
+		lazyStubNoctxt = &obj.Sym{Name: "goloader.lazyBindMorestackNoctxt", Kind: symkind.STEXT, Offset: InvalidOffset}
+		lazyStubAddrs[lazyStubCtxt] = abi0PCs[0]
+		lazyStubAddrs[lazyStubNoctxt] = abi0PCs[1]
+	})
+	if lazyStubCtxt == nil {
+		return nil, 0
+	}
+	if strings.HasPrefix(morestack, "runtime.morestack_noctxt") {
+		return lazyStubNoctxt, lazyStubAddrs[lazyStubNoctxt]
+	}
+	return lazyStubCtxt, lazyStubAddrs[lazyStubCtxt]
+}
+
+// findStackCheckGuard returns the offset within code of the g.stackguard0 displacement of an amd64 stack check, i.e.
+// CMPQ SP, 16(g) or CMPQ R12, 16(g) followed by JLS
+func findStackCheckGuard(code []byte) (int, bool) {
+	for i := 0; i+6 <= len(code) && i < stackCheckMaxSkip; i++ {
+		rex, opcode, modrm, disp := code[i], code[i+1], code[i+2], code[i+3]
+		if rex&0xFA != 0x48 || opcode != 0x3b || modrm&0xC0 != 0x40 || (modrm>>3)&0x7 != 0x4 || modrm&0x7 == 0x4 || disp != stackguardOffset {
+			continue
+		}
+		if code[i+4] == 0x76 || (code[i+4] == 0x0f && code[i+5] == 0x86) {
+			return i + 3, true
+		}
+	}
+	return 0, false
+}
+
+// prepareLazyBinding picks the functions to be bound on first call, and makes their stack checks always fail.
+// It must be called after the module's text has been copied, but before it is relocated.
+func (linker *Linker) prepareLazyBinding(codeModule *CodeModule) {
+	if !linker.options.LazyBinding || linker.Arch.Family != sys.AMD64 {
+		return
+	}
+	linker.lazyFuncs = map[string]*lazyFunc{}
+	for _, symbol := range linker.symMap {
+		if symbol.Kind != symkind.STEXT || symbol.Offset < 0 {
+			continue
+		}
+		guard, ok := findStackCheckGuard(codeModule.codeByte[symbol.Offset : symbol.Offset+symbol.Size])
+		if !ok {
+			continue
+		}
+		for i, loc := range symbol.Reloc {
+			if !strings.HasPrefix(loc.Sym.Name, "runtime.morestack") || loc.Offset < symbol.Offset+guard {
+				continue
+			}
+			stub, stubAddr := lazyStubFor(loc.Sym.Name)
+			if stub == nil {
+				return
+			}
+			linker.lazyFuncs[symbol.Name] = &lazyFunc{
+				symbol:          symbol,
+				stackCheckReloc: i,
+				guardOffset:     symbol.Offset + guard,
+				retPC:           uintptr(codeModule.codeBase + loc.Offset + loc.Size),
+				stub:            stub,
+				stubAddr:        stubAddr,
+			}
+			codeModule.codeByte[symbol.Offset+guard] = stackHiOffset
+			break
+		}
+	}
+}
+
+// registerLazyBinding makes the module's unbound functions callable, and must be called before any of its code runs
+func (linker *Linker) registerLazyBinding(codeModule *CodeModule, symbolMap map[string]uintptr) {
+	if len(linker.lazyFuncs) == 0 {
+		return
+	}
+	linkerCopy := *linker
+	binder := &lazyBinder{linker: &linkerCopy, module: codeModule, symbolMap: symbolMap, funcs: linker.lazyFuncs}
+	codeModule.lazy = binder
+	lazyStubsLock.Lock()
+	for _, f := range binder.funcs {
+		f.binder = binder
+		lazyStubs[f.retPC] = f
+	}
+	lazyStubsLock.Unlock()
+}
+
+func (cm *CodeModule) unregisterLazyBinding() {
+	if cm.lazy == nil {
+		return
+	}
+	lazyStubsLock.Lock()
+	for _, f := range cm.lazy.funcs {
+		delete(lazyStubs, f.retPC)
+	}
+	lazyStubsLock.Unlock()
+}
+
+// LazyBindingStats returns how many of the module's functions were loaded unbound, and how many of those have been
+// bound since. Both are zero unless the module was loaded with WithLazyBinding.
+func (cm *CodeModule) LazyBindingStats() LazyBindingStats {
+	if cm.lazy == nil {
+		return LazyBindingStats{}
+	}
+	cm.lazy.mu.Lock()
+	defer cm.lazy.mu.Unlock()
+	return LazyBindingStats{Deferred: len(cm.lazy.funcs), Bound: cm.lazy.bound}
+}
+
+// lazyBind is called by lazyBindMorestack, and binds the function whose stack check called it. The closure context is
+// passed through so that it stays visible to the GC while the function is bound.
+func lazyBind(ctxt unsafe.Pointer) unsafe.Pointer {
+	// The unbound function is the first caller (past the stub, and any ABI wrapper) whose return address is known
+	var pcs [8]uintptr
+	n := runtime.Callers(2, pcs[:])
+	var f *lazyFunc
+	lazyStubsLock.Lock()
+	for _, pc := range pcs[:n] {
+		if f = lazyStubs[pc]; f != nil {
+			break
+		}
+	}
+	lazyStubsLock.Unlock()
+	if f == nil {
+		panic(fmt.Sprintf("lazy binding stub called from %x, which isn't an unbound function", pcs[:n]))
+	}
+	if err := f.binder.bind(f); err != nil {
+		panic(fmt.Sprintf("failed to bind %s on its first call: %s", f.symbol.Name, err))
+	}
+	return ctxt
+}
+
+func (b *lazyBinder) bind(f *lazyFunc) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f.bound {
+		// Another thread got here first
+		return nil
+	}
+	cm := b.module
+	// Only the function itself, its stack check and the far reference entries its relocations may use are written
+	spans := [][2]int{{f.symbol.Offset, f.symbol.Size}, {f.guardOffset, 1}}
+	if cm.farRefs != nil && cm.farRefs.size > 0 {
+		spans = append(spans, [2]int{cm.farRefs.start, cm.farRefs.size})
+	}
+	for _, span := range spans {
+		var protect func() error
+		if protect, err = cm.makeTextWritable(uintptr(cm.codeBase+span[0]), span[1]); err != nil {
+			return err
+		}
+		defer func() {
+			if err2 := protect(); err2 != nil && err == nil {
+				err = err2
+			}
+		}()
+	}
+	// This also relocates the stack check's call back to runtime.morestack, which must happen before the check itself
+	// is restored below, or a thread which is genuinely short of stack would call the stub forever
+	if err = b.linker.relocateSymbol(cm, f.symbol, b.symbolMap, true); err != nil {
+		return err
+	}
+	MakeThreadJITCodeExecutable(uintptr(cm.codeBase+f.symbol.Offset), f.symbol.Size)
+	cm.codeByte[f.guardOffset] = stackguardOffset
+	MakeThreadJITCodeExecutable(uintptr(cm.codeBase+f.guardOffset), 1)
+	f.bound = true
+	b.bound++
+	if b.bound == len(b.funcs) {
+		// Nothing left to relocate
+		b.linker = nil
+		b.symbolMap = nil
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build amd64
+// +build amd64
+
+package goloader
+
+// Implemented in lazy_amd64.s
+func lazyBindMorestack()
+func lazyBindMorestackNoctxt()
+
+// lazyBindStubs are called in place of runtime.morestack and runtime.morestack_noctxt by unbound functions
+var lazyBindStubs = []func(){lazyBindMorestack, lazyBindMorestackNoctxt}
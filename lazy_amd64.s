@@ -0,0 +1,25 @@
+#include "textflag.h"
+#include "funcdata.h"
+
+// lazyBindMorestack is called in place of runtime·morestack by the stack check of a function which hasn't been bound
+// yet, see lazy.go. The function's arguments have already been spilled, and its closure context is in DX. Once the
+// function is bound this returns into its morestack path, which reloads the arguments and jumps back to its entry.
+TEXT ·lazyBindMorestack(SB), NOSPLIT, $24-0
+	NO_LOCAL_POINTERS
+	MOVQ R14, 16(SP)
+	MOVQ DX, 0(SP)
+	CALL ·lazyBind(SB)
+	MOVQ 8(SP), DX
+	MOVQ 16(SP), R14
+	XORPS X15, X15
+	RET
+
+// lazyBindMorestackNoctxt is called in place of runtime·morestack_noctxt
+TEXT ·lazyBindMorestackNoctxt(SB), NOSPLIT, $24-0
+	NO_LOCAL_POINTERS
+	MOVQ R14, 16(SP)
+	MOVQ $0, 0(SP)
+	CALL ·lazyBind(SB)
+	MOVQ 16(SP), R14
+	XORPS X15, X15
+	RET
@@ -0,0 +1,7 @@
+//go:build !amd64
+// +build !amd64
+
+package goloader
+
+// Lazy binding is only implemented on amd64
+var lazyBindStubs []func()
@@ -33,6 +33,9 @@ type segment struct {
 	noptrdataLen  int
 	bssLen        int
 	noptrbssLen   int
+	rodataLen     int
+	rodataOff     int
+	farRefs       *farRefTable
 	codeLen       int
 	maxCodeLength int
 	maxDataLength int
@@ -44,8 +47,9 @@ type Linker struct {
 	code                   []byte
 	data                   []byte
 	noptrdata              []byte
-	bss                    []byte
-	noptrbss               []byte
+	bssSize                int // BSS is only represented by its size, since it is backed by fresh zero pages in the data mapping
+	noptrbssSize           int
+	rodata                 []byte
 	cuFiles                []obj.CompilationUnitFiles
 	symMap                 map[string]*obj.Sym
 	objsymbolMap           map[string]*obj.ObjSymbol
@@ -70,6 +74,12 @@ type Linker struct {
 	reachableSymbols       map[string]struct{}
 	pkgs                   []*obj.Pkg
 	pkgsByName             map[string]*obj.Pkg
+	sharedSyms             map[string]uintptr   // Symbols resolved to another instance's copy instead of loaded again, see LoadInstance
+	archives               [][]byte             // Read-only mappings of the archive files, which symbol data aliases until Release
+	lazyFuncs              map[string]*lazyFunc // Functions to be bound on first call, see lazy.go
+	relocOverflow          RelocOverflow        // How the relocation being applied reached an out of range target
+	stats                  LoadStats            // Phases of reading objects, which each module loaded starts from
+	released               bool
 }
 
 type CodeModule struct {
@@ -84,6 +94,28 @@ type CodeModule struct {
 	patchedTypeMethodsMtyp map[*_type]map[int]typeOff
 	deduplicatedTypes      map[string]uintptr
 	heapStrings            map[string]*string
+	globals                map[string]globalVar
+	noMigrate              map[string]struct{}
+	writeProtected         bool
+	mappedAnywhere         bool // Out of PC relative range of the first module, see mapModule
+	exportedTypes          map[string]map[string]*_type
+	symPtr                 map[string]uintptr
+	funcs                  map[string]patchableFunc
+	dataSyms               map[string]uintptr
+	dataFingerprints       map[string]uint64
+	patches                []*FunctionPatch
+	patchArea              *patchArea // Space reserved for PatchFunctions, see WithFunctionPatchSpace
+	hostTrampolines        hostTrampolines
+	pclnMappings           [][]byte // Read-only copies of the function tables, see sealPclnTables
+	lazy                   *lazyBinder
+	instanceOf             *CodeModule   // The first instance, whose text and types this one shares, see LoadInstance
+	instances              int           // How many other instances share this module's text and types
+	debugEntry             *jitCodeEntry // Registration with debuggers, see jitdebug.go
+	symbols                []Symbol      // Sorted by address
+	relocRecords           []RelocRecord // See WithRelocationRecords
+	callSites              []uintptr     // Sorted, see Verify
+	dataTypes              []dataType    // Declared types of data and bss symbols, see Verify
+	stats                  LoadStats
 }
 
 var (
@@ -117,6 +149,21 @@ func initLinker(opts []LinkerOptFunc) (*Linker, error) {
 	if os.Getenv("GOLOADER_FORCE_TEST_RELOCATION_EPILOGUES") == "1" {
 		opts = append(opts, WithForceTestRelocationEpilogues())
 	}
+	if os.Getenv("GOLOADER_FORCE_TEST_MAP_ANYWHERE") == "1" {
+		opts = append(opts, WithForceTestMapAnywhere())
+	}
+	if os.Getenv("GOLOADER_GDB_JIT") == "1" {
+		opts = append(opts, WithDebuggerRegistration())
+	}
+	if os.Getenv("GOLOADER_PERF_MAP") == "1" {
+		opts = append(opts, WithPerfMap())
+	}
+	if dir := os.Getenv("GOLOADER_PERF_JITDUMP_DIR"); dir != "" {
+		opts = append(opts, WithPerfJITDump(dir))
+	}
+	if os.Getenv("GOLOADER_RECORD_RELOCATIONS") == "1" {
+		opts = append(opts, WithRelocationRecords())
+	}
 	linker.Opts(opts...)
 
 	head := make([]byte, unsafe.Sizeof(pcHeader{}))
@@ -170,6 +217,7 @@ func (linker *Linker) Opts(linkerOpts ...LinkerOptFunc) {
 func (linker *Linker) addSymbols(symbolNames []string, globalSymPtr map[string]uintptr) error {
 	// static_tmp is 0, golang compile not allocate memory.
 	linker.noptrdata = append(linker.noptrdata, make([]byte, IntSize)...)
+	linker.rodata = append(linker.rodata, make([]byte, IntSize)...)
 
 	for _, cuFileSet := range linker.cuFiles {
 		for _, fileName := range cuFileSet.Files {
@@ -246,13 +294,15 @@ func (linker *Linker) addSymbols(symbolNames []string, globalSymPtr map[string]u
 		case symkind.SNOPTRDATA, symkind.SRODATA:
 			if strings.HasPrefix(sym.Name, TypeStringPrefix) {
 				// nothing todo
+			} else if isReadOnlyDataSymbol(sym.Kind) {
+				offset += linker.rodataOffset()
 			} else {
 				offset += len(linker.data)
 			}
 		case symkind.SBSS:
 			offset += len(linker.data) + len(linker.noptrdata)
 		case symkind.SNOPTRBSS:
-			offset += len(linker.data) + len(linker.noptrdata) + len(linker.bss)
+			offset += len(linker.data) + len(linker.noptrdata) + linker.bssSize
 		}
 		sym.Offset += offset
 		if offset != 0 {
@@ -289,94 +339,39 @@ func (linker *Linker) addSymbol(name string, globalSymPtr map[string]uintptr) (s
 			// Pessimistically pad the function text with extra bytes for any relocations which might add extra
 			// instructions at the end in the case of a 32 bit overflow. These epilogue PCs need to be added to
 			// the PCData, PCLine, PCFile, PCSP etc in case of pre-emption or stack unwinding while the PC is running these hacked instructions.
-			// We find the relevant PCValues for the offset of the reloc, and reuse the values for the reloc's epilogue
+			// We find the relevant PCValues for the offset of the reloc, and reuse the values for the reloc's epilogue.
+			// Calls, address loads and GOT loads don't need this, since they are redirected through a trampoline or
+			// GOT slot shared by all relocations to the same target, in the module's far reference table.
 
 			if linker.options.NoRelocationEpilogues && !strings.HasPrefix(reloc.Sym.Name, TypeStringPrefix) {
 				continue
 			}
 			switch reloc.Type {
-			case reloctype.R_ADDRARM64:
-				objsym.Reloc[i].EpilogueOffset = len(linker.code) - symbol.Offset
-				objsym.Reloc[i].EpilogueSize = maxExtraInstructionBytesADRP
-				linker.code = append(linker.code, createArchNops(linker.Arch, maxExtraInstructionBytesADRP)...)
 			case reloctype.R_ARM64_PCREL_LDST8, reloctype.R_ARM64_PCREL_LDST16, reloctype.R_ARM64_PCREL_LDST32, reloctype.R_ARM64_PCREL_LDST64:
 				objsym.Reloc[i].EpilogueOffset = len(linker.code) - symbol.Offset
 				objsym.Reloc[i].EpilogueSize = maxExtraInstructionBytesADRPLDST
 				linker.code = append(linker.code, createArchNops(linker.Arch, maxExtraInstructionBytesADRPLDST)...)
-			case reloctype.R_CALLARM64, reloctype.R_CALLARM64 | reloctype.R_WEAK:
-				objsym.Reloc[i].EpilogueOffset = alignof(len(linker.code)-symbol.Offset, PtrSize)
-				objsym.Reloc[i].EpilogueSize = maxExtraInstructionBytesCALLARM64
-				alignment := alignof(len(linker.code)-symbol.Offset, PtrSize) - (len(linker.code) - symbol.Offset)
-				linker.code = append(linker.code, createArchNops(linker.Arch, maxExtraInstructionBytesCALLARM64+alignment)...)
 			case reloctype.R_PCREL:
-				objsym.Reloc[i].EpilogueOffset = len(linker.code) - symbol.Offset
 				var epilogueSize int
-				offset := reloc.Offset
-				if reloc.Offset == 1 {
-					// This might happen if a CGo E9 JMP is right at the beginning of a function, so we want to avoid slicing before the start of the text
-					offset += 1
-				}
-				instructionBytes := objsym.Data[offset-2 : reloc.Offset+reloc.Size]
-				opcode := instructionBytes[0]
+				opcode := pcrelOpcodeAt(objsym.Data, reloc.Offset)
 				switch opcode {
-				case x86amd64LEAcode:
-					epilogueSize = maxExtraInstructionBytesPCRELxLEAQ
 				case x86amd64MOVcode:
 					epilogueSize = maxExtraInstructionBytesPCRELxMOVNear
 				case x86amd64CMPLcode:
 					epilogueSize = maxExtraInstructionBytesPCRELxCMPLNear
-				case x86amd64JMPcode:
-					epilogueSize = maxExtraInstructionBytesPCRELxJMP
-				case x86amd64CALL2code: // CGo FF 15 PCREL call
-					if instructionBytes[1] == 0x15 {
-						epilogueSize = maxExtraInstructionBytesPCRELxCALL2
-						break
-					}
-					fallthrough // Might be FF XX E8/E9 ...
 				default:
-					switch instructionBytes[1] {
-					case x86amd64CALLcode:
-						opcode = x86amd64CALLcode
-						epilogueSize = maxExtraInstructionBytesCALLNear
-					case x86amd64JMPcode:
-						epilogueSize = maxExtraInstructionBytesPCRELxJMP
-					}
+					continue
 				}
+				objsym.Reloc[i].EpilogueOffset = len(linker.code) - symbol.Offset
 				returnOffset := (reloc.Offset + reloc.Size) - (objsym.Reloc[i].EpilogueOffset + epilogueSize) - len(x86amd64JMPShortCode) //  assumes short jump, adjusts if not
 				shortJmp := returnOffset < 0 && returnOffset > -0x80
-				switch opcode {
-				case x86amd64MOVcode:
-					if shortJmp {
+				if shortJmp {
+					switch opcode {
+					case x86amd64MOVcode:
 						epilogueSize = maxExtraInstructionBytesPCRELxMOVShort
-					}
-				case x86amd64CMPLcode:
-					if shortJmp {
+					case x86amd64CMPLcode:
 						epilogueSize = maxExtraInstructionBytesPCRELxCMPLShort
 					}
-				case x86amd64CALLcode:
-					if shortJmp {
-						epilogueSize = maxExtraInstructionBytesCALLShort
-					}
-				}
-				objsym.Reloc[i].EpilogueSize = epilogueSize
-				linker.code = append(linker.code, createArchNops(linker.Arch, epilogueSize)...)
-			case reloctype.R_GOTPCREL, reloctype.R_TLS_IE:
-				objsym.Reloc[i].EpilogueOffset = len(linker.code) - symbol.Offset
-				objsym.Reloc[i].EpilogueSize = maxExtraInstructionBytesGOTPCREL
-				linker.code = append(linker.code, createArchNops(linker.Arch, objsym.Reloc[i].EpilogueSize)...)
-			case reloctype.R_ARM64_GOTPCREL, reloctype.R_ARM64_TLS_IE:
-				objsym.Reloc[i].EpilogueOffset = alignof(len(linker.code)-symbol.Offset, PtrSize)
-				objsym.Reloc[i].EpilogueSize = maxExtraInstructionBytesARM64GOTPCREL
-				// need to be able to pad to align to multiple of 8
-				alignment := alignof(len(linker.code)-symbol.Offset, PtrSize) - (len(linker.code) - symbol.Offset)
-				linker.code = append(linker.code, createArchNops(linker.Arch, objsym.Reloc[i].EpilogueSize+alignment)...)
-			case reloctype.R_CALL, reloctype.R_CALL | reloctype.R_WEAK:
-				epilogueSize := maxExtraInstructionBytesCALLNear
-				returnOffset := (reloc.Offset + reloc.Size) - (objsym.Reloc[i].EpilogueOffset + epilogueSize) - len(x86amd64JMPShortCode) //  assumes short jump, adjusts if not
-				shortJmp := returnOffset < 0 && returnOffset > -0x80
-				objsym.Reloc[i].EpilogueOffset = len(linker.code) - symbol.Offset
-				if shortJmp {
-					epilogueSize = maxExtraInstructionBytesCALLShort
 				}
 				objsym.Reloc[i].EpilogueSize = epilogueSize
 				linker.code = append(linker.code, createArchNops(linker.Arch, epilogueSize)...)
@@ -400,19 +395,21 @@ func (linker *Linker) addSymbol(name string, globalSymPtr map[string]uintptr) (s
 			copy(data, objsym.Data)
 			stringVal := string(data)
 			linker.heapStringMap[symbol.Name] = &stringVal
+		} else if isReadOnlyDataSymbol(symbol.Kind) {
+			symbol.Offset = len(linker.rodata)
+			linker.rodata = append(linker.rodata, objsym.Data...)
+			bytearrayAlign(&linker.rodata, PtrSize)
 		} else {
 			symbol.Offset = len(linker.noptrdata)
 			linker.noptrdata = append(linker.noptrdata, objsym.Data...)
 			bytearrayAlign(&linker.noptrdata, PtrSize)
 		}
 	case symkind.SBSS:
-		symbol.Offset = len(linker.bss)
-		linker.bss = append(linker.bss, objsym.Data...)
-		bytearrayAlign(&linker.bss, PtrSize)
+		symbol.Offset = linker.bssSize
+		linker.bssSize = alignof(linker.bssSize+int(objsym.Size), PtrSize)
 	case symkind.SNOPTRBSS:
-		symbol.Offset = len(linker.noptrbss)
-		linker.noptrbss = append(linker.noptrbss, objsym.Data...)
-		bytearrayAlign(&linker.noptrbss, PtrSize)
+		symbol.Offset = linker.noptrbssSize
+		linker.noptrbssSize = alignof(linker.noptrbssSize+int(objsym.Size), PtrSize)
 	case symkind.STLSBSS:
 		// Nothing to do, since runtime.tls_g should be resolved from the host binary
 	default:
@@ -455,16 +452,9 @@ func (linker *Linker) addSymbol(name string, globalSymPtr map[string]uintptr) (s
 				if exist {
 					reloc.Sym = linker.symMap[reloc.Sym.Name]
 				} else {
-					path := strings.Trim(strings.TrimPrefix(reloc.Sym.Name, TypeImportPathPrefix), ".")
 					reloc.Sym.Kind = symkind.SNOPTRDATA
 					reloc.Sym.Offset = len(linker.noptrdata)
-					// name memory layout
-					// name { tagLen(byte), len(uint16), str*}
-					nameLen := []byte{0, 0, 0}
-					binary.PutUvarint(nameLen[1:], uint64(len(path)))
-					linker.noptrdata = append(linker.noptrdata, nameLen...)
-					linker.noptrdata = append(linker.noptrdata, path...)
-					linker.noptrdata = append(linker.noptrdata, ZeroByte)
+					linker.noptrdata = append(linker.noptrdata, importPathName(reloc.Sym.Name)...)
 					bytearrayAlign(&linker.noptrdata, PtrSize)
 				}
 			}
@@ -504,6 +494,17 @@ func (linker *Linker) addSymbol(name string, globalSymPtr map[string]uintptr) (s
 	return symbol, nil
 }
 
+// importPathName returns the data of a type:.importpath. symbol, which the compiler leaves to the linker to generate
+func importPathName(name string) []byte {
+	path := strings.Trim(strings.TrimPrefix(name, TypeImportPathPrefix), ".")
+	// name memory layout
+	// name { tagLen(byte), len(uint16), str*}
+	nameLen := []byte{0, 0, 0}
+	binary.PutUvarint(nameLen[1:], uint64(len(path)))
+	data := append(nameLen, path...)
+	return append(data, ZeroByte)
+}
+
 func (linker *Linker) readFuncData(symbol *obj.ObjSymbol, codeLen int) (err error) {
 	nameOff := len(linker.funcnametab)
 	if offset, ok := linker.namemap[symbol.Name]; !ok {
@@ -589,6 +590,13 @@ func (linker *Linker) addSymbolMap(symPtr map[string]uintptr, codeModule *CodeMo
 		if !linker.isSymbolReachable(name) {
 			continue
 		}
+		if addr, ok := linker.sharedSyms[name]; ok {
+			symbolMap[name] = addr
+			if sym.Kind == symkind.STEXT {
+				codeModule.Syms[sym.Name] = addr
+			}
+			continue
+		}
 		if sym.Offset == InvalidOffset {
 			if ptr, ok := symPtr[sym.Name]; ok {
 				symbolMap[name] = ptr
@@ -673,7 +681,7 @@ func (linker *Linker) addFuncTab(module *moduledata, _func *_func, symbolMap map
 	setfuncentry(_func, symbolMap[funcname], module.text)
 	Func := linker.symMap[funcname].Func
 
-	if err = stackobject.AddStackObject(funcname, linker.symMap, symbolMap, module.noptrdata); err != nil {
+	if err = stackobject.AddStackObject(funcname, linker.symMap, symbolMap, module.rodata); err != nil {
 		return err
 	}
 	if err = linker.addDeferReturn(_func); err != nil {
@@ -761,7 +769,8 @@ func (linker *Linker) patchPCValuesForReloc(pcvalues *[]byte, relocOffet int, ep
 	if linker.Arch.Family == sys.ARM64 {
 		pcQuantum = 4
 	}
-	p := *pcvalues
+	// Copy, since the table may alias a read-only archive mapping, and is modified in place below
+	p := append([]byte(nil), *pcvalues...)
 	if len(p) == 0 {
 		panic("trying to patch a zero sized pcvalue table. This shouldn't be possible...")
 	}
@@ -844,31 +853,70 @@ func (linker *Linker) buildModule(codeModule *CodeModule, symbolMap map[string]u
 	module.ebss = module.bss + uintptr(segment.bssLen)
 	module.noptrbss = module.ebss
 	module.enoptrbss = module.noptrbss + uintptr(segment.noptrbssLen)
-	module.end = module.enoptrbss
+	// Funcdata and the gcdata of stack objects are read-only data, so are addressed relative to it
+	module.rodata = module.data + uintptr(segment.rodataOff)
+	module.gofunc = module.rodata
+	module.end = module.data + uintptr(segment.sumDataLen)
 	module.types = module.data
-	module.etypes = module.enoptrbss
+	module.etypes = module.end
 
 	module.ftab = append(module.ftab, initfunctab(module.minpc, uintptr(len(module.pclntable)), module.text))
 	for index, _func := range linker._func {
 		funcname := gostringnocopy(&linker.funcnametab[_func.nameoff])
+		if _, shared := linker.sharedSyms[funcname]; shared {
+			// Run from another instance's text, see LoadInstance
+			continue
+		}
 		module.ftab = append(module.ftab, initfunctab(symbolMap[funcname], uintptr(len(module.pclntable)), module.text))
 		if err = linker.addFuncTab(module, linker._func[index], symbolMap); err != nil {
 			return err
 		}
 	}
 	module.ftab = append(module.ftab, initfunctab(module.maxpc, uintptr(len(module.pclntable)), module.text))
+	addFindFuncTab(module)
+
+	if err = linker.addgcdata(codeModule, symbolMap); err != nil {
+		return err
+	}
+	for name, addr := range symbolMap {
+		if strings.HasPrefix(name, TypePrefix) &&
+			!strings.HasPrefix(name, TypeDoubleDotPrefix) &&
+			addr >= module.types && addr < module.etypes {
+			module.typelinks = append(module.typelinks, int32(addr-module.types))
+			module.typemap[typeOff(addr-module.types)] = (*_type)(unsafe.Pointer(addr))
+		}
+	}
+	initmodule(codeModule.module, linker)
+	tables, err := codeModule.sealPclnTables(module.pclnTables())
+	if err != nil {
+		return err
+	}
+	setPclnTables(module, tables)
 
-	// see:^src/cmd/link/internal/ld/pcln.go findfunctab
+	modulesLock.Lock()
+	addModule(codeModule)
+	modulesLock.Unlock()
+	additabs(codeModule.module)
+	moduledataverify1(codeModule.module)
+	modulesinit()
+	typelinksinit() // Deduplicate typelinks across all modules
+	// Only index the module's types once it's active, since hashing them resolves type offsets
+	typeIdx.addModule(codeModule)
+	return err
+}
+
+// addFindFuncTab appends the findfunctab for the module's ftab, which starts with an entry at minpc and ends with one at
+// maxpc, to its pclntable
+// see:^src/cmd/link/internal/ld/pcln.go findfunctab
+func addFindFuncTab(module *moduledata) {
+	funcs := module.ftab[1:]
 	funcbucket := []findfuncbucket{}
-	for k := 0; k < len(linker._func); k++ {
-		lEntry := int(getfuncentry(linker._func[k], module.text) - module.text)
+	for k := 0; k < len(funcs)-1; k++ {
+		lEntry := int(funcs[k].entry)
 		lb := lEntry / pcbucketsize
 		li := lEntry % pcbucketsize / (pcbucketsize / nsub)
 
-		entry := int(module.maxpc - module.text)
-		if k < len(linker._func)-1 {
-			entry = int(getfuncentry(linker._func[k+1], module.text) - module.text)
-		}
+		entry := int(funcs[k+1].entry)
 		b := entry / pcbucketsize
 		i := entry % pcbucketsize / (pcbucketsize / nsub)
 
@@ -884,31 +932,13 @@ func (linker *Linker) buildModule(codeModule *CodeModule, symbolMap map[string]u
 			}
 		}
 	}
+	if len(funcbucket) == 0 {
+		// All of an instance's functions may be run from another's text, see LoadInstance
+		funcbucket = append(funcbucket, findfuncbucket{})
+	}
 	length := len(funcbucket) * FindFuncBucketSize
 	append2Slice(&module.pclntable, uintptr(unsafe.Pointer(&funcbucket[0])), length)
 	module.findfunctab = (uintptr)(unsafe.Pointer(&module.pclntable[len(module.pclntable)-length]))
-
-	if err = linker.addgcdata(codeModule, symbolMap); err != nil {
-		return err
-	}
-	for name, addr := range symbolMap {
-		if strings.HasPrefix(name, TypePrefix) &&
-			!strings.HasPrefix(name, TypeDoubleDotPrefix) &&
-			addr >= module.types && addr < module.etypes {
-			module.typelinks = append(module.typelinks, int32(addr-module.types))
-			module.typemap[typeOff(addr-module.types)] = (*_type)(unsafe.Pointer(addr))
-		}
-	}
-	initmodule(codeModule.module, linker)
-
-	modulesLock.Lock()
-	addModule(codeModule)
-	modulesLock.Unlock()
-	additabs(codeModule.module)
-	moduledataverify1(codeModule.module)
-	modulesinit()
-	typelinksinit() // Deduplicate typelinks across all modules
-	return err
 }
 
 func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolMap map[string]uintptr) (err error) {
@@ -921,9 +951,6 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 	// uses *_type pointer equality and many overlapping or builtin types may be included twice
 	// We have to do this after adding the module to the linked list since deduplication
 	// depends on symbol resolution across all modules
-	typehash := make(map[uint32][]*_type, len(firstmoduledata.typelinks))
-	buildModuleTypeHash(activeModules()[0], typehash)
-
 	patchedTypeMethodsIfn := make(map[*_type]map[int]struct{})
 	patchedTypeMethodsTfn := make(map[*_type]map[int]struct{})
 	patchedTypeMethodsMtyp := make(map[*_type]map[int]typeOff)
@@ -931,6 +958,10 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 	byteorder := linker.Arch.ByteOrder
 	dedupedTypes := map[string]uintptr{}
 	for _, symbol := range linker.symMap {
+		if _, shared := linker.sharedSyms[symbol.Name]; shared {
+			// Not loaded again, see LoadInstance
+			continue
+		}
 		if linker.options.DumpTextBeforeAndAfterRelocs && linker.options.RelocationDebugWriter != nil && symbol.Kind == symkind.STEXT && symbol.Offset >= 0 {
 			_, _ = fmt.Fprintf(linker.options.RelocationDebugWriter, "BEFORE DEDUPE (%x - %x) %142s: %x\n", codeModule.codeBase+symbol.Offset, codeModule.codeBase+symbol.Offset+symbol.Size, symbol.Name, codeModule.codeByte[symbol.Offset:symbol.Offset+symbol.Size])
 		}
@@ -944,6 +975,10 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 				addrBase = segment.codeBase
 				relocByte = segment.codeByte
 			}
+			if _, shared := linker.sharedSyms[sym.Name]; shared {
+				// Already another instance's copy, deduplicated when that instance was loaded
+				continue
+			}
 			if addr != InvalidHandleValue && sym.Kind == symkind.SRODATA &&
 				strings.HasPrefix(sym.Name, TypePrefix) &&
 				!strings.HasPrefix(sym.Name, TypeDoubleDotPrefix) && sym.Offset != -1 {
@@ -952,9 +987,8 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 				// already known types from other modules to allow fast type assertion using *_type pointer equality
 				t := (*_type)(unsafe.Pointer(addr))
 				prevT := (*_type)(unsafe.Pointer(addr))
-				for _, candidate := range typehash[t.hash] {
-					seen := map[_typePair]struct{}{}
-					if typesEqual(t, candidate, seen) {
+				for _, candidate := range typeIdx.lookup(t.hash) {
+					if typesEqualCached(t, candidate) {
 						t = candidate
 						break
 					}
@@ -977,10 +1011,14 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 					}
 					u := t.uncommon()
 					prevU := prevT.uncommon()
-					err2 := codeModule.patchTypeMethodOffsets(t, u, prevU, patchedTypeMethodsIfn, patchedTypeMethodsTfn, patchedTypeMethodsMtyp)
+					err2 := codeModule.patchTypeMethodOffsets(linker.Arch, t, u, prevU, patchedTypeMethodsIfn, patchedTypeMethodsTfn, patchedTypeMethodsMtyp)
 					if err2 != nil {
 						return err2
 					}
+					if _, lazy := linker.lazyFuncs[symbol.Name]; lazy {
+						// The function's relocations (including this one) are only applied once it is bound
+						continue relocLoop
+					}
 
 					addr = uintptr(unsafe.Pointer(t))
 					if linker.options.RelocationDebugWriter != nil && loc.Offset != InvalidOffset {
@@ -993,9 +1031,13 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 							objabi.SymKind(symbol.Kind), objabi.SymKind(sym.Kind), relocType, addrBase, uintptr(unsafe.Pointer(&relocByte[loc.Offset])),
 							addr, int(addr)-addrBase, symbol.Name, sym.Name)
 					}
+					linker.relocOverflow = RelocInRange
 					switch loc.Type {
 					case reloctype.R_GOTPCREL:
-						linker.relocateGOTPCREL(addr, loc, relocByte)
+						err2 := linker.relocateGOTPCREL(addr, loc, &codeModule.segment)
+						if err2 != nil {
+							err = err2
+						}
 					case reloctype.R_PCREL:
 						err2 := linker.relocatePCREL(addr, loc, &codeModule.segment, relocByte, addrBase)
 						if err2 != nil {
@@ -1012,18 +1054,20 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 						// TODO - sanity check this
 						address := uintptr(int(addr) + loc.Add)
 						putAddress(byteorder, relocByte[loc.Offset:], uint64(address))
-					case reloctype.R_ADDROFF, reloctype.R_WEAKADDROFF:
-						offset := int(addr) - addrBase + loc.Add
-						if offset > 0x7FFFFFFF || offset < -0x80000000 {
-							err = fmt.Errorf("symName: %s %s offset: %d overflows!\n", objabi.RelocType(loc.Type), sym.Name, offset)
-						}
-						byteorder.PutUint32(relocByte[loc.Offset:], uint32(offset))
-					case reloctype.R_METHODOFF:
-						if loc.Sym.Kind == symkind.STEXT {
+					case reloctype.R_ADDROFF, reloctype.R_WEAKADDROFF, reloctype.R_METHODOFF:
+						if loc.Type == reloctype.R_METHODOFF && loc.Sym.Kind == symkind.STEXT {
 							addrBase = segment.codeBase
 						}
 						offset := int(addr) - addrBase + loc.Add
 						if offset > 0x7FFFFFFF || offset < -0x80000000 {
+							if loc.Add == 0 {
+								// The offset can't reach t, e.g. since the module was mapped out of range of the host binary
+								// (see mapModule), so leave it pointing at the module's own copy of the type, and have
+								// runtime.resolveTypeOff redirect that to t through the module's typemap instead
+								codeModule.module.typemap[typeOff(uintptr(unsafe.Pointer(prevT))-codeModule.module.types)] = t
+								linker.relocOverflow = RelocTypemap
+								break
+							}
 							err = fmt.Errorf("symName: %s %s offset: %d overflows!\n", objabi.RelocType(loc.Type), sym.Name, offset)
 						}
 						byteorder.PutUint32(relocByte[loc.Offset:], uint32(offset))
@@ -1033,6 +1077,7 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 						panic(fmt.Sprintf("unhandled reloc %s", objabi.RelocType(loc.Type)))
 						// TODO - should we attempt to rewrite other relocations which point at *_types too?
 					}
+					linker.recordReloc(codeModule, RelocPhaseDeduplicate, symbol, loc, addr)
 				}
 			}
 		}
@@ -1048,7 +1093,7 @@ func (linker *Linker) deduplicateTypeDescriptors(codeModule *CodeModule, symbolM
 	if err != nil {
 		return err
 	}
-	err = patchTypeMethodTextPtrs(uintptr(codeModule.codeBase), codeModule.patchedTypeMethodsIfn, codeModule.patchedTypeMethodsTfn)
+	codeModule.stats.ItabsPatched, err = patchTypeMethodTextPtrs(uintptr(codeModule.codeBase), codeModule.patchedTypeMethodsIfn, codeModule.patchedTypeMethodsTfn)
 
 	return err
 }
@@ -1075,10 +1120,9 @@ func (linker *Linker) buildExports(codeModule *CodeModule, symbolMap map[string]
 			fmTypeAddr, ok := symbolMap[FirstModulePrefix+info.TypeName]
 			if ok && fmTypeAddr != typeAddr {
 				// Prefer firstmodule types if equal (i.e. deduplicate)
-				seen := map[_typePair]struct{}{}
 				fmTyp := (*_type)(unsafe.Pointer(fmTypeAddr))
 				newTyp := (*_type)(unsafe.Pointer(typeAddr))
-				if fmTyp.hash == newTyp.hash && typesEqual(fmTyp, newTyp, seen) {
+				if fmTyp.hash == newTyp.hash && typesEqualCached(fmTyp, newTyp) {
 					typeAddr = fmTypeAddr
 				}
 			}
@@ -1112,8 +1156,44 @@ func (linker *Linker) buildExports(codeModule *CodeModule, symbolMap map[string]
 	}
 }
 
+// RebuildReason is why a symbol couldn't be resolved from the host binary, so why the package providing it has to be
+// built and loaded along with the module
+type RebuildReason int
+
+const (
+	RebuildUnresolvedSymbol   RebuildReason = iota // Missing from the host, e.g. because its linker found it unreachable
+	RebuildSkipDeduplication                       // In a package given to WithSkipTypeDeduplicationForPackages
+	RebuildUnreachableMethods                      // A host type of a package with methods the module calls but the host lacks
+	RebuildUnresolvedType                          // A host type which may have changed, so must be built to compare
+)
+
+func (r RebuildReason) String() string {
+	switch r {
+	case RebuildUnresolvedSymbol:
+		return "unresolved symbol"
+	case RebuildSkipDeduplication:
+		return "skip type deduplication"
+	case RebuildUnreachableMethods:
+		return "unreachable methods"
+	case RebuildUnresolvedType:
+		return "unresolved type"
+	}
+	return "unknown"
+}
+
+func (r RebuildReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
 func (linker *Linker) UnresolvedExternalSymbols(symbolMap map[string]uintptr, ignorePackages []string, stdLibPkgs map[string]struct{}, unsafeBlindlyUseFirstModuleTypes bool) map[string]*obj.Sym {
+	symMap, _ := linker.UnresolvedExternalSymbolReasons(symbolMap, ignorePackages, stdLibPkgs, unsafeBlindlyUseFirstModuleTypes)
+	return symMap
+}
+
+// UnresolvedExternalSymbolReasons is UnresolvedExternalSymbols, also returning why each symbol is unresolved
+func (linker *Linker) UnresolvedExternalSymbolReasons(symbolMap map[string]uintptr, ignorePackages []string, stdLibPkgs map[string]struct{}, unsafeBlindlyUseFirstModuleTypes bool) (map[string]*obj.Sym, map[string]RebuildReason) {
 	symMap := make(map[string]*obj.Sym)
+	reasons := make(map[string]RebuildReason)
 	for symName, sym := range linker.symMap {
 		shouldSkipDedup := false
 		for _, pkgPath := range ignorePackages {
@@ -1147,6 +1227,10 @@ func (linker *Linker) UnresolvedExternalSymbols(symbolMap map[string]uintptr, ig
 						// Only rebuild types which are reachable (via relocs) from the main package, otherwise we'll end up building everything unnecessarily
 						if (linker.isTypeReachable(symName) && !unsafeBlindlyUseFirstModuleTypes) || firstModuleTypeHasUnreachableMethods {
 							symMap[symName] = sym
+							reasons[symName] = RebuildUnresolvedType
+							if _, forced := linker.pkgNamesToForceRebuild[sym.Pkg]; forced || firstModuleTypeHasUnreachableMethods {
+								reasons[symName] = RebuildUnreachableMethods
+							}
 						}
 					}
 				}
@@ -1155,6 +1239,10 @@ func (linker *Linker) UnresolvedExternalSymbols(symbolMap map[string]uintptr, ig
 				if _, ok := linker.objsymbolMap[symName]; !ok || shouldSkipDedup {
 					if linker.isSymbolReachable(symName) {
 						symMap[symName] = sym
+						reasons[symName] = RebuildUnresolvedSymbol
+						if shouldSkipDedup {
+							reasons[symName] = RebuildSkipDeduplication
+						}
 					}
 				}
 			}
@@ -1176,7 +1264,7 @@ func (linker *Linker) UnresolvedExternalSymbols(symbolMap map[string]uintptr, ig
 			}
 		}
 	}
-	return symMap
+	return symMap, reasons
 }
 
 func (linker *Linker) UnresolvedPackageReferences(existingPkgs []string) []string {
@@ -1235,31 +1323,65 @@ func (linker *Linker) UnloadStrings() {
 	linker.heapStringMap = nil
 }
 
+// Release drops all the parsed object file state and intermediate buffers held by the linker, and unmaps the archives
+// its symbol data was read from. Modules already loaded from the linker are unaffected, but it can't be loaded again.
+func (linker *Linker) Release() error {
+	var err error
+	for _, archive := range linker.archives {
+		if archive == nil {
+			continue
+		}
+		if err2 := Munmap(archive); err2 != nil && err == nil {
+			err = fmt.Errorf("failed to unmap archive: %w", err2)
+		}
+	}
+	*linker = Linker{Arch: linker.Arch, options: linker.options, released: true}
+	return err
+}
+
 func Load(linker *Linker, symPtr map[string]uintptr) (codeModule *CodeModule, err error) {
+	if linker.released {
+		return nil, fmt.Errorf("can't load a linker after Release")
+	}
 	codeModule = &CodeModule{
 		Syms:   make(map[string]uintptr),
 		module: &moduledata{typemap: make(map[typeOff]*_type)},
 	}
+	codeModule.stats = linker.stats.clone()
+	endPhase := codeModule.stats.StartPhase("map")
 	codeModule.codeLen = len(linker.code)
 	codeModule.dataLen = len(linker.data)
 	codeModule.noptrdataLen = len(linker.noptrdata)
-	codeModule.bssLen = len(linker.bss)
-	codeModule.noptrbssLen = len(linker.noptrbss)
-	codeModule.sumDataLen = codeModule.dataLen + codeModule.noptrdataLen + codeModule.bssLen + codeModule.noptrbssLen
-	codeModule.maxCodeLength = alignof(codeModule.codeLen, PageSize)
+	codeModule.bssLen = linker.bssSize
+	codeModule.noptrbssLen = linker.noptrbssSize
+	codeModule.rodataLen = len(linker.rodata)
+	codeModule.rodataOff = linker.rodataOffset()
+	codeModule.sumDataLen = codeModule.rodataOff + codeModule.rodataLen
+	patchSpace := alignof(linker.options.FunctionPatchSpace, osPageSize)
+	codeModule.farRefs = linker.newFarRefTable(codeModule.codeLen + patchSpace)
+	codeModule.maxCodeLength = alignof(codeModule.farRefs.start+codeModule.farRefs.size, PageSize)
 	codeModule.maxDataLength = alignof(codeModule.sumDataLen, PageSize)
-	codeByte, err := Mmap(codeModule.maxCodeLength)
-	if err != nil {
-		return nil, err
+	if patchSpace > 0 {
+		// Patched functions follow the module's text, so that the runtime's function table can simply be extended
+		codeModule.patchArea = &patchArea{
+			textOff:   codeModule.codeLen,
+			textEnd:   codeModule.codeLen + patchSpace,
+			farRefOff: codeModule.maxCodeLength,
+			farRefEnd: codeModule.maxCodeLength + patchSpace,
+			dataOff:   alignof(codeModule.sumDataLen, osPageSize),
+		}
+		codeModule.patchArea.dataEnd = codeModule.patchArea.dataOff + patchSpace
+		codeModule.maxCodeLength = alignof(codeModule.patchArea.farRefEnd, PageSize)
+		codeModule.maxDataLength = alignof(codeModule.patchArea.dataEnd, PageSize)
 	}
-	dataByte, err := MmapData(codeModule.maxDataLength)
+	codeByte, dataByte, err := linker.mapModule(codeModule)
 	if err != nil {
 		return nil, err
 	}
 
 	codeModule.codeByte = codeByte
 	codeModule.codeBase = int((*sliceHeader)(unsafe.Pointer(&codeByte)).Data)
-	copy(codeModule.codeByte, linker.code)
+	linker.copySection(codeModule.codeByte, linker.code, 0, true)
 	codeModule.codeOff = codeModule.codeLen
 
 	codeModule.dataByte = dataByte
@@ -1268,26 +1390,72 @@ func Load(linker *Linker, symPtr map[string]uintptr) (codeModule *CodeModule, er
 	codeModule.dataOff = codeModule.dataLen
 	copy(codeModule.dataByte[codeModule.dataOff:], linker.noptrdata)
 	codeModule.dataOff += codeModule.noptrdataLen
-	copy(codeModule.dataByte[codeModule.dataOff:], linker.bss)
+	// bss and noptrbss are left as the untouched anonymous pages of the fresh mapping, so aren't committed until written
 	codeModule.dataOff += codeModule.bssLen
-	copy(codeModule.dataByte[codeModule.dataOff:], linker.noptrbss)
 	codeModule.dataOff += codeModule.noptrbssLen
+	linker.copySection(codeModule.dataByte, linker.rodata, codeModule.rodataOff, false)
+	codeModule.dataOff = codeModule.rodataOff + codeModule.rodataLen
+	endPhase()
 
 	var symbolMap map[string]uintptr
-	if symbolMap, err = linker.addSymbolMap(symPtr, codeModule); err == nil {
+	endPhase = codeModule.stats.StartPhase("relocate")
+	if err = linker.unprotectText(codeModule); err == nil {
+		symbolMap, err = linker.addSymbolMap(symPtr, codeModule)
+		linker.prepareLazyBinding(codeModule)
+	}
+	if err == nil {
 		if err = linker.relocate(codeModule, symbolMap); err == nil {
+			endPhase()
+			endPhase = codeModule.stats.StartPhase("build module")
 			if err = linker.buildModule(codeModule, symbolMap); err == nil {
+				endPhase()
+				endPhase = codeModule.stats.StartPhase("deduplicate types")
 				if err = linker.deduplicateTypeDescriptors(codeModule, symbolMap); err == nil {
+					endPhase()
+					endPhase = codeModule.stats.StartPhase("register")
 					linker.buildExports(codeModule, symbolMap)
+					linker.buildGlobals(codeModule, symbolMap)
+					linker.buildExportedTypes(codeModule, symbolMap)
+					linker.buildPatchInfo(codeModule, symbolMap, symPtr)
+					linker.buildSymbols(codeModule, symbolMap)
+					linker.buildCallSites(codeModule)
+					linker.registerLazyBinding(codeModule, symbolMap)
+					linker.registerWithDebuggers(codeModule, symbolMap)
 					MakeThreadJITCodeExecutable(uintptr(codeModule.codeBase), codeModule.maxCodeLength)
-					if err = linker.doInitialize(codeModule, symbolMap); err == nil {
-						return codeModule, err
+					if err = linker.registerWithProfilers(codeModule, symbolMap); err == nil {
+						if err = linker.protectModule(codeModule); err == nil {
+							endPhase()
+							endPhase = codeModule.stats.StartPhase("initialize")
+							if err = linker.doInitialize(codeModule, symbolMap); err == nil {
+								endPhase()
+								linker.countLoadStats(codeModule)
+								return codeModule, err
+							}
+						}
 					}
 				}
 			}
 		}
 	}
 	if err != nil {
+		endPhase()
+		modulesLock.Lock()
+		if modules[codeModule] {
+			// The module was added before failing, so mustn't be left visible to the runtime or to Modules
+			removeModule(codeModule)
+			modulesLock.Unlock()
+			removeitabs(codeModule.module)
+			modulesinit()
+		} else {
+			modulesLock.Unlock()
+		}
+		// Forget anything cached about types in the mapping we're about to release
+		typeIdx.removeModule(codeModule)
+		codeModule.unregisterLazyBinding()
+		codeModule.unregisterFromDebuggers()
+		_ = codeModule.unregisterFromProfilers()
+		_ = codeModule.releaseHostTrampolines()
+		_ = codeModule.releasePclnTables()
 		err2 := Munmap(codeByte)
 		err3 := Munmap(dataByte)
 		if err2 != nil {
@@ -1301,7 +1469,17 @@ func Load(linker *Linker, symPtr map[string]uintptr) (codeModule *CodeModule, er
 }
 
 func (cm *CodeModule) Unload() error {
-	err := cm.revertPatchedTypeMethods()
+	modulesLock.Lock()
+	instances := cm.instances
+	modulesLock.Unlock()
+	if instances > 0 {
+		return fmt.Errorf("can't unload a module while %d other instances of it share its text and types", instances)
+	}
+	err := cm.revertFunctionPatches()
+	if err != nil {
+		return err
+	}
+	err = cm.revertPatchedTypeMethods()
 	if err != nil {
 		return err
 	}
@@ -1309,15 +1487,32 @@ func (cm *CodeModule) Unload() error {
 	runtime.GC()
 	modulesLock.Lock()
 	removeModule(cm)
+	if cm.instanceOf != nil {
+		cm.instanceOf.instances--
+		cm.instanceOf = nil
+	}
 	modulesLock.Unlock()
 	modulesinit()
+	cm.unregisterLazyBinding()
+	cm.unregisterFromDebuggers()
+	forgetDisassembly(cm)
+	err3 := cm.unregisterFromProfilers()
+	if err4 := cm.releaseHostTrampolines(); err3 == nil {
+		err3 = err4
+	}
+	if err4 := cm.releasePclnTables(); err3 == nil {
+		err3 = err4
+	}
 	err1 := Munmap(cm.codeByte)
 	err2 := Munmap(cm.dataByte)
 	if err1 != nil {
 		return err1
 	}
 	cm.heapStrings = nil
-	return err2
+	if err2 != nil {
+		return err2
+	}
+	return err3
 }
 
 func (cm *CodeModule) TextAddr() (start, end uintptr) {
@@ -1331,7 +1526,7 @@ func (cm *CodeModule) DataAddr() (start, end uintptr) {
 	if cm.module == nil {
 		return 0, 0
 	}
-	return cm.module.data, cm.module.enoptrbss
+	return cm.module.data, cm.module.end
 }
 
 func CastToFuncUnsafe[T any](addr uintptr) T {
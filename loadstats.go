@@ -0,0 +1,208 @@
+package goloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/trace"
+	"sort"
+	"time"
+)
+
+// LoadPhase is the time spent in one phase of building or loading a module
+type LoadPhase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// LoadStats describes where the time went while a module was built and loaded, and how much work each phase did.
+// CodeModule.Stats covers reading the module's objects and loading it, while the jit package's LoadableUnit.Stats
+// adds go list, the builds of the package and of any dependencies missing from the host, and the reading of objects
+// needed only to find those dependencies.
+type LoadStats struct {
+	Phases []LoadPhase // In the order each phase first started
+
+	PackagesBuilt     int            // Dependencies compiled from source because the host lacked them, see jit
+	PackagesReused    int            // Dependencies resolved entirely from the host binary, see jit
+	Packages          int            // Packages read by the linker
+	Symbols           int            // Symbols read by the linker, including those resolved from the host
+	Relocations       map[string]int // Applied when the module was first linked, by type
+	Epilogues         int            // Relocations out of range of their instruction, resolved via an epilogue
+	Trampolines       int            // Trampolines added to the far reference table, see FarRefStats
+	GOTSlots          int            // GOT slots added to the far reference table, see FarRefStats
+	TypesDeduplicated int            // Type descriptors redirected to an equal one of the host or another module
+	ItabsPatched      int            // Itabs of the host whose missing methods were pointed at the module
+	CodeBytes         int            // Text, including epilogues and the far reference table
+	DataBytes         int            // Data, noptrdata, bss, noptrbss and rodata
+}
+
+// Phase returns the time spent in the named phase, or 0 if it never ran
+func (s LoadStats) Phase(name string) time.Duration {
+	for _, phase := range s.Phases {
+		if phase.Name == name {
+			return phase.Duration
+		}
+	}
+	return 0
+}
+
+// Total returns the time spent in all phases
+func (s LoadStats) Total() (total time.Duration) {
+	for _, phase := range s.Phases {
+		total += phase.Duration
+	}
+	return total
+}
+
+// StartPhase starts timing the named phase, which is also marked as a runtime/trace region while it runs, and
+// returns a func which ends it. Ending a phase more than once has no effect, and timing a phase again adds to its
+// duration.
+func (s *LoadStats) StartPhase(name string) (end func()) {
+	region := trace.StartRegion(context.Background(), "goloader: "+name)
+	start := time.Now()
+	ended := false
+	return func() {
+		if !ended {
+			ended = true
+			region.End()
+			s.AddPhase(name, time.Since(start))
+		}
+	}
+}
+
+// AddPhase adds d to the duration of the named phase
+func (s *LoadStats) AddPhase(name string, d time.Duration) {
+	for i := range s.Phases {
+		if s.Phases[i].Name == name {
+			s.Phases[i].Duration += d
+			return
+		}
+	}
+	s.Phases = append(s.Phases, LoadPhase{Name: name, Duration: d})
+}
+
+// Merge adds other's phase durations and counters to s
+func (s *LoadStats) Merge(other LoadStats) {
+	for _, phase := range other.Phases {
+		s.AddPhase(phase.Name, phase.Duration)
+	}
+	s.PackagesBuilt += other.PackagesBuilt
+	s.PackagesReused += other.PackagesReused
+	s.Packages += other.Packages
+	s.Symbols += other.Symbols
+	for typ, n := range other.Relocations {
+		if s.Relocations == nil {
+			s.Relocations = map[string]int{}
+		}
+		s.Relocations[typ] += n
+	}
+	s.Epilogues += other.Epilogues
+	s.Trampolines += other.Trampolines
+	s.GOTSlots += other.GOTSlots
+	s.TypesDeduplicated += other.TypesDeduplicated
+	s.ItabsPatched += other.ItabsPatched
+	s.CodeBytes += other.CodeBytes
+	s.DataBytes += other.DataBytes
+}
+
+func (s LoadStats) clone() LoadStats {
+	s.Phases = append([]LoadPhase(nil), s.Phases...)
+	relocs := s.Relocations
+	s.Relocations = make(map[string]int, len(relocs))
+	for typ, n := range relocs {
+		s.Relocations[typ] = n
+	}
+	return s
+}
+
+// WriteTo writes the stats to w as a human-readable summary
+func (s LoadStats) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	printf := func(format string, args ...interface{}) error {
+		m, err := fmt.Fprintf(w, format, args...)
+		n += int64(m)
+		return err
+	}
+	for _, phase := range s.Phases {
+		if err := printf("%-24s %v\n", phase.Name, phase.Duration); err != nil {
+			return n, err
+		}
+	}
+	if err := printf("%-24s %v\n", "total", s.Total()); err != nil {
+		return n, err
+	}
+	counters := []struct {
+		name  string
+		value int
+	}{
+		{"packages built", s.PackagesBuilt},
+		{"packages reused", s.PackagesReused},
+		{"packages", s.Packages},
+		{"symbols", s.Symbols},
+		{"epilogues", s.Epilogues},
+		{"trampolines", s.Trampolines},
+		{"got slots", s.GOTSlots},
+		{"types deduplicated", s.TypesDeduplicated},
+		{"itabs patched", s.ItabsPatched},
+		{"code bytes", s.CodeBytes},
+		{"data bytes", s.DataBytes},
+	}
+	for _, counter := range counters {
+		if err := printf("%-24s %d\n", counter.name, counter.value); err != nil {
+			return n, err
+		}
+	}
+	types := make([]string, 0, len(s.Relocations))
+	for typ := range s.Relocations {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		if err := printf("%-24s %d\n", "relocs "+typ, s.Relocations[typ]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// countReloc counts a relocation towards the module's LoadStats. Those redirected by type deduplication were already
+// counted when first applied, and those applied by lazy binding happen after loading.
+func (cm *CodeModule) countReloc(phase RelocPhase, typ string, overflow RelocOverflow) {
+	if phase != RelocPhaseRelocate {
+		return
+	}
+	if cm.stats.Relocations == nil {
+		cm.stats.Relocations = map[string]int{}
+	}
+	cm.stats.Relocations[typ]++
+	if overflow == RelocEpilogue {
+		cm.stats.Epilogues++
+	}
+}
+
+// Stats returns the phases of reading the linker's objects, which every module loaded from it starts with. Its counters
+// are only filled in by Load.
+func (linker *Linker) Stats() LoadStats {
+	return linker.stats.clone()
+}
+
+// countLoadStats fills in the counters of the module's LoadStats which are known once it's loaded
+func (linker *Linker) countLoadStats(codeModule *CodeModule) {
+	stats := &codeModule.stats
+	stats.Packages = len(linker.pkgs)
+	stats.Symbols = len(linker.symMap)
+	stats.TypesDeduplicated = len(codeModule.deduplicatedTypes)
+	stats.CodeBytes = codeModule.codeLen
+	if codeModule.farRefs != nil {
+		stats.Trampolines = codeModule.farRefs.trampolines
+		stats.GOTSlots = codeModule.farRefs.gotSlots
+		stats.CodeBytes += codeModule.farRefs.used
+	}
+	stats.DataBytes = codeModule.sumDataLen
+}
+
+// Stats returns the module's LoadStats. Its phases start with reading the module's objects, which is shared by every
+// instance loaded from the same Linker.
+func (cm *CodeModule) Stats() LoadStats {
+	return cm.stats.clone()
+}
@@ -0,0 +1,200 @@
+package goloader
+
+import (
+	"cmd/objfile/objabi"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/eihigh/goloader/obj"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// NoMigrateMarker can be placed in the doc comment or line comment of a package level var declaration
+// to exclude it from MigrateGlobals, e.g.
+//
+//	//goloader:nomigrate
+//	var startedAt = time.Now()
+const NoMigrateMarker = "//goloader:nomigrate"
+
+type MigrateOptions struct {
+	// Allow restricts migration to these symbols (e.g. "github.com/org/pkg.Cache") or packages (e.g. "github.com/org/pkg").
+	// If empty, all package level variables present in both modules are candidates for migration
+	Allow []string
+	// Deny excludes these symbols or packages from migration, and takes precedence over Allow
+	Deny []string
+}
+
+type MigrationReport struct {
+	Migrated     []string         // Variables whose values were converted and copied into the new module
+	Skipped      []string         // Variables excluded by MigrateOptions or a NoMigrateMarker
+	Missing      []string         // Variables present in the old module but not in the new module
+	Incompatible map[string]error // Variables whose old and new types could not be converted
+}
+
+// globalVar records the address and declared type of a package level variable whose storage lives in a CodeModule's data segment
+type globalVar struct {
+	addr uintptr
+	typ  *_type
+}
+
+func (linker *Linker) buildGlobals(codeModule *CodeModule, symbolMap map[string]uintptr) {
+	codeModule.globals = map[string]globalVar{}
+	dataStart := uintptr(codeModule.dataBase)
+	dataEnd := dataStart + uintptr(codeModule.sumDataLen)
+	for _, pkg := range linker.pkgs {
+		pkgPrefix := objabi.PathToPrefix(pkg.PkgPath) + "."
+		for name, objSym := range pkg.Syms {
+			switch objSym.Kind {
+			case symkind.SDATA, symkind.SBSS, symkind.SNOPTRDATA, symkind.SNOPTRBSS:
+			default:
+				continue
+			}
+			if !strings.HasPrefix(name, pkgPrefix) || objSym.Type == EmptyString || strings.HasPrefix(objSym.Type, obj.UnresolvedSymRefPrefix) {
+				continue
+			}
+			// Compiler generated statics (e.g. pkg..stmp_0, pkg..inittask, closure vars·f) aren't user declared variables
+			varName := strings.TrimPrefix(name, pkgPrefix)
+			if varName == "_" || strings.ContainsAny(varName, ".·") {
+				continue
+			}
+			addr, ok := symbolMap[name]
+			if !ok || addr < dataStart || addr >= dataEnd {
+				// Either unreachable, or the variable was resolved to the first module's copy, so there's nothing to migrate
+				continue
+			}
+			typeAddr, ok := symbolMap[objSym.Type]
+			if !ok {
+				continue
+			}
+			if dup, ok := codeModule.deduplicatedTypes[objSym.Type]; ok {
+				typeAddr = dup
+			}
+			codeModule.globals[name] = globalVar{addr: addr, typ: (*_type)(unsafe.Pointer(typeAddr))}
+		}
+	}
+}
+
+// ExcludeFromMigration marks package level variables (by symbol name) as never to be migrated by MigrateGlobals,
+// whether this module is the source or the destination of the migration.
+func (cm *CodeModule) ExcludeFromMigration(symNames ...string) {
+	if cm.noMigrate == nil {
+		cm.noMigrate = map[string]struct{}{}
+	}
+	for _, symName := range symNames {
+		cm.noMigrate[symName] = struct{}{}
+	}
+}
+
+func symbolListContains(list []string, symName string) bool {
+	for _, entry := range list {
+		if symName == entry || strings.HasPrefix(symName, entry+".") || strings.HasPrefix(symName, objabi.PathToPrefix(entry)+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateGlobals copies the values of package level variables from oldModule into the equivalent variables of newModule,
+// pairing them by symbol name. Each value is converted with the same machinery as ConvertTypesAcrossModules, so any
+// itabs, function pointers and types reachable from the old value are translated to their newModule equivalents.
+// This should be called after newModule has been loaded (and so its init funcs have run), but before oldModule is unloaded.
+// Note that as with ConvertTypesAcrossModules, memory reachable from the old values may be modified in place.
+func MigrateGlobals(oldModule, newModule *CodeModule, opts MigrateOptions) (*MigrationReport, error) {
+	if oldModule == nil || newModule == nil {
+		return nil, fmt.Errorf("can't migrate globals between nil modules")
+	}
+	report := &MigrationReport{Incompatible: map[string]error{}}
+
+	names := make([]string, 0, len(oldModule.globals))
+	for name := range oldModule.globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldVar := oldModule.globals[name]
+		newVar, ok := newModule.globals[name]
+		if !ok {
+			report.Missing = append(report.Missing, name)
+			continue
+		}
+		_, oldNoMigrate := oldModule.noMigrate[name]
+		_, newNoMigrate := newModule.noMigrate[name]
+		if oldNoMigrate || newNoMigrate || symbolListContains(opts.Deny, name) || (len(opts.Allow) > 0 && !symbolListContains(opts.Allow, name)) {
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+		if err := migrateGlobal(oldModule, newModule, oldVar, newVar); err != nil {
+			report.Incompatible[name] = err
+			continue
+		}
+		report.Migrated = append(report.Migrated, name)
+	}
+	return report, nil
+}
+
+func migrateGlobal(oldModule, newModule *CodeModule, oldVar, newVar globalVar) error {
+	// Convert via a pointer to the variable, since boxing an interface typed variable directly would lose its static type
+	oldPtr := reflect.NewAt(AsRType(oldVar.typ), unsafe.Pointer(oldVar.addr)).Interface()
+	newPtrType := reflect.PointerTo(AsRType(newVar.typ))
+	converted, err := ConvertTypesAcrossModules(oldModule, newModule, oldPtr, newPtrType)
+	if err != nil {
+		return err
+	}
+	convertedVal := reflect.ValueOf(converted)
+	if convertedVal.Type() != newPtrType {
+		return fmt.Errorf("converted value has type %s, expected %s", convertedVal.Type(), newPtrType)
+	}
+	reflect.NewAt(AsRType(newVar.typ), unsafe.Pointer(newVar.addr)).Elem().Set(convertedVal.Elem())
+	return nil
+}
+
+// NoMigrateGlobals parses the given Go source files of package pkgPath and returns the symbol names of all
+// package level variables annotated with NoMigrateMarker, suitable for passing to CodeModule.ExcludeFromMigration.
+func NoMigrateGlobals(pkgPath string, files []string) ([]string, error) {
+	var symNames []string
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		for _, decl := range f.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.VAR {
+				continue
+			}
+			wholeDecl := hasNoMigrateMarker(genDecl.Doc)
+			for _, spec := range genDecl.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+				if !wholeDecl && !hasNoMigrateMarker(valueSpec.Doc) && !hasNoMigrateMarker(valueSpec.Comment) {
+					continue
+				}
+				for _, ident := range valueSpec.Names {
+					if ident.Name != "_" {
+						symNames = append(symNames, objabi.PathToPrefix(pkgPath)+"."+ident.Name)
+					}
+				}
+			}
+		}
+	}
+	return symNames, nil
+}
+
+func hasNoMigrateMarker(group *ast.CommentGroup) bool {
+	if group == nil {
+		return false
+	}
+	for _, comment := range group.List {
+		if strings.HasPrefix(comment.Text, NoMigrateMarker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+//go:build !darwin || !arm64
+// +build !darwin !arm64
+
+package mmap
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// MmapAnywhere maps a module's code and data wherever the OS chooses to put them, rather than within 32 bits of the
+// first module. This is only usable for modules which reach everything outside themselves through absolute addresses,
+// but since the module's code still addresses its own data PC relatively, the two mappings must be within 32 bits of each other.
+func MmapAnywhere(codeSize, dataSize int) (code, data []byte, err error) {
+	code, err = mmapCode(codeSize, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err = mmapData(dataSize, 0)
+	if err != nil {
+		_ = Munmap(code)
+		return nil, nil, err
+	}
+	codeStart, dataStart := uintptr(unsafe.Pointer(&code[0])), uintptr(unsafe.Pointer(&data[0]))
+	lo, hi := codeStart, dataStart+uintptr(len(data))
+	if dataStart < codeStart {
+		lo, hi = dataStart, codeStart+uintptr(len(code))
+	}
+	if hi-lo > 1<<31 {
+		_ = Munmap(code)
+		_ = Munmap(data)
+		return nil, nil, fmt.Errorf("code mapping %p and data mapping %p are not within 32 bits of each other", &code[0], &data[0])
+	}
+	return code, data, nil
+}
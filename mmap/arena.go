@@ -0,0 +1,225 @@
+//go:build (darwin && !arm64) || freebsd || linux || netbsd
+// +build darwin,!arm64 freebsd linux netbsd
+
+package mmap
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const arenaEnabled = true
+
+// All relocations between JIT modules and the host binary must fit in a signed 32-bit PC relative offset
+const maxDistanceFromFirstModule = 1 << 31
+
+// ArenaReservationSize is the size of each PROT_NONE region reserved for sub-allocating module code and data.
+// Reserving address space is cheap, since no memory is committed until a mapping is handed out.
+var ArenaReservationSize uintptr = 1 << 30
+
+type span struct {
+	start uintptr
+	end   uintptr
+}
+
+type reservation struct {
+	span
+	free []span // sorted by address, never adjacent
+}
+
+type arena struct {
+	sync.Mutex
+	reservations []*reservation
+	live         map[uintptr]uintptr // start -> end of mappings handed out
+}
+
+var jitArena = &arena{live: map[uintptr]uintptr{}}
+
+// ArenaStats describes the address space reserved for JIT modules near the host binary, and how much of it is in use
+type ArenaStats struct {
+	Reservations   int     // Number of reserved regions
+	ReservedBytes  uintptr // Total size of reserved regions
+	CommittedBytes uintptr // Bytes currently handed out as code or data mappings
+	FreeBytes      uintptr // Bytes available for reuse without reserving more address space
+	LargestFree    uintptr // Largest contiguous free range
+	Mappings       int     // Number of live code or data mappings
+}
+
+func ArenaUsage() ArenaStats {
+	jitArena.Lock()
+	defer jitArena.Unlock()
+	stats := ArenaStats{Reservations: len(jitArena.reservations), Mappings: len(jitArena.live)}
+	for _, r := range jitArena.reservations {
+		stats.ReservedBytes += r.end - r.start
+		for _, s := range r.free {
+			stats.FreeBytes += s.end - s.start
+			if s.end-s.start > stats.LargestFree {
+				stats.LargestFree = s.end - s.start
+			}
+		}
+	}
+	stats.CommittedBytes = stats.ReservedBytes - stats.FreeBytes
+	return stats
+}
+
+func (r *reservation) alloc(size uintptr) (uintptr, bool) {
+	for i, s := range r.free {
+		if s.end-s.start < size {
+			continue
+		}
+		addr := s.start
+		if s.end-s.start == size {
+			r.free = append(r.free[:i], r.free[i+1:]...)
+		} else {
+			r.free[i].start += size
+		}
+		return addr, true
+	}
+	return 0, false
+}
+
+func (r *reservation) release(s span) {
+	i := sort.Search(len(r.free), func(i int) bool { return r.free[i].start >= s.end })
+	r.free = append(r.free, span{})
+	copy(r.free[i+1:], r.free[i:])
+	r.free[i] = s
+	// Coalesce with the following and preceding ranges
+	if i+1 < len(r.free) && r.free[i].end == r.free[i+1].start {
+		r.free[i].end = r.free[i+1].end
+		r.free = append(r.free[:i+1], r.free[i+2:]...)
+	}
+	if i > 0 && r.free[i-1].end == r.free[i].start {
+		r.free[i-1].end = r.free[i].end
+		r.free = append(r.free[:i], r.free[i+1:]...)
+	}
+}
+
+// reserve maps a new PROT_NONE region of at least minSize bytes within range of the first module, preferably above it,
+// where the host binary's method offsets can reach it too (see goloader.ErrOutOfRangeOfHost)
+func (a *arena) reserve(minSize uintptr) (*reservation, error) {
+	firstModuleAddr := uintptr(activeModules()[0])
+	mappings, err := getCurrentProcMaps()
+	if err != nil {
+		return nil, err
+	}
+	gaps, err := findFreeAddressesNearTarget(firstModuleAddr, int(minSize), maxDistanceFromFirstModule, mappings)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoMappingInRange, err)
+	}
+	for _, g := range gaps {
+		size := ArenaReservationSize
+		if size < minSize {
+			size = minSize
+		}
+		if g.endAddr-g.startAddr < size {
+			size = g.endAddr - g.startAddr
+		}
+		start := g.startAddr
+		if g.endAddr <= firstModuleAddr {
+			// Below the first module, take the top of the gap, nearest to it
+			start = g.endAddr - size
+		}
+		addr, err := mmap(start, size, syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON|arenaReserveFlags, -1, 0)
+		if err != nil {
+			// Most likely EEXIST from MAP_FIXED_NOREPLACE, meaning someone else mapped this gap since we read the process maps
+			continue
+		}
+		if addr != start && !inRangeOfFirstModule(addr, size, firstModuleAddr) {
+			// The kernel treated the address as a hint and put the reservation somewhere unusable
+			_ = munmap(addr, size)
+			continue
+		}
+		r := &reservation{span: span{addr, addr + size}, free: []span{{addr, addr + size}}}
+		a.reservations = append(a.reservations, r)
+		return r, nil
+	}
+	return nil, fmt.Errorf("%w, failed to reserve 0x%x bytes within 0x%x of it (0x%x), taken mappings: \n%s",
+		ErrNoMappingInRange, minSize, uintptr(maxDistanceFromFirstModule), firstModuleAddr, formatTakenMappings(mappings))
+}
+
+func inRangeOfFirstModule(addr, size, firstModuleAddr uintptr) bool {
+	if addr >= firstModuleAddr {
+		return addr+size-firstModuleAddr <= maxDistanceFromFirstModule
+	}
+	return firstModuleAddr-addr <= maxDistanceFromFirstModule
+}
+
+func (a *arena) acquire(size int, mapFunc func(size int, addr uintptr) ([]byte, error)) ([]byte, error) {
+	a.Lock()
+	defer a.Unlock()
+	length := roundPageUp(uintptr(size))
+	var addr uintptr
+	found := false
+	for _, r := range a.reservations {
+		if addr, found = r.alloc(length); found {
+			break
+		}
+	}
+	if !found {
+		r, err := a.reserve(length)
+		if err != nil {
+			return nil, err
+		}
+		addr, _ = r.alloc(length)
+	}
+	// Since the range is inside our own reservation, MAP_FIXED can't clobber anyone else's mapping
+	mapping, err := mapFunc(int(length), addr)
+	if err != nil {
+		a.reservationOf(addr).release(span{addr, addr + length})
+		return nil, err
+	}
+	if uintptr(unsafe.Pointer(&mapping[0])) != addr {
+		// The reservation wasn't replaced, so the range can go straight back to it
+		err = fmt.Errorf("mapping was placed at %p instead of the reserved address 0x%x", &mapping[0], addr)
+		if err2 := mapper.Munmap(mapping); err2 != nil {
+			err = fmt.Errorf("%w (and failed to munmap it: %v)", err, err2)
+		}
+		a.reservationOf(addr).release(span{addr, addr + length})
+		return nil, err
+	}
+	a.live[addr] = addr + length
+	return mapping, nil
+}
+
+func (a *arena) reservationOf(addr uintptr) *reservation {
+	for _, r := range a.reservations {
+		if addr >= r.start && addr < r.end {
+			return r
+		}
+	}
+	return nil
+}
+
+// release returns b's range to the arena if it was allocated from it, replacing its pages with inaccessible,
+// uncommitted ones so the memory is given back to the OS but the address space stays reserved
+func (a *arena) release(b []byte) (owned bool, err error) {
+	if len(b) == 0 {
+		return false, nil
+	}
+	addr := uintptr(unsafe.Pointer(&b[0]))
+	a.Lock()
+	defer a.Unlock()
+	end, ok := a.live[addr]
+	if !ok {
+		return false, nil
+	}
+	if _, err = mmap(addr, end-addr, syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON|syscall.MAP_FIXED|arenaReserveFlags&^mapFixedNoReplace, -1, 0); err != nil {
+		return true, os.NewSyscallError("syscall.Mmap", err)
+	}
+	mapper.forget(b)
+	delete(a.live, addr)
+	a.reservationOf(addr).release(span{addr, end})
+	return true, nil
+}
+
+func arenaAcquire(size int, mapFunc func(size int, addr uintptr) ([]byte, error)) ([]byte, error) {
+	return jitArena.acquire(size, mapFunc)
+}
+
+func arenaRelease(b []byte) (owned bool, err error) {
+	return jitArena.release(b)
+}
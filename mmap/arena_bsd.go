@@ -0,0 +1,9 @@
+//go:build (darwin && !arm64) || freebsd || netbsd
+// +build darwin,!arm64 freebsd netbsd
+
+package mmap
+
+// Without MAP_FIXED_NOREPLACE, the reservation address is only a hint, and reserve() checks where it ended up
+const mapFixedNoReplace = 0
+
+const arenaReserveFlags = 0
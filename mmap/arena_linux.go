@@ -0,0 +1,12 @@
+//go:build linux
+// +build linux
+
+package mmap
+
+import "syscall"
+
+// MAP_FIXED_NOREPLACE (Linux 4.17+) fails with EEXIST rather than clobbering an existing mapping.
+// Older kernels ignore it and treat the address as a hint, which reserve() also handles.
+const mapFixedNoReplace = 0x100000
+
+const arenaReserveFlags = syscall.MAP_NORESERVE | mapFixedNoReplace
@@ -0,0 +1,31 @@
+//go:build !((darwin && !arm64) || freebsd || linux || netbsd)
+// +build !darwin arm64
+// +build !freebsd
+// +build !linux
+// +build !netbsd
+
+package mmap
+
+// On windows and darwin/arm64, mappings are placed by AcquireMapping's probing instead
+const arenaEnabled = false
+
+type ArenaStats struct {
+	Reservations   int
+	ReservedBytes  uintptr
+	CommittedBytes uintptr
+	FreeBytes      uintptr
+	LargestFree    uintptr
+	Mappings       int
+}
+
+func ArenaUsage() ArenaStats {
+	return ArenaStats{}
+}
+
+func arenaAcquire(size int, mapFunc func(size int, addr uintptr) ([]byte, error)) ([]byte, error) {
+	panic("unreachable")
+}
+
+func arenaRelease(b []byte) (owned bool, err error) {
+	return false, nil
+}
@@ -1,6 +1,7 @@
 package mmap
 
 import (
+	"errors"
 	"fmt"
 	"github.com/eihigh/goloader/mmap/mapping"
 	"math"
@@ -10,6 +11,9 @@ import (
 	"unsafe"
 )
 
+// ErrNoMappingInRange is returned (wrapped) when no mapping could be placed within PC relative range of the first module
+var ErrNoMappingInRange = errors.New("failed to acquire a mapping within 32 bits of the first module address")
+
 var pageSize = uintptr(syscall.Getpagesize()) // Overridden for windows to use GetAllocationGranularity()
 
 func roundPageUp(p uintptr) uintptr {
@@ -20,6 +24,10 @@ func roundPageDown(p uintptr) uintptr {
 	return p & ^(pageSize - 1)
 }
 
+// Nothing is mapped below Linux's default vm.mmap_min_addr, even where privileges would allow it, so that dereferences
+// of nil pointers keep faulting
+const minMappingAddr = 0x10000
+
 type gap struct {
 	startAddr uintptr
 	endAddr   uintptr
@@ -53,14 +61,65 @@ func findNextFreeAddressesAfterTarget(targetAddr uintptr, size int, mappings []m
 	return suitableGaps, nil
 }
 
+// findFreeAddressesNearTarget returns the gaps between mappings with room for size bytes within maxDistance of
+// targetAddr, clipped to that range. Those above the target come first, nearest first, followed by those below it,
+// since the runtime only resolves method text offsets upwards from the host binary's text.
+func findFreeAddressesNearTarget(targetAddr uintptr, size int, maxDistance uintptr, mappings []mapping.Mapping) (gaps []gap, err error) {
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].StartAddr < mappings[j].StartAddr
+	})
+
+	lo, hi := uintptr(minMappingAddr), targetAddr+maxDistance
+	if targetAddr > maxDistance+minMappingAddr {
+		lo = targetAddr - maxDistance
+	}
+	if hi < targetAddr {
+		hi = ^uintptr(0)
+	}
+	prevEnd := uintptr(0)
+	for i := 0; i <= len(mappings); i++ {
+		g := gap{startAddr: prevEnd, endAddr: ^uintptr(0)}
+		if i < len(mappings) {
+			g.endAddr = roundPageDown(mappings[i].StartAddr)
+			prevEnd = roundPageUp(mappings[i].EndAddr)
+		}
+		if g.startAddr < lo {
+			g.startAddr = roundPageUp(lo - 1)
+		}
+		if g.endAddr > hi {
+			g.endAddr = roundPageDown(hi)
+		}
+		if g.endAddr > g.startAddr && int(g.endAddr-g.startAddr) >= size {
+			gaps = append(gaps, g)
+		}
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		aboveI, aboveJ := gaps[i].startAddr >= targetAddr, gaps[j].startAddr >= targetAddr
+		if aboveI != aboveJ {
+			return aboveI
+		}
+		if aboveI {
+			return gaps[i].startAddr < gaps[j].startAddr
+		}
+		return gaps[i].endAddr > gaps[j].endAddr
+	})
+	if len(gaps) == 0 {
+		return gaps, fmt.Errorf("could not find free address range with size 0x%x within 0x%x of target 0x%x", size, maxDistance, targetAddr)
+	}
+	return gaps, nil
+}
+
 //go:linkname activeModules runtime.activeModules
 func activeModules() []unsafe.Pointer
 
-// This isn't concurrency safe since other code outside goloader might mmap something in the same region we're trying to.
-// Ideally we would just skip these collisions via MAP_FIXED_NOREPLACE but this isn't portable...
+// Probing isn't concurrency safe since other code outside goloader might mmap something in the same region we're trying to,
+// so where supported, mappings are sub-allocated from an address range reserved up front instead (see arena.go)
 var mmapLock sync.Mutex
 
 func AcquireMapping(size int, mapFunc func(size int, addr uintptr) ([]byte, error)) ([]byte, error) {
+	if arenaEnabled {
+		return arenaAcquire(size, mapFunc)
+	}
 	mmapLock.Lock()
 	defer mmapLock.Unlock()
 
@@ -86,16 +145,16 @@ func AcquireMapping(size int, mapFunc func(size int, addr uintptr) ([]byte, erro
 				if uintptr(unsafe.Pointer(&mapping[len(mapping)-1]))-firstModuleAddr > 1<<32 {
 					err = Munmap(mapping)
 					if err != nil {
-						return nil, fmt.Errorf("failed to acquire a mapping within 32 bits of the first module address, wanted 0x%x, got %p - %p, also failed to munmap: %w", firstModuleAddr, &mapping[0], &mapping[len(mapping)-1], err)
+						return nil, fmt.Errorf("%w, wanted 0x%x, got %p - %p, also failed to munmap: %v", ErrNoMappingInRange, firstModuleAddr, &mapping[0], &mapping[len(mapping)-1], err)
 					}
-					return nil, fmt.Errorf("failed to acquire a mapping within 32 bits of the first module address, wanted 0x%x, got %p - %p", firstModuleAddr, &mapping[0], &mapping[len(mapping)-1])
+					return nil, fmt.Errorf("%w, wanted 0x%x, got %p - %p", ErrNoMappingInRange, firstModuleAddr, &mapping[0], &mapping[len(mapping)-1])
 				}
 				return mapping, nil
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("failed to aquire mapping between taken mappings: \n%s", formatTakenMappings(mappings))
+	return nil, fmt.Errorf("%w, failed to aquire mapping between taken mappings: \n%s", ErrNoMappingInRange, formatTakenMappings(mappings))
 }
 
 func formatTakenMappings(mappings []mapping.Mapping) string {
@@ -1,7 +1,10 @@
 package mmap
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/eihigh/goloader/mmap/mapping"
+	"os"
 	"testing"
 )
 
@@ -37,3 +40,115 @@ func TestMmapManager(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestArenaReuse(t *testing.T) {
+	if !arenaEnabled {
+		t.Skip("arena allocation not supported on this platform")
+	}
+	before := ArenaUsage()
+	code, err := Mmap(100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MmapData(300000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	during := ArenaUsage()
+	if during.Mappings != before.Mappings+2 {
+		t.Errorf("expected %d mappings, got %d", before.Mappings+2, during.Mappings)
+	}
+	if during.CommittedBytes < before.CommittedBytes+uintptr(len(code)+len(data)) {
+		t.Errorf("expected at least 0x%x committed bytes, got 0x%x", before.CommittedBytes+uintptr(len(code)+len(data)), during.CommittedBytes)
+	}
+	code[0] = 0xCC
+	data[len(data)-1] = 1
+
+	codeAddr := &code[0]
+	err = Munmap(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The freed range should be reused for an allocation of the same size, and come back zeroed
+	code2, err := Mmap(100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &code2[0] != codeAddr {
+		t.Errorf("expected freed range %p to be reused, got %p", codeAddr, &code2[0])
+	}
+	if code2[0] != 0 {
+		t.Errorf("expected reused range to be zeroed, got 0x%x", code2[0])
+	}
+	err = Munmap(code2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Munmap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := ArenaUsage()
+	if after.CommittedBytes != before.CommittedBytes || after.Mappings != before.Mappings {
+		t.Errorf("expected arena usage to return to %+v, got %+v", before, after)
+	}
+}
+
+func TestFindFreeAddressesNearTarget(t *testing.T) {
+	const target = 0x100000000
+	page := pageSize
+	mappings := []mapping.Mapping{
+		{StartAddr: target - 0x10*page, EndAddr: target + 0x10*page},   // The host binary
+		{StartAddr: target + 0x20*page, EndAddr: target + 0x1000*page}, // Fills most of the space above it
+		{StartAddr: target - 0x800*page, EndAddr: target - 0x20*page},  // And less of the space below it
+		{StartAddr: target + 0x2000*page, EndAddr: target + 0x2001*page},
+	}
+	maxDistance := 0x1800 * page
+	gaps, err := findFreeAddressesNearTarget(target, int(0x100*page), maxDistance, mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 2 {
+		t.Fatalf("expected a gap on either side of the target, got %+v", gaps)
+	}
+	if gaps[0].startAddr <= target || gaps[1].endAddr != target-0x800*page {
+		t.Errorf("expected the gap above the target first, even though the one below is nearer, got %+v", gaps)
+	}
+	for _, g := range gaps {
+		if g.startAddr < target-maxDistance || g.endAddr > target+maxDistance {
+			t.Errorf("expected gap 0x%x - 0x%x to be clipped to within 0x%x of 0x%x", g.startAddr, g.endAddr, maxDistance, target)
+		}
+	}
+
+	// Once the space above is taken, only the gap below is left
+	mappings = append(mappings, mapping.Mapping{StartAddr: target + 0x1000*page, EndAddr: target + 0x2000*page})
+	gaps, err = findFreeAddressesNearTarget(target, int(0x100*page), maxDistance, mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 || gaps[0].endAddr > target {
+		t.Errorf("expected only the gap below the target, got %+v", gaps)
+	}
+}
+
+func TestMmapFile(t *testing.T) {
+	contents := bytes.Repeat([]byte("goloader"), 4096)
+	f, err := os.CreateTemp(t.TempDir(), "mmapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(contents); err != nil {
+		t.Fatal(err)
+	}
+	data, err := MmapFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if !bytes.Equal(data, contents) {
+		t.Errorf("mapped contents differ from file contents")
+	}
+	if err = Munmap(data); err != nil {
+		t.Fatal(err)
+	}
+}
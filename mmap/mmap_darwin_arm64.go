@@ -35,6 +35,11 @@ func MmapData(size int) ([]byte, error) {
 	return AcquireMapping(size, mmapData)
 }
 
+// MmapAnywhere isn't supported on darwin/arm64, where mmap only takes the address as a hint
+func MmapAnywhere(codeSize, dataSize int) (code, data []byte, err error) {
+	return nil, nil, fmt.Errorf("mapping a module out of range of the first module is not supported on darwin/arm64")
+}
+
 func mmapCode(size int, addr uintptr) ([]byte, error) {
 	// darwin arm64 won't accept MAP_FIXED, but seems to take addr as a hint...
 	data, err := mapper.Mmap(
@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || linux || openbsd || solaris || netbsd
+// +build darwin dragonfly freebsd linux openbsd solaris netbsd
+
+package mmap
+
+import (
+	"os"
+	"syscall"
+)
+
+// MmapFile maps the whole of f read-only, so its contents can be read in place rather than copied onto the heap.
+// Pages are only read from disk when touched. The mapping stays valid after f is closed, and is released with Munmap.
+func MmapFile(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	data, err := mapper.Mmap(0, int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("syscall.Mmap", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,42 @@
+//go:build windows
+// +build windows
+
+package mmap
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"unsafe"
+)
+
+// MmapFile maps the whole of f read-only, so its contents can be read in place rather than copied onto the heap.
+// Pages are only read from disk when touched. The mapping stays valid after f is closed, and is released with Munmap.
+func MmapFile(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := int(info.Size())
+	if size == 0 {
+		return nil, nil
+	}
+	h, errno := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READONLY, 0, 0, nil)
+	if h == 0 {
+		return nil, os.NewSyscallError("CreateFileMapping", errno)
+	}
+	addr, errno := syscall.MapViewOfFile(h, syscall.FILE_MAP_READ, 0, 0, 0)
+	if addr == 0 {
+		_ = syscall.CloseHandle(h)
+		return nil, os.NewSyscallError("MapViewOfFile", errno)
+	}
+	if err := syscall.CloseHandle(h); err != nil {
+		return nil, os.NewSyscallError("CloseHandle", err)
+	}
+
+	var header reflect.SliceHeader
+	header.Data = addr
+	header.Len = size
+	header.Cap = size
+	return *(*[]byte)(unsafe.Pointer(&header)), nil
+}
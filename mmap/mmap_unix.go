@@ -56,6 +56,9 @@ func mmapData(size int, addr uintptr) ([]byte, error) {
 }
 
 func Munmap(b []byte) (err error) {
+	if owned, err := arenaRelease(b); owned {
+		return err
+	}
 	err = mapper.Munmap(b)
 	if err != nil {
 		err = os.NewSyscallError("syscall.Munmap", err)
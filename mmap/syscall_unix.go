@@ -92,3 +92,10 @@ func (m *mmapper) Munmap(data []byte) (err error) {
 	delete(m.active, p)
 	return nil
 }
+
+// forget drops a mapping from m without unmapping it, for when its address range has been remapped in place
+func (m *mmapper) forget(data []byte) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.active, &data[cap(data)-1])
+}
@@ -87,6 +87,4 @@ func initmodule(module *moduledata, linker *Linker) {
 	module.filetab = linker.filetab
 	module.hasmain = 0
 	module.bad = false
-	module.gofunc = module.noptrdata
-	module.rodata = module.noptrdata
 }
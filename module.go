@@ -117,4 +117,5 @@ func removeModule(cm *CodeModule) {
 		datap = datap.next
 	}
 	delete(modules, cm)
+	typeIdx.removeModule(cm)
 }
@@ -29,3 +29,9 @@ func MprotectMakeExecutable(page []byte) error {
 func MprotectMakeReadOnly(page []byte) error {
 	return syscall.Mprotect(page, syscall.PROT_READ)
 }
+
+// MprotectMakeWritableExecutable temporarily re-enables writes to executable pages without ever making them
+// non-executable, so that threads concurrently running code on them don't fault
+func MprotectMakeWritableExecutable(page []byte) error {
+	return syscall.Mprotect(page, syscall.PROT_READ|syscall.PROT_WRITE|syscall.PROT_EXEC)
+}
@@ -29,3 +29,9 @@ func MprotectMakeExecutable(page []byte) error {
 func MprotectMakeReadOnly(page []byte) error {
 	return VirtualProtect(uintptr(unsafe.Pointer(&page[0])), uintptr(len(page)), syscall.PAGE_READONLY)
 }
+
+// MprotectMakeWritableExecutable temporarily re-enables writes to executable pages without ever making them
+// non-executable, so that threads concurrently running code on them don't fault
+func MprotectMakeWritableExecutable(page []byte) error {
+	return VirtualProtect(uintptr(unsafe.Pointer(&page[0])), uintptr(len(page)), syscall.PAGE_EXECUTE_READWRITE)
+}
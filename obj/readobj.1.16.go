@@ -35,10 +35,17 @@ func (pkg *Pkg) Symbols() error {
 		case archive.EntryPkgDef:
 			// nothing todo
 		case archive.EntryGoObj:
-			b := make([]byte, e.Obj.Size)
-			_, err := pkg.F.ReadAt(b, e.Obj.Offset)
-			if err != nil {
-				return err
+			var b []byte
+			if pkg.Mapped != nil {
+				// Symbol data, pcdata etc. will alias the mapping, so is only paged in when the linker copies it into place
+				end := e.Obj.Offset + e.Obj.Size
+				b = pkg.Mapped[e.Obj.Offset:end:end]
+			} else {
+				b = make([]byte, e.Obj.Size)
+				_, err := pkg.F.ReadAt(b, e.Obj.Offset)
+				if err != nil {
+					return err
+				}
 			}
 			r := goobj.NewReaderFromBytes(b, false)
 			// Name of referenced indexed symbols.
@@ -303,10 +310,11 @@ func (pkg *Pkg) addSym(r *goobj.Reader, idx uint32, refNames *map[goobj.SymRef]s
 		// We handle this separately at the end of convertMachoRelocs() by adding the actual target address as text under this symbol name.
 		return
 	}
-	if symbol.Size > 0 {
+	if kind := objabi.SymKind(symbol.Kind); symbol.Size > 0 && kind != objabi.SBSS && kind != objabi.SNOPTRBSS {
 		symbol.Data = r.Data(idx)
 		grow(&symbol.Data, (int)(symbol.Size))
 	} else {
+		// BSS has no content to read, the linker only uses its Size
 		symbol.Data = make([]byte, 0)
 	}
 
@@ -20,6 +20,7 @@ type Pkg struct {
 	Arch           string
 	PkgPath        string
 	F              *os.File
+	Mapped         []byte // If set, F mapped read-only, which Go objects are read from in place
 	SymNameOrder   []string
 	Objidx         uint32 // index of this archive in the slice of files
 	ReferencedPkgs []string
@@ -0,0 +1,812 @@
+package goloader
+
+import (
+	"cmd/objfile/sys"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/eihigh/goloader/mprotect"
+	"github.com/eihigh/goloader/obj"
+	"github.com/eihigh/goloader/objabi/dataindex"
+	"github.com/eihigh/goloader/objabi/symkind"
+	"github.com/eihigh/goloader/stackobject"
+)
+
+// patchableFunc records enough about a function loaded into a CodeModule to tell whether a later build changed it
+type patchableFunc struct {
+	entry       uintptr
+	size        int
+	args        uint32
+	typeName    string
+	fingerprint uint64
+}
+
+type functionRedirect struct {
+	name     string
+	entry    uintptr
+	original []byte
+	prev     patchableFunc
+}
+
+// patchArea is the space reserved after a module for PatchFunctions, as offsets within its code and data mappings.
+// Each patch is placed after the previous one, so patches are reverted in the reverse order to which they were applied.
+type patchArea struct {
+	textOff, textEnd     int // Follows the module's text, so textOff is always the offset of the module's maxpc
+	farRefOff, farRefEnd int // Follows the module's far reference table
+	dataOff, dataEnd     int // Follows the module's read-only data, on pages of its own which stay writable
+}
+
+// pclnTables are the parts of a module's moduledata which PatchFunctions extends
+type pclnTables struct {
+	pcHeader    *pcHeader
+	funcnametab []byte
+	cutab       []uint32
+	filetab     []byte
+	pctab       []byte
+	pclntable   []byte
+	ftab        []functab
+	findfunctab uintptr
+	maxpc       uintptr // Also etext
+	end         uintptr // Also etypes
+}
+
+// FunctionPatch is the result of PatchFunctions, and can be used to undo it
+type FunctionPatch struct {
+	Functions []string // Symbol names of the functions in the target module which now jump to new bodies
+	target    *CodeModule
+	redirects []functionRedirect
+	area      patchArea  // The target's patch area before this patch was applied
+	tables    pclnTables // The target's function tables before this patch was applied
+	strings   []*string  // String constants only referred to by the new code, which must live as long as it does
+	applied   bool
+	reverted  bool
+}
+
+func symbolFingerprint(objsym *obj.ObjSymbol) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(objsym.Data)
+	var buf [8]byte
+	for _, reloc := range objsym.Reloc {
+		binary.LittleEndian.PutUint64(buf[:], uint64(reloc.Offset))
+		_, _ = h.Write(buf[:])
+		binary.LittleEndian.PutUint64(buf[:], uint64(reloc.Type))
+		_, _ = h.Write(buf[:])
+		binary.LittleEndian.PutUint64(buf[:], uint64(reloc.Add))
+		_, _ = h.Write(buf[:])
+		if reloc.Sym != nil {
+			_, _ = h.Write([]byte(reloc.Sym.Name))
+		}
+	}
+	return h.Sum64()
+}
+
+func (linker *Linker) buildPatchInfo(codeModule *CodeModule, symbolMap map[string]uintptr, symPtr map[string]uintptr) {
+	codeModule.symPtr = symPtr
+	codeModule.funcs = map[string]patchableFunc{}
+	codeModule.dataSyms = map[string]uintptr{}
+	if codeModule.patchArea != nil {
+		codeModule.dataFingerprints = map[string]uint64{}
+	}
+	codeStart := uintptr(codeModule.codeBase)
+	codeEnd := codeStart + uintptr(codeModule.codeLen)
+	dataStart := uintptr(codeModule.dataBase)
+	dataEnd := dataStart + uintptr(codeModule.sumDataLen)
+	for name, sym := range linker.symMap {
+		addr, ok := symbolMap[name]
+		if !ok || addr == InvalidHandleValue {
+			continue
+		}
+		if sym.Kind == symkind.STEXT {
+			objsym := linker.objsymbolMap[name]
+			if objsym == nil || objsym.Func == nil || addr < codeStart || addr >= codeEnd {
+				continue
+			}
+			codeModule.funcs[name] = patchableFunc{
+				entry:       addr,
+				size:        len(objsym.Data),
+				args:        objsym.Func.Args,
+				typeName:    objsym.Type,
+				fingerprint: symbolFingerprint(objsym),
+			}
+			continue
+		}
+		if dup, ok := codeModule.deduplicatedTypes[name]; ok {
+			addr = dup
+		} else if addr < dataStart || addr >= dataEnd {
+			continue
+		}
+		codeModule.dataSyms[name] = addr
+		if objsym := linker.objsymbolMap[name]; objsym != nil && codeModule.dataFingerprints != nil {
+			codeModule.dataFingerprints[name] = symbolFingerprint(objsym)
+		}
+	}
+}
+
+// enclosingFunc returns the name of the function a closure was declared in, e.g. pkg.F for pkg.F.func1.2
+func enclosingFunc(name string) (string, bool) {
+	for i := 0; i < len(name); {
+		j := strings.Index(name[i:], ".func")
+		if j < 0 {
+			break
+		}
+		i += j
+		if k := i + len(".func"); k < len(name) && name[k] >= '0' && name[k] <= '9' {
+			return name[:i], true
+		}
+		i++
+	}
+	return "", false
+}
+
+// PatchFunctions redirects functions in module whose bodies differ in newLinker (a rebuild of the same packages) to
+// their new versions, without reloading the module or migrating its state. module must have been loaded
+// WithFunctionPatchSpace, and only the new bodies of the changed functions (along with any functions, types and
+// read-only data which only they use) are relocated into that space. Everything else they refer to resolves to
+// module's own symbols, or to the symbols module was loaded against, so both versions share package variables, types
+// and itabs. The old entry points are then overwritten with a jump to the new bodies, so existing function values,
+// method tables and itabs all reach the new code. The new bodies are added to module's own function tables, so stack
+// traces, stack maps and GC of frames in either version remain correct.
+//
+// Patching is refused (and nothing is modified) if any changed function's signature or argument size differs, if a
+// closure and the function declaring it both changed (since the captured variables may be laid out differently), if
+// the new code uses a type whose layout changed, or package variables module doesn't have - in those cases a full
+// reload is needed.
+// Goroutines already executing an old body will finish it; only new calls run the new code.
+// Patches on the same module must be reverted in the reverse order to which they were applied.
+func PatchFunctions(module *CodeModule, newLinker *Linker) (*FunctionPatch, error) {
+	if module == nil || newLinker == nil {
+		return nil, fmt.Errorf("can't patch functions with a nil module or linker")
+	}
+	if newLinker.released {
+		return nil, fmt.Errorf("can't patch functions from a linker after Release")
+	}
+	if module.symPtr == nil {
+		return nil, fmt.Errorf("module has no recorded symbols to patch against, it must be loaded with Load")
+	}
+	if module.patchArea == nil {
+		return nil, fmt.Errorf("module has no space to patch functions into, it must be loaded WithFunctionPatchSpace")
+	}
+	if _, err := trampolineSize(newLinker.Arch, 0, 0); err != nil {
+		return nil, err
+	}
+
+	var changed, unpatchable []string
+	for name, oldFunc := range module.funcs {
+		if _, ok := newLinker.symMap[name]; !ok {
+			continue
+		}
+		objsym := newLinker.objsymbolMap[name]
+		if objsym == nil || objsym.Func == nil || symbolFingerprint(objsym) == oldFunc.fingerprint {
+			continue
+		}
+		if objsym.Func.Args != oldFunc.args || objsym.Type != oldFunc.typeName {
+			unpatchable = append(unpatchable, name+": signature changed")
+			continue
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	for _, name := range changed {
+		if outer, isClosure := enclosingFunc(name); isClosure {
+			if i := sort.SearchStrings(changed, outer); i < len(changed) && changed[i] == outer {
+				unpatchable = append(unpatchable, name+": closure changed along with its enclosing function "+outer)
+			}
+		}
+	}
+	if len(unpatchable) > 0 {
+		sort.Strings(unpatchable)
+		return nil, fmt.Errorf("can't patch functions, a full reload is required:\n    %s", strings.Join(unpatchable, "\n    "))
+	}
+	patch := &FunctionPatch{Functions: changed, target: module}
+	if len(changed) == 0 {
+		return patch, nil
+	}
+
+	builder := &patchBuilder{
+		linker:    newLinker,
+		target:    module,
+		changed:   map[string]bool{},
+		area:      *module.patchArea,
+		symbolMap: map[string]uintptr{},
+		local:     map[string]bool{},
+	}
+	for _, name := range changed {
+		builder.changed[name] = true
+	}
+	tables, err := builder.build(changed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load patched functions: %w", err)
+	}
+	for _, name := range changed {
+		oldFunc := module.funcs[name]
+		if size, _ := trampolineSize(newLinker.Arch, oldFunc.entry, builder.symbolMap[name]); size > oldFunc.size {
+			return nil, fmt.Errorf("function %s (%d bytes) is too small to patch with a %d byte trampoline", name, oldFunc.size, size)
+		}
+	}
+
+	if tables, err = module.sealPclnTables(tables); err != nil {
+		return nil, err
+	}
+	patch.area = *module.patchArea
+	patch.tables = module.module.pclnTables()
+	patch.strings = builder.strings
+	patch.applied = true
+	*module.patchArea = builder.area
+	setPclnTables(module.module, tables)
+	MakeThreadJITCodeExecutable(uintptr(module.codeBase+patch.area.textOff), builder.area.textOff-patch.area.textOff)
+	MakeThreadJITCodeExecutable(uintptr(module.codeBase+patch.area.farRefOff), builder.area.farRefOff-patch.area.farRefOff)
+
+	for _, name := range changed {
+		oldFunc := module.funcs[name]
+		var original []byte
+		original, err = module.writeTrampoline(newLinker.Arch, oldFunc.entry, builder.symbolMap[name])
+		if err != nil {
+			break
+		}
+		patch.redirects = append(patch.redirects, functionRedirect{name: name, entry: oldFunc.entry, original: original, prev: oldFunc})
+		// So that a later patch is compared against what's now running
+		oldFunc.fingerprint = symbolFingerprint(newLinker.objsymbolMap[name])
+		module.funcs[name] = oldFunc
+	}
+	module.patches = append(module.patches, patch)
+	if err != nil {
+		if err2 := patch.Revert(); err2 != nil {
+			err = fmt.Errorf("%w (and failed to revert the partial patch: %v)", err, err2)
+		}
+		return nil, err
+	}
+	return patch, nil
+}
+
+// patchBuilder lays out the symbols of a patch in its target module's patch area, and relocates them
+type patchBuilder struct {
+	linker    *Linker
+	target    *CodeModule
+	changed   map[string]bool
+	area      patchArea          // The next free offsets
+	symbolMap map[string]uintptr // Where each symbol the patch refers to resolves
+	local     map[string]bool    // Symbols loaded into the patch area
+	text      []*obj.Sym         // Functions loaded into the patch area in address order, rebased to their offsets in the target's code
+	data      []*obj.Sym         // Data loaded into the patch area, rebased to their offsets in the target's data
+	queue     []string
+	strings   []*string
+	errs      []string // Why a full reload is needed instead
+}
+
+// build loads the changed functions, and anything they refer to which the target doesn't already have, into the
+// target's patch area, and returns the target's function tables extended with the loaded functions
+func (b *patchBuilder) build(changed []string) (tables pclnTables, err error) {
+	target := b.target
+	// Earlier patches' code in the same pages may be running
+	for _, span := range [][2]int{{b.area.textOff, b.area.textEnd}, {b.area.farRefOff, b.area.farRefEnd}} {
+		var protect func() error
+		if protect, err = target.makeTextWritable(uintptr(target.codeBase+span[0]), span[1]-span[0]); err != nil {
+			return tables, err
+		}
+		defer func() {
+			if err2 := protect(); err2 != nil && err == nil {
+				err = err2
+			}
+		}()
+	}
+
+	if tlsG, ok := target.symPtr[TLSNAME]; ok {
+		b.symbolMap[TLSNAME] = tlsG
+	}
+	b.queue = append(b.queue, changed...)
+	for len(b.queue) > 0 {
+		name := b.queue[0]
+		b.queue = b.queue[1:]
+		if err = b.resolve(name); err != nil {
+			return tables, err
+		}
+	}
+	if len(b.errs) > 0 {
+		sort.Strings(b.errs)
+		return tables, fmt.Errorf("can't patch functions, a full reload is required:\n    %s", strings.Join(b.errs, "\n    "))
+	}
+
+	// Every target is already resolved, so relocate as when binding a function on first call, rather than deferring
+	// any relocations or registering itabs
+	codeModule := &CodeModule{segment: target.segment, module: &moduledata{typemap: map[typeOff]*_type{}}}
+	codeModule.farRefs = &farRefTable{start: b.area.farRefOff, size: b.area.farRefEnd - b.area.farRefOff, entries: map[farRefKey]int{}}
+	for _, syms := range [][]*obj.Sym{b.text, b.data} {
+		for _, sym := range syms {
+			if err = b.linker.relocateSymbol(codeModule, sym, b.symbolMap, true); err != nil {
+				return tables, err
+			}
+		}
+	}
+	b.area.farRefOff = alignof(b.area.farRefOff+codeModule.farRefs.used, farRefTrampolineSize)
+	return b.extendTables(target.module)
+}
+
+// resolve finds where the patch's references to name should go, loading it into the patch area if the target doesn't
+// have a usable copy
+func (b *patchBuilder) resolve(name string) error {
+	if _, ok := b.symbolMap[name]; ok || name == TLSNAME {
+		return nil
+	}
+	linker, target := b.linker, b.target
+	sym := linker.symMap[name]
+	switch {
+	case strings.HasPrefix(name, TypeStringPrefix):
+		str := target.heapStrings[name]
+		if str == nil {
+			if str = linker.heapStringMap[name]; str == nil {
+				return fmt.Errorf("impossible! got a nil string for symbol %s", name)
+			}
+			b.strings = append(b.strings, str)
+		}
+		if len(*str) == 0 {
+			// Any address will do, the length is 0, so it should never be read
+			b.symbolMap[name] = uintptr(unsafe.Pointer(str))
+		} else {
+			b.symbolMap[name] = (*reflect.StringHeader)(unsafe.Pointer(str)).Data
+		}
+		return nil
+	case sym == nil || sym.Offset == InvalidOffset:
+		if addr, ok := target.symPtr[name]; ok {
+			b.symbolMap[name] = addr
+			return nil
+		}
+		return fmt.Errorf("unresolved external symbol: %s", name)
+	case sym.Kind == symkind.STEXT:
+		if f, ok := target.funcs[name]; ok && !b.changed[name] {
+			b.symbolMap[name] = f.entry
+			return nil
+		}
+		return b.loadText(sym)
+	}
+
+	addr, inTarget := target.dataSyms[name]
+	objsym := linker.objsymbolMap[name]
+	// Symbols synthesized by the linker are the same in every build
+	same := objsym == nil || symbolFingerprint(objsym) == target.dataFingerprints[name]
+	switch {
+	case isTypeSymbol(name):
+		if inTarget && !same {
+			b.errs = append(b.errs, name+": type changed layout")
+			return nil
+		}
+		if !inTarget {
+			addr, inTarget = target.symPtr[name]
+		}
+	case strings.HasPrefix(name, ItabPrefix):
+		if inTarget = inTarget && same; !inTarget {
+			addr, inTarget = target.symPtr[name]
+		}
+	case sym.Kind != symkind.SRODATA && objsym != nil:
+		// Package variables are shared with the target, other than static temporaries, which are named by the order in
+		// which the compiler created them, so may hold something else entirely
+		if !inTarget {
+			addr, inTarget = target.symPtr[name]
+		}
+		if !inTarget || (!same && strings.Contains(name, "..stmp_")) {
+			b.errs = append(b.errs, name+": new package variable")
+			return nil
+		}
+	default:
+		// Other read-only data (funcdata, gcbits etc.) is only shared if it's identical, and never resolved to the host
+		// binary, since funcdata must be addressable relative to the target's noptrdata
+		inTarget = inTarget && same
+	}
+	if inTarget {
+		b.symbolMap[name] = addr
+		return nil
+	}
+	return b.loadData(sym)
+}
+
+func (b *patchBuilder) loadText(sym *obj.Sym) error {
+	offset := b.area.textOff
+	if offset+sym.Size > b.area.textEnd {
+		return fmt.Errorf("function patch space is full, %s needs %d bytes but %d are left", sym.Name, sym.Size, b.area.textEnd-offset)
+	}
+	b.area.textOff += sym.Size
+	copy(b.target.codeByte[offset:offset+sym.Size], b.linker.code[sym.Offset:])
+	b.symbolMap[sym.Name] = uintptr(b.target.codeBase + offset)
+	b.text = append(b.text, b.rebase(sym, offset))
+	if objsym := b.linker.objsymbolMap[sym.Name]; objsym != nil && objsym.Func != nil {
+		for _, name := range objsym.Func.FuncData {
+			if name != EmptyString {
+				b.queue = append(b.queue, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *patchBuilder) loadData(sym *obj.Sym) error {
+	var data []byte
+	if objsym := b.linker.objsymbolMap[sym.Name]; objsym != nil {
+		data = make([]byte, sym.Size)
+		copy(data, objsym.Data)
+	} else if strings.HasPrefix(sym.Name, TypeImportPathPrefix) {
+		data = importPathName(sym.Name)
+	} else if ispreprocesssymbol(sym.Name) {
+		data = make([]byte, UInt64Size)
+		if err := preprocesssymbol(b.linker.Arch.ByteOrder, sym.Name, data); err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("can't find the data of symbol %s", sym.Name)
+	}
+	offset, err := b.allocData(len(data))
+	if err != nil {
+		return fmt.Errorf("%w while loading %s", err, sym.Name)
+	}
+	copy(b.target.dataByte[offset:], data)
+	b.symbolMap[sym.Name] = uintptr(b.target.dataBase + offset)
+	b.data = append(b.data, b.rebase(sym, offset))
+	return nil
+}
+
+func (b *patchBuilder) allocData(size int) (int, error) {
+	offset := alignof(b.area.dataOff, PtrSize)
+	if offset+size > b.area.dataEnd {
+		return 0, fmt.Errorf("function patch space is full, %d bytes of data are needed but %d are left", size, b.area.dataEnd-offset)
+	}
+	b.area.dataOff = offset + size
+	return offset, nil
+}
+
+// rebase returns a copy of sym moved to offset in the target's mapping, and queues the symbols it refers to
+func (b *patchBuilder) rebase(sym *obj.Sym, offset int) *obj.Sym {
+	b.local[sym.Name] = true
+	rebased := *sym
+	delta := offset - sym.Offset
+	rebased.Offset = offset
+	rebased.Reloc = make([]obj.Reloc, len(sym.Reloc))
+	for i, loc := range sym.Reloc {
+		loc.Offset += delta
+		loc.EpilogueOffset += delta
+		rebased.Reloc[i] = loc
+		if loc.Size > 0 {
+			// Not one of the markers for the linker's dead code elimination, e.g. R_USEIFACE
+			b.queue = append(b.queue, loc.Sym.Name)
+		}
+	}
+	return &rebased
+}
+
+// extendTables returns md's function tables with the functions loaded into the patch area appended, and md's text
+// and data extended to cover the patch area up to where the patch ends. Each table is a new copy, since the runtime
+// may be reading md's concurrently, but existing offsets into them stay valid.
+func (b *patchBuilder) extendTables(md *moduledata) (pclnTables, error) {
+	linker := b.linker
+	staged := *md
+	staged.funcnametab = append([]byte{}, md.funcnametab...)
+	staged.filetab = append([]byte{}, md.filetab...)
+	staged.pctab = append([]byte{}, md.pctab...)
+	staged.pclntable = append([]byte{}, md.pclntable...)
+	staged.cutab = append([]uint32{}, md.cutab...)
+	for _, offset := range linker.cutab {
+		staged.cutab = append(staged.cutab, offset+uint32(len(md.filetab)))
+	}
+	staged.filetab = append(staged.filetab, linker.filetab...)
+	cuBase := len(md.cutab)
+	namemap := map[string]int{}
+	nameOff := func(name string) int {
+		if offset, ok := namemap[name]; ok {
+			return offset
+		}
+		namemap[name] = len(staged.funcnametab)
+		staged.funcnametab = append(staged.funcnametab, []byte(name)...)
+		staged.funcnametab = append(staged.funcnametab, ZeroByte)
+		return namemap[name]
+	}
+
+	staged.ftab = append([]functab{}, md.ftab[:len(md.ftab)-1]...)
+	for _, sym := range b.text {
+		objsym := linker.objsymbolMap[sym.Name]
+		if objsym == nil || objsym.Func == nil {
+			return pclnTables{}, fmt.Errorf("function %s has no function info", sym.Name)
+		}
+		Func := objsym.Func
+		entry := b.symbolMap[sym.Name]
+		pcspOff := len(staged.pctab)
+		staged.pctab = append(staged.pctab, Func.PCSP...)
+		pcfileOff := len(staged.pctab)
+		staged.pctab = append(staged.pctab, Func.PCFile...)
+		pclnOff := len(staged.pctab)
+		staged.pctab = append(staged.pctab, Func.PCLine...)
+		_func := initfunc(objsym, nameOff(sym.Name), pcspOff, pcfileOff, pclnOff, cuBase+Func.CUOffset)
+		setfuncentry(&_func, entry, staged.text)
+		if err := linker.setDeferReturn(&_func, sym.Name); err != nil {
+			return pclnTables{}, err
+		}
+
+		pcdata := make([]uint32, len(Func.PCData))
+		for i, values := range Func.PCData {
+			if len(values) > 0 {
+				pcdata[i] = uint32(len(staged.pctab))
+				staged.pctab = append(staged.pctab, values...)
+			}
+		}
+		funcdata := make([]uint32, len(Func.FuncData))
+		for i, name := range Func.FuncData {
+			funcdata[i] = ^uint32(0)
+			if name != EmptyString {
+				addr := b.symbolMap[name]
+				if addr < staged.gofunc || addr >= uintptr(b.target.dataBase+b.area.dataEnd) {
+					return pclnTables{}, fmt.Errorf("funcdata %s of %s isn't in the module", name, sym.Name)
+				}
+				funcdata[i] = uint32(addr - staged.gofunc)
+			}
+		}
+		if len(Func.InlTree) > 0 {
+			for len(pcdata) <= dataindex.PCDATA_InlTreeIndex {
+				pcdata = append(pcdata, 0)
+			}
+			pcdata[dataindex.PCDATA_InlTreeIndex] = uint32(len(staged.pctab))
+			staged.pctab = append(staged.pctab, Func.PCInline...)
+			addr, err := b.loadInlineTree(objsym, nameOff, staged.cutab)
+			if err != nil {
+				return pclnTables{}, err
+			}
+			for len(funcdata) <= dataindex.FUNCDATA_InlTree {
+				funcdata = append(funcdata, ^uint32(0))
+			}
+			funcdata[dataindex.FUNCDATA_InlTree] = uint32(addr - staged.gofunc)
+		}
+		grow(&staged.pctab, alignof(len(staged.pctab), PtrSize))
+		if len(Func.FuncData) > dataindex.FUNCDATA_StackObjects && b.local[Func.FuncData[dataindex.FUNCDATA_StackObjects]] {
+			objects := adduintptr(b.symbolMap[Func.FuncData[dataindex.FUNCDATA_StackObjects]], 0)
+			if err := stackobject.SetStackObjectPtrs(sym.Name, objects, linker.symMap, b.symbolMap, staged.rodata); err != nil {
+				return pclnTables{}, err
+			}
+		}
+
+		_func.npcdata = uint32(len(pcdata))
+		_func.nfuncdata = uint8(len(funcdata))
+		staged.ftab = append(staged.ftab, initfunctab(entry, uintptr(len(staged.pclntable)), staged.text))
+		append2Slice(&staged.pclntable, uintptr(unsafe.Pointer(&_func)), _FuncSize)
+		if len(pcdata) > 0 {
+			append2Slice(&staged.pclntable, uintptr(unsafe.Pointer(&pcdata[0])), Uint32Size*len(pcdata))
+		}
+		if len(funcdata) > 0 {
+			append2Slice(&staged.pclntable, uintptr(unsafe.Pointer(&funcdata[0])), Uint32Size*len(funcdata))
+		}
+	}
+	staged.maxpc = uintptr(b.target.codeBase + b.area.textOff)
+	staged.etext = staged.maxpc
+	staged.ftab = append(staged.ftab, initfunctab(staged.maxpc, uintptr(len(staged.pclntable)), staged.text))
+	addFindFuncTab(&staged)
+	staged.pcHeader = (*pcHeader)(unsafe.Pointer(&staged.pclntable[0]))
+	staged.pcHeader.nfunc = len(staged.ftab)
+	staged.pcHeader.nfiles = uint(len(staged.filetab))
+	if end := uintptr(b.target.dataBase + b.area.dataOff); end > staged.end {
+		staged.end = end
+		staged.etypes = end
+	}
+	moduledataverify1(&staged)
+	return staged.pclnTables(), nil
+}
+
+// loadInlineTree loads the records of a function's inlining tree into the patch area, see addInlineTree
+func (b *patchBuilder) loadInlineTree(objsym *obj.ObjSymbol, nameOff func(string) int, cutab []uint32) (uintptr, error) {
+	namemap := map[string]int{}
+	for _, inl := range objsym.Func.InlTree {
+		namemap[inl.Func] = nameOff(inl.Func)
+	}
+	bytes := make([]byte, len(objsym.Func.InlTree)*obj.InlinedCallSize)
+	for k, inl := range objsym.Func.InlTree {
+		// If we can't find the inlined funcID, we assume it's FuncID_normal.
+		var funcID = uint8(obj.FuncIDNormal)
+		if inlSym, ok := b.linker.objsymbolMap[inl.Func]; ok {
+			funcID = inlSym.Func.FuncID
+		}
+		inlinedcall := obj.InitInlinedCall(inl, funcID, namemap, cutab)
+		copy2Slice(bytes[k*obj.InlinedCallSize:], uintptr(unsafe.Pointer(&inlinedcall)), obj.InlinedCallSize)
+	}
+	offset, err := b.allocData(len(bytes))
+	if err != nil {
+		return 0, fmt.Errorf("%w while loading the inlining tree of %s", err, objsym.Name)
+	}
+	copy(b.target.dataByte[offset:], bytes)
+	return uintptr(b.target.dataBase + offset), nil
+}
+
+func (md *moduledata) pclnTables() pclnTables {
+	return pclnTables{
+		pcHeader:    md.pcHeader,
+		funcnametab: md.funcnametab,
+		cutab:       md.cutab,
+		filetab:     md.filetab,
+		pctab:       md.pctab,
+		pclntable:   md.pclntable,
+		ftab:        md.ftab,
+		findfunctab: md.findfunctab,
+		maxpc:       md.maxpc,
+		end:         md.end,
+	}
+}
+
+// setPclnTables replaces md's function tables with tables, while the runtime may be looking up functions in them.
+// Every table in one is a prefix of the same table in the other, so only the range of PCs looked up needs ordering:
+// it's extended after the tables, and reduced before them.
+func setPclnTables(md *moduledata, tables pclnTables) {
+	shrink := tables.maxpc < md.maxpc
+	if shrink {
+		atomic.StoreUintptr(&md.maxpc, tables.maxpc)
+		atomic.StoreUintptr(&md.etext, tables.maxpc)
+	}
+	setSlice(unsafe.Pointer(&md.pclntable), unsafe.Pointer(&tables.pclntable))
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&md.pcHeader)), unsafe.Pointer(tables.pcHeader))
+	setSlice(unsafe.Pointer(&md.funcnametab), unsafe.Pointer(&tables.funcnametab))
+	setSlice(unsafe.Pointer(&md.cutab), unsafe.Pointer(&tables.cutab))
+	setSlice(unsafe.Pointer(&md.filetab), unsafe.Pointer(&tables.filetab))
+	setSlice(unsafe.Pointer(&md.pctab), unsafe.Pointer(&tables.pctab))
+	setSlice(unsafe.Pointer(&md.ftab), unsafe.Pointer(&tables.ftab))
+	atomic.StoreUintptr(&md.findfunctab, tables.findfunctab)
+	atomic.StoreUintptr(&md.end, tables.end)
+	atomic.StoreUintptr(&md.etypes, tables.end)
+	if !shrink {
+		atomic.StoreUintptr(&md.etext, tables.maxpc)
+		atomic.StoreUintptr(&md.maxpc, tables.maxpc)
+	}
+}
+
+// setSlice stores the slice at src to the slice at dst, of which it's a prefix or an extension, so that a concurrent
+// reader never sees a length longer than the array it sees
+func setSlice(dst, src unsafe.Pointer) {
+	d, s := (*sliceHeader)(dst), (*sliceHeader)(src)
+	data := (*unsafe.Pointer)(unsafe.Pointer(&d.Data))
+	length := (*uintptr)(unsafe.Pointer(&d.Len))
+	if s.Len < d.Len {
+		atomic.StoreUintptr(length, uintptr(s.Len))
+		atomic.StorePointer(data, *(*unsafe.Pointer)(unsafe.Pointer(&s.Data)))
+	} else {
+		atomic.StorePointer(data, *(*unsafe.Pointer)(unsafe.Pointer(&s.Data)))
+		atomic.StoreUintptr(length, uintptr(s.Len))
+	}
+	d.Cap = s.Cap
+}
+
+// Revert restores the original entry points of the patched functions, then removes the new bodies from the module's
+// function tables and frees their space for another patch. As with CodeModule.Unload, the caller must ensure no
+// goroutine is still executing (or will return into) the new bodies.
+func (p *FunctionPatch) Revert() error {
+	if p.reverted {
+		return nil
+	}
+	target := p.target
+	if n := len(target.patches); p.applied && (n == 0 || target.patches[n-1] != p) {
+		return fmt.Errorf("patches must be reverted in the reverse order to which they were applied")
+	}
+	for i := len(p.redirects) - 1; i >= 0; i-- {
+		redirect := p.redirects[i]
+		if err := target.writeCode(redirect.entry, redirect.original); err != nil {
+			return fmt.Errorf("failed to restore entrypoint of %s: %w", redirect.name, err)
+		}
+		target.funcs[redirect.name] = redirect.prev
+	}
+	p.redirects = nil
+	p.reverted = true
+	if p.applied {
+		tables := p.tables
+		// Names and PC value tables are left as they are, since runtime.Func values for the new bodies may still
+		// refer to them
+		tables.funcnametab, tables.cutab, tables.filetab, tables.pctab =
+			target.module.funcnametab, target.module.cutab, target.module.filetab, target.module.pctab
+		setPclnTables(target.module, tables)
+		*target.patchArea = p.area
+		target.patches = target.patches[:len(target.patches)-1]
+		p.strings = nil
+	}
+	return nil
+}
+
+func (cm *CodeModule) revertFunctionPatches() error {
+	for len(cm.patches) > 0 {
+		if err := cm.patches[len(cm.patches)-1].Revert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func trampolineSize(arch *sys.Arch, entry, target uintptr) (int, error) {
+	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
+		return 0, fmt.Errorf("function patching is not supported on darwin/arm64, since MAP_JIT text can't be made writeable while other threads execute it")
+	}
+	switch arch.Family {
+	case sys.AMD64:
+		offset := int64(target) - int64(entry+uintptr(len(x86amd64JMPNearCode)))
+		if offset == int64(int32(offset)) {
+			return len(x86amd64JMPNearCode), nil
+		}
+		return len(x86amd64JMPLcode) + PtrSize, nil
+	case sys.ARM64:
+		offset := int64(target) - int64(entry)
+		if offset >= -(1<<27) && offset < (1<<27) {
+			return len(arm64Bcode), nil
+		}
+		return len(arm64CALLCode) + PtrSize, nil
+	default:
+		return 0, fmt.Errorf("function patching is not supported on %s", arch.Name)
+	}
+}
+
+// writeTrampoline overwrites the start of the function at entry with a jump to target, and returns the overwritten bytes
+func (cm *CodeModule) writeTrampoline(arch *sys.Arch, entry, target uintptr) (original []byte, err error) {
+	size, err := trampolineSize(arch, entry, target)
+	if err != nil {
+		return nil, err
+	}
+	code := make([]byte, size)
+	switch arch.Family {
+	case sys.AMD64:
+		if size == len(x86amd64JMPNearCode) {
+			copy(code, x86amd64JMPNearCode)
+			binary.LittleEndian.PutUint32(code[1:], uint32(int32(int64(target)-int64(entry+uintptr(size)))))
+		} else {
+			copy(code, x86amd64JMPLcode)
+			binary.LittleEndian.PutUint64(code[len(x86amd64JMPLcode):], uint64(target))
+		}
+	case sys.ARM64:
+		if size == len(arm64Bcode) {
+			binary.LittleEndian.PutUint32(code, binary.LittleEndian.Uint32(arm64Bcode)|uint32((int64(target)-int64(entry))>>2)&0x3FFFFFF)
+		} else {
+			copy(code, arm64CALLCode)
+			binary.LittleEndian.PutUint64(code[len(arm64CALLCode):], uint64(target))
+		}
+	}
+	original = make([]byte, size)
+	copy(original, (*[1 << 8]byte)(unsafe.Pointer(entry))[:size:size])
+	return original, cm.writeCode(entry, code)
+}
+
+// makeTextWritable makes the pages of cm's text spanning [addr, addr+size) writable, while staying executable since
+// other threads may be running code in them, and returns a function which write protects them again
+func (cm *CodeModule) makeTextWritable(addr uintptr, size int) (protect func() error, err error) {
+	if !cm.writeProtected {
+		return func() error { return nil }, nil
+	}
+	pages := pagesSpanning(addr, size)
+	if err = mprotect.MprotectMakeWritableExecutable(pages); err != nil {
+		return nil, fmt.Errorf("failed to make text at 0x%x writable: %w", addr, err)
+	}
+	return func() error {
+		if err := mprotect.MprotectMakeExecutable(pages); err != nil {
+			return fmt.Errorf("failed to restore write protection of text at 0x%x: %w", addr, err)
+		}
+		return nil
+	}, nil
+}
+
+// writeCode overwrites live text at addr. Where possible (a 4 byte instruction, or up to 8 bytes at an 8 byte aligned
+// address) this is done with a single atomic store, so that a concurrently executing thread never sees a torn instruction.
+// If cm's text is write protected, the affected pages are made writable (while staying executable) for the duration.
+func (cm *CodeModule) writeCode(addr uintptr, code []byte) (err error) {
+	protect, err := cm.makeTextWritable(addr, len(code))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := protect(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+	switch {
+	case len(code) == 4 && addr%4 == 0:
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(addr)), binary.LittleEndian.Uint32(code))
+	case len(code) <= 8 && addr%8 == 0:
+		var word [8]byte
+		binary.LittleEndian.PutUint64(word[:], atomic.LoadUint64((*uint64)(unsafe.Pointer(addr))))
+		copy(word[:], code)
+		atomic.StoreUint64((*uint64)(unsafe.Pointer(addr)), binary.LittleEndian.Uint64(word[:]))
+	default:
+		copy((*[1 << 8]byte)(unsafe.Pointer(addr))[:len(code):len(code)], code)
+	}
+	MakeThreadJITCodeExecutable(addr, len(code))
+	return nil
+}
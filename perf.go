@@ -0,0 +1,197 @@
+package goloader
+
+import (
+	"bytes"
+	"cmd/objfile/sys"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+)
+
+// Loaded modules can be described to Linux profilers such as perf and bpftrace, which can't otherwise symbolise
+// samples in anonymous executable mappings, in two ways:
+//   - a perf map (/tmp/perf-<pid>.map), with a "start size name" line for each function, which perf report reads
+//     directly
+//   - a jitdump (jit-<pid>.dump), which also records each function's code and load time, so that perf inject --jit can
+//     attribute samples even after a module is unloaded and its addresses reused. perf record learns of the file
+//     through an executable mapping of it, so the process must be recorded with -k mono to match its timestamps.
+// See https://github.com/torvalds/linux/blob/master/tools/perf/Documentation/jit-interface.txt and
+// jitdump-specification.txt alongside it.
+
+const (
+	jitDumpMagic      = 0x4A695444
+	jitDumpVersion    = 1
+	jitDumpHeaderSize = 40
+	jitCodeLoad       = 0
+	jitCodeClose      = 3
+)
+
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+var (
+	perfLock     sync.Mutex
+	perfMapLines = map[*CodeModule][]byte{}
+	perfMapOrder []*CodeModule                 // Modules in perfMapLines, in load order
+	jitDumps     = map[string]*jitDumpWriter{} // By directory, so linkers recording to different ones each get their own
+)
+
+// jitDumpWriter appends records to one of this process's jitdumps
+type jitDumpWriter struct {
+	f         *os.File
+	mapping   []byte // Only exists for perf record to see
+	codeIndex uint64
+}
+
+func perfMapPath() string {
+	// perf only looks in /tmp, regardless of TMPDIR
+	return fmt.Sprintf("/tmp/perf-%d.map", os.Getpid())
+}
+
+// registerWithProfilers writes the module's functions to the perf map and jitdump, if enabled
+func (linker *Linker) registerWithProfilers(codeModule *CodeModule, symbolMap map[string]uintptr) error {
+	if !linker.options.PerfMap && linker.options.PerfJITDumpDir == "" {
+		return nil
+	}
+	funcs := linker.moduleFuncs(symbolMap)
+	perfLock.Lock()
+	defer perfLock.Unlock()
+	if linker.options.PerfMap {
+		var lines bytes.Buffer
+		for _, f := range funcs {
+			_, _ = fmt.Fprintf(&lines, "%x %x %s\n", f.entry, f.size, f.name)
+		}
+		file, err := os.OpenFile(perfMapPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open perf map: %w", err)
+		}
+		_, err = file.Write(lines.Bytes())
+		if err2 := file.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write perf map: %w", err)
+		}
+		perfMapLines[codeModule] = lines.Bytes()
+		perfMapOrder = append(perfMapOrder, codeModule)
+	}
+	if linker.options.PerfJITDumpDir != "" {
+		dir, err := filepath.Abs(linker.options.PerfJITDumpDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve jitdump directory: %w", err)
+		}
+		jitDump := jitDumps[dir]
+		if jitDump == nil {
+			jitDump, err = openJITDump(dir, linker.Arch)
+			if err != nil {
+				return err
+			}
+			jitDumps[dir] = jitDump
+		}
+		codeBase := uintptr(codeModule.codeBase)
+		for _, f := range funcs {
+			code := codeModule.codeByte[f.entry-codeBase : f.entry-codeBase+f.size]
+			if err := jitDump.writeCodeLoad(f.name, f.entry, code); err != nil {
+				return fmt.Errorf("failed to write jitdump: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// unregisterFromProfilers removes the module's functions from the perf map, by rewriting it with those of the modules
+// which are still loaded. Nothing is written to the jitdump, since perf inject attributes samples by load time.
+func (cm *CodeModule) unregisterFromProfilers() error {
+	perfLock.Lock()
+	defer perfLock.Unlock()
+	if _, ok := perfMapLines[cm]; !ok {
+		return nil
+	}
+	delete(perfMapLines, cm)
+	var lines bytes.Buffer
+	remaining := perfMapOrder[:0]
+	for _, module := range perfMapOrder {
+		if module != cm {
+			remaining = append(remaining, module)
+			lines.Write(perfMapLines[module])
+		}
+	}
+	perfMapOrder = remaining
+	// Replace the file rather than truncating it, so that a profiler reading it never sees it partially written
+	tmp := perfMapPath() + ".tmp"
+	if err := os.WriteFile(tmp, lines.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to rewrite perf map: %w", err)
+	}
+	if err := os.Rename(tmp, perfMapPath()); err != nil {
+		return fmt.Errorf("failed to rewrite perf map: %w", err)
+	}
+	return nil
+}
+
+func openJITDump(dir string, arch *sys.Arch) (*jitDumpWriter, error) {
+	var machine elf.Machine
+	switch arch.Family {
+	case sys.AMD64:
+		machine = elf.EM_X86_64
+	case sys.ARM64:
+		machine = elf.EM_AARCH64
+	case sys.I386:
+		machine = elf.EM_386
+	case sys.ARM:
+		machine = elf.EM_ARM
+	}
+	path := filepath.Join(dir, fmt.Sprintf("jit-%d.dump", os.Getpid()))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jitdump: %w", err)
+	}
+	w := &jitDumpWriter{f: f}
+	// The header and records are written in the host's byte order, which readers tell from the magic number
+	header := &dwBuf{order: hostByteOrder()}
+	header.u32(jitDumpMagic)
+	header.u32(jitDumpVersion)
+	header.u32(jitDumpHeaderSize)
+	header.u32(uint32(machine))
+	header.u32(0) // pad1
+	header.u32(uint32(os.Getpid()))
+	header.u64(uint64(nanotime()))
+	header.u64(0) // flags
+	if _, err = f.Write(header.b); err == nil {
+		w.mapping, err = mapJITDump(f)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to initialise jitdump: %w", err)
+	}
+	return w, nil
+}
+
+func (w *jitDumpWriter) writeCodeLoad(name string, addr uintptr, code []byte) error {
+	record := &dwBuf{order: hostByteOrder()}
+	record.u32(jitCodeLoad)
+	record.u32(uint32(4 + 4 + 8 + 4 + 4 + 8 + 8 + 8 + 8 + len(name) + 1 + len(code))) // total_size
+	record.u64(uint64(nanotime()))
+	record.u32(uint32(os.Getpid()))
+	record.u32(uint32(gettid()))
+	record.u64(uint64(addr)) // vma
+	record.u64(uint64(addr)) // code_addr
+	record.u64(uint64(len(code)))
+	record.u64(w.codeIndex)
+	record.str(name)
+	record.b = append(record.b, code...)
+	w.codeIndex++
+	_, err := w.f.Write(record.b)
+	return err
+}
+
+func hostByteOrder() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
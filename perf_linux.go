@@ -0,0 +1,22 @@
+//go:build linux
+// +build linux
+
+package goloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapJITDump maps the start of the jitdump executable, which is how perf record finds it
+func mapJITDump(f *os.File) ([]byte, error) {
+	mapping, err := syscall.Mmap(int(f.Fd()), 0, osPageSize, syscall.PROT_READ|syscall.PROT_EXEC, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	return mapping, nil
+}
+
+func gettid() int {
+	return syscall.Gettid()
+}
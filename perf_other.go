@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package goloader
+
+import (
+	"fmt"
+	"os"
+)
+
+func mapJITDump(f *os.File) ([]byte, error) {
+	return nil, fmt.Errorf("jitdump is only supported on linux")
+}
+
+func gettid() int {
+	return os.Getpid()
+}
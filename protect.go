@@ -0,0 +1,152 @@
+package goloader
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/eihigh/goloader/mprotect"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+var osPageSize = os.Getpagesize()
+
+// isReadOnlyDataSymbol reports whether a symbol is placed in the module's read-only data pages, which are everything
+// of kind SRODATA other than string constants (see addSymbol). Funcdata is addressed relative to the start of those
+// pages, see buildModule.
+func isReadOnlyDataSymbol(kind int) bool {
+	return kind == symkind.SRODATA
+}
+
+// rodataOffset returns the offset of the read-only data within the data mapping, which follows noptrbss on its own page(s)
+func (linker *Linker) rodataOffset() int {
+	offset := len(linker.data) + len(linker.noptrdata) + linker.bssSize + linker.noptrbssSize
+	if len(linker.rodata) == 0 {
+		return offset
+	}
+	return alignof(offset, osPageSize)
+}
+
+// unprotectText drops execute permission from a freshly mapped module's text while it is being relocated, so that no
+// page is ever both writable and executable
+func (linker *Linker) unprotectText(codeModule *CodeModule) error {
+	if linker.options.NoWriteProtection {
+		return nil
+	}
+	if err := mprotect.MprotectMakeWritable(codeModule.codeByte); err != nil {
+		return fmt.Errorf("failed to make text writable for relocation: %w", err)
+	}
+	return nil
+}
+
+// protectModule flips the relocated text to R-X and the read-only data and function tables to R--, before any of the
+// module's code runs
+func (linker *Linker) protectModule(codeModule *CodeModule) error {
+	if linker.options.NoWriteProtection {
+		return nil
+	}
+	if err := mprotect.MprotectMakeExecutable(codeModule.codeByte); err != nil {
+		return fmt.Errorf("failed to make text executable: %w", err)
+	}
+	for _, page := range codeModule.hostTrampolines.pages {
+		if err := mprotect.MprotectMakeExecutable(page); err != nil {
+			return fmt.Errorf("failed to make host method trampolines executable: %w", err)
+		}
+	}
+	for _, tables := range codeModule.pclnMappings {
+		if err := mprotect.MprotectMakeReadOnly(tables); err != nil {
+			return fmt.Errorf("failed to make function tables read-only: %w", err)
+		}
+	}
+	if codeModule.rodataLen > 0 {
+		rodataEnd := len(codeModule.dataByte)
+		if codeModule.patchArea != nil {
+			// Patched functions' data is written after load, see PatchFunctions
+			rodataEnd = codeModule.patchArea.dataOff
+		}
+		if err := mprotect.MprotectMakeReadOnly(codeModule.dataByte[codeModule.rodataOff:rodataEnd]); err != nil {
+			return fmt.Errorf("failed to make read-only data read-only: %w", err)
+		}
+	}
+	codeModule.writeProtected = true
+	return nil
+}
+
+// inReadOnlyData reports whether addr is in the module's write protected read-only data
+func (cm *CodeModule) inReadOnlyData(addr uintptr) bool {
+	start := uintptr(cm.dataBase + cm.rodataOff)
+	return cm.writeProtected && addr >= start && addr < start+uintptr(cm.rodataLen)
+}
+
+// pagesSpanning returns the whole pages containing [addr, addr+size)
+func pagesSpanning(addr uintptr, size int) []byte {
+	start := addr &^ uintptr(osPageSize-1)
+	end := uintptr(alignof(int(addr)+size, osPageSize))
+	return unsafe.Slice((*byte)(unsafe.Pointer(start)), end-start)
+}
+
+// sealPclnTables copies tables onto pages of their own, which are read-only once the module is write protected, and
+// returns the copies. The runtime never writes to the tables, and goloader only builds new ones (see PatchFunctions).
+// The pages are kept until the module is unloaded, since runtime.Func values and names from any earlier tables may
+// still refer to them.
+func (cm *CodeModule) sealPclnTables(tables pclnTables) (pclnTables, error) {
+	sizes := []int{
+		len(tables.pclntable),
+		len(tables.ftab) * int(unsafe.Sizeof(functab{})),
+		len(tables.cutab) * Uint32Size,
+		len(tables.funcnametab),
+		len(tables.filetab),
+		len(tables.pctab),
+	}
+	offsets := make([]int, len(sizes))
+	size := 0
+	for i := range sizes {
+		offsets[i] = alignof(size, PtrSize)
+		size = offsets[i] + sizes[i]
+	}
+	mapping, err := MmapData(alignof(size, osPageSize))
+	if err != nil {
+		return tables, fmt.Errorf("failed to map function tables: %w", err)
+	}
+	at := func(i int) unsafe.Pointer {
+		return unsafe.Pointer(&mapping[offsets[i]])
+	}
+	sealed := tables
+	sealed.pclntable = unsafe.Slice((*byte)(at(0)), len(tables.pclntable))
+	sealed.ftab = unsafe.Slice((*functab)(at(1)), len(tables.ftab))
+	sealed.cutab = unsafe.Slice((*uint32)(at(2)), len(tables.cutab))
+	sealed.funcnametab = unsafe.Slice((*byte)(at(3)), len(tables.funcnametab))
+	sealed.filetab = unsafe.Slice((*byte)(at(4)), len(tables.filetab))
+	sealed.pctab = unsafe.Slice((*byte)(at(5)), len(tables.pctab))
+	copy(sealed.pclntable, tables.pclntable)
+	copy(sealed.ftab, tables.ftab)
+	copy(sealed.cutab, tables.cutab)
+	copy(sealed.funcnametab, tables.funcnametab)
+	copy(sealed.filetab, tables.filetab)
+	copy(sealed.pctab, tables.pctab)
+	// The header and findfunctab are both within pclntable
+	base := uintptr(unsafe.Pointer(&tables.pclntable[0]))
+	sealed.pcHeader = (*pcHeader)(unsafe.Pointer(&sealed.pclntable[uintptr(unsafe.Pointer(tables.pcHeader))-base]))
+	sealed.findfunctab = uintptr(unsafe.Pointer(&sealed.pclntable[tables.findfunctab-base]))
+
+	if cm.writeProtected {
+		if err = mprotect.MprotectMakeReadOnly(mapping); err != nil {
+			_ = Munmap(mapping)
+			return tables, fmt.Errorf("failed to make function tables read-only: %w", err)
+		}
+	}
+	cm.pclnMappings = append(cm.pclnMappings, mapping)
+	return sealed, nil
+}
+
+// releasePclnTables unmaps every copy of the module's function tables made by sealPclnTables
+func (cm *CodeModule) releasePclnTables() error {
+	var err error
+	for _, tables := range cm.pclnMappings {
+		if err2 := Munmap(tables); err == nil {
+			err = err2
+		}
+	}
+	cm.pclnMappings = nil
+	return err
+}
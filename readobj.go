@@ -4,6 +4,7 @@ import (
 	"cmd/objfile/goobj"
 	"cmd/objfile/sys"
 	"fmt"
+	"github.com/eihigh/goloader/mmap"
 	"github.com/eihigh/goloader/obj"
 	"github.com/eihigh/goloader/objabi/reloctype"
 	"github.com/eihigh/goloader/objabi/symkind"
@@ -32,13 +33,34 @@ func Parse(f *os.File, pkgpath *string) ([]string, error) {
 	return symbols, nil
 }
 
-func readObj(pkg *obj.Pkg, linker *Linker) error {
+// parseObj reads pkg's symbols and qualifies their names with its package path. It only touches pkg, so archives can be
+// parsed concurrently before being added to the linker in order with addObj.
+func parseObj(pkg *obj.Pkg) error {
 	if pkg.PkgPath == EmptyString {
 		pkg.PkgPath = DefaultPkgPath
 	}
 	if err := pkg.Symbols(); err != nil {
 		return fmt.Errorf("read error: %v", err)
 	}
+	for _, sym := range pkg.Syms {
+		for index, loc := range sym.Reloc {
+			if !strings.HasPrefix(sym.Reloc[index].Sym.Name, TypeStringPrefix) {
+				sym.Reloc[index].Sym.Name = strings.Replace(loc.Sym.Name, EmptyPkgPath, pkg.PkgPath, -1)
+			}
+		}
+		if sym.Type != EmptyString {
+			sym.Type = strings.Replace(sym.Type, EmptyPkgPath, pkg.PkgPath, -1)
+		}
+		if sym.Func != nil {
+			for index, FuncData := range sym.Func.FuncData {
+				sym.Func.FuncData[index] = strings.Replace(FuncData, EmptyPkgPath, pkg.PkgPath, -1)
+			}
+		}
+	}
+	return nil
+}
+
+func (linker *Linker) addObj(pkg *obj.Pkg) error {
 	if linker.Arch != nil && linker.Arch.Name != pkg.Arch {
 		return fmt.Errorf("read obj error: Arch %s != Arch %s", linker.Arch.Name, pkg.Arch)
 	} else {
@@ -56,22 +78,9 @@ func readObj(pkg *obj.Pkg, linker *Linker) error {
 	}
 
 	for _, sym := range pkg.Syms {
-		for index, loc := range sym.Reloc {
-			if !strings.HasPrefix(sym.Reloc[index].Sym.Name, TypeStringPrefix) {
-				sym.Reloc[index].Sym.Name = strings.Replace(loc.Sym.Name, EmptyPkgPath, pkg.PkgPath, -1)
-			}
-		}
-		if sym.Type != EmptyString {
-			sym.Type = strings.Replace(sym.Type, EmptyPkgPath, pkg.PkgPath, -1)
-		}
 		if sym.Func != nil {
-			for index, FuncData := range sym.Func.FuncData {
-				sym.Func.FuncData[index] = strings.Replace(FuncData, EmptyPkgPath, pkg.PkgPath, -1)
-			}
 			sym.Func.CUOffset += cuOffset
 		}
-	}
-	for _, sym := range pkg.Syms {
 		linker.objsymbolMap[sym.Name] = sym
 	}
 	linker.cuFiles = append(linker.cuFiles, pkg.CUFiles...)
@@ -89,6 +98,15 @@ type LinkerOptions struct {
 	NoRelocationEpilogues            bool
 	SkipTypeDeduplicationForPackages []string
 	ForceTestRelocationEpilogues     bool
+	NoWriteProtection                bool
+	ForceTestMapAnywhere             bool
+	ReadParallelism                  int
+	LazyBinding                      bool
+	RegisterWithDebuggers            bool
+	PerfMap                          bool
+	PerfJITDumpDir                   string
+	RecordRelocations                bool
+	FunctionPatchSpace               int
 }
 
 // WithSymbolNameOrder allows you to control the sequence (placement in memory) of symbols from an object file.
@@ -135,6 +153,87 @@ func WithForceTestRelocationEpilogues() func(*LinkerOptions) {
 	}
 }
 
+// WithForceTestMapAnywhere maps modules wherever the OS chooses, as if no mapping within range of the first module
+// could be acquired, to test loading modules which reach the host binary only through their far reference table.
+func WithForceTestMapAnywhere() func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.ForceTestMapAnywhere = true
+	}
+}
+
+// WithReadParallelism sets how many archives ReadObjs parses at once, and how many goroutines walk the symbol graph
+// for reachability. By default GOMAXPROCS is used. A value of 1 reads everything sequentially.
+func WithReadParallelism(n int) func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.ReadParallelism = n
+	}
+}
+
+// WithLazyBinding leaves a loaded module's functions unrelocated, and relocates each on its first call instead, which
+// cuts load time for modules with large dependency graphs whose functions are mostly never called. Only amd64 is
+// supported, elsewhere (and for functions without a stack check) functions are bound at load as usual.
+func WithLazyBinding() func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.LazyBinding = true
+	}
+}
+
+// WithDebuggerRegistration registers each loaded module with debuggers through GDB's JIT interface, as an in-memory ELF
+// object with symbols and DWARF line, frame and inlining info, so that module text can be symbolised and have
+// breakpoints set in it. It's also enabled by setting GOLOADER_GDB_JIT=1. Only amd64 and arm64 are supported.
+func WithDebuggerRegistration() func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.RegisterWithDebuggers = true
+	}
+}
+
+// WithPerfMap appends a line for each function in a loaded module to /tmp/perf-<pid>.map, which perf and other Linux
+// profilers read to symbolise samples in JIT code. The module's lines are removed again when it's unloaded.
+// It's also enabled by setting GOLOADER_PERF_MAP=1.
+func WithPerfMap() func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.PerfMap = true
+	}
+}
+
+// WithPerfJITDump records each function in a loaded module, along with its code, in dir/jit-<pid>.dump, for use with
+// perf record -k mono followed by perf inject --jit. It's also enabled by setting GOLOADER_PERF_JITDUMP_DIR. Linkers
+// given the same dir share one jitdump, while those given different dirs each write their own. Only Linux is supported.
+func WithPerfJITDump(dir string) func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.PerfJITDumpDir = dir
+	}
+}
+
+// WithRelocationRecords keeps a RelocRecord for every relocation applied to a loaded module, available from
+// CodeModule.RelocRecords. It's also enabled by setting GOLOADER_RECORD_RELOCATIONS=1.
+func WithRelocationRecords() func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.RecordRelocations = true
+	}
+}
+
+// WithFunctionPatchSpace reserves bytes of address space after a loaded module's text, and as much again after its data
+// and its far reference table, into which PatchFunctions relocates the new bodies of changed functions (and any
+// functions, types or read-only data only they use). Pages are only committed once a patch writes to them. Modules
+// loaded without it can't be patched.
+func WithFunctionPatchSpace(bytes int) func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.FunctionPatchSpace = bytes
+	}
+}
+
+// WithNoWriteProtection leaves a loaded module's text mapped RWX and its read-only data and function tables writable,
+// rather than flipping them to R-X and R-- before init. This is only intended for debugging, e.g. to set software breakpoints.
+// Write protection covers the text, every read-only data symbol (type descriptors, itabs, funcdata such as stack maps
+// and gclocals, inlining trees) and the function tables. String constants are kept on the Go heap rather than in the
+// module, so aren't protected.
+func WithNoWriteProtection() func(*LinkerOptions) {
+	return func(options *LinkerOptions) {
+		options.NoWriteProtection = true
+	}
+}
+
 func resolveSymRefName(symRef goobj.SymRef, pkgs []*obj.Pkg, objByPkg map[string]uint32, objIdx uint32) (symName, pkgName string) {
 	pkg := pkgs[objIdx-1]
 	pkgName = pkg.ReferencedPkgs[symRef.PkgIdx]
@@ -150,6 +249,7 @@ func ReadObjs(files []string, pkgPath []string, globalSymPtr map[string]uintptr,
 	if err != nil {
 		return nil, err
 	}
+	defer linker.stats.StartPhase("read objects")()
 	var osFiles []*os.File
 	defer func() {
 		for _, f := range osFiles {
@@ -162,22 +262,44 @@ func ReadObjs(files []string, pkgPath []string, globalSymPtr map[string]uintptr,
 	for i, file := range files {
 		f, err := os.Open(file)
 		if err != nil {
+			_ = linker.Release()
 			return nil, err
 		}
 		osFiles = append(osFiles, f)
+		mapping, err := mmap.MmapFile(f)
+		if err != nil {
+			_ = linker.Release()
+			return nil, fmt.Errorf("failed to map archive %s: %w", file, err)
+		}
+		linker.archives = append(linker.archives, mapping)
 		pkg := obj.Pkg{
 			Syms:          make(map[string]*obj.ObjSymbol, 0),
 			F:             f,
+			Mapped:        mapping,
 			PkgPath:       pkgPath[i],
 			Objidx:        uint32(i + 1),
 			SymNamesByIdx: make(map[uint32]string),
 			Exports:       make(map[string]obj.ExportSymType),
 		}
 		objByPkg[pkgPath[i]] = pkg.Objidx
-		if err := readObj(&pkg, linker); err != nil {
+		pkgs = append(pkgs, &pkg)
+	}
+
+	// Archives are parsed concurrently, but added to the linker in the order given, so that symbol order (and which
+	// of several duplicate symbols wins) is the same as reading them one by one
+	parseErrs := make([]error, len(pkgs))
+	parallelFor(len(pkgs), linker.options.ReadParallelism, func(i int) {
+		parseErrs[i] = parseObj(pkgs[i])
+	})
+	for i, pkg := range pkgs {
+		err := parseErrs[i]
+		if err == nil {
+			err = linker.addObj(pkg)
+		}
+		if err != nil {
+			_ = linker.Release()
 			return nil, err
 		}
-		pkgs = append(pkgs, &pkg)
 		symNames = append(symNames, pkg.SymNameOrder...)
 	}
 
@@ -236,9 +358,11 @@ func ReadObjs(files []string, pkgPath []string, globalSymPtr map[string]uintptr,
 	for symName := range mainPkgSyms {
 		linker.collectReachableTypes(symName)
 	}
+	mainPkgSymNames := make([]string, 0, len(mainPkgSyms))
 	for symName := range mainPkgSyms {
-		linker.collectReachableSymbols(symName)
+		mainPkgSymNames = append(mainPkgSymNames, symName)
 	}
+	linker.collectReachableSymbolsFrom(mainPkgSymNames)
 
 	firstModuleTypesToForceRebuild := map[*_type]*obj.ObjSymbol{}
 
@@ -298,6 +422,7 @@ outer:
 		linker.collectReachableTypes(name)
 	}
 	if err := linker.addSymbols(symNames, globalSymPtr); err != nil {
+		_ = linker.Release()
 		return nil, err
 	}
 	linker.pkgs = pkgs
@@ -350,33 +475,66 @@ func (linker *Linker) collectReachableTypes(symName string) {
 }
 
 func (linker *Linker) collectReachableSymbols(symName string) {
+	linker.collectReachableSymbolsFrom([]string{symName})
+}
+
+// parallelReachabilityThreshold is the number of newly reached symbols worth spreading across goroutines
+const parallelReachabilityThreshold = 256
+
+// collectReachableSymbolsFrom marks everything reachable from roots. The graph is walked breadth first: the references
+// of each round's newly reached symbols are found concurrently, then merged in order, so the result doesn't depend on scheduling.
+func (linker *Linker) collectReachableSymbolsFrom(roots []string) {
 	// Don't have to be as clever as linker's deadcode.go - just add everything we can reference conservatively
-	if _, ok := linker.reachableSymbols[symName]; ok {
-		return
+	var frontier []string
+	for _, symName := range roots {
+		if _, ok := linker.reachableSymbols[symName]; !ok {
+			linker.reachableSymbols[symName] = struct{}{}
+			frontier = append(frontier, symName)
+		}
+	}
+	for len(frontier) > 0 {
+		refs := make([][]string, len(frontier))
+		parallelism := linker.options.ReadParallelism
+		if len(frontier) < parallelReachabilityThreshold {
+			parallelism = 1
+		}
+		parallelFor(len(frontier), parallelism, func(i int) {
+			refs[i] = linker.symbolReferences(frontier[i])
+		})
+		var next []string
+		for _, symRefs := range refs {
+			for _, symName := range symRefs {
+				if _, ok := linker.reachableSymbols[symName]; !ok {
+					linker.reachableSymbols[symName] = struct{}{}
+					next = append(next, symName)
+				}
+			}
+		}
+		frontier = next
 	}
+}
 
-	linker.reachableSymbols[symName] = struct{}{}
+// symbolReferences returns the names of all symbols which symName's symbol refers to. It only reads the linker's state.
+func (linker *Linker) symbolReferences(symName string) []string {
+	var refs []string
 	if strings.HasPrefix(symName, TypePrefix+"*") {
-		nonPtr := TypePrefix + strings.TrimPrefix(symName, TypePrefix+"*")
-		linker.collectReachableSymbols(nonPtr)
+		refs = append(refs, TypePrefix+strings.TrimPrefix(symName, TypePrefix+"*"))
 	}
-
 	objsym := linker.objsymbolMap[symName]
-	if objsym != nil {
-		if objsym.Type != "" {
-			linker.collectReachableSymbols(objsym.Type)
-		}
-
-		for _, reloc := range objsym.Reloc {
-			linker.collectReachableSymbols(reloc.Sym.Name)
-		}
-		if objsym.Func != nil {
-			for _, inl := range objsym.Func.InlTree {
-				linker.collectReachableSymbols(inl.Func)
-			}
-			for _, funcData := range objsym.Func.FuncData {
-				linker.collectReachableSymbols(funcData)
-			}
+	if objsym == nil {
+		return refs
+	}
+	if objsym.Type != "" {
+		refs = append(refs, objsym.Type)
+	}
+	for _, reloc := range objsym.Reloc {
+		refs = append(refs, reloc.Sym.Name)
+	}
+	if objsym.Func != nil {
+		for _, inl := range objsym.Func.InlTree {
+			refs = append(refs, inl.Func)
 		}
+		refs = append(refs, objsym.Func.FuncData...)
 	}
+	return refs
 }
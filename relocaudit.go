@@ -0,0 +1,179 @@
+package goloader
+
+import (
+	"cmd/objfile/objabi"
+	"encoding/json"
+	"io"
+
+	"github.com/eihigh/goloader/obj"
+	"github.com/eihigh/goloader/objabi/reloctype"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// RelocPhase is the step of loading which applied a relocation
+type RelocPhase int
+
+const (
+	RelocPhaseRelocate    RelocPhase = iota // Linker.relocate, when the module is first linked
+	RelocPhaseDeduplicate                   // Redirected to an equal type descriptor of the host or another module
+	RelocPhaseBind                          // Applied on a function's first call, see WithLazyBinding
+)
+
+func (p RelocPhase) String() string {
+	switch p {
+	case RelocPhaseRelocate:
+		return "relocate"
+	case RelocPhaseDeduplicate:
+		return "deduplicate"
+	case RelocPhaseBind:
+		return "bind"
+	}
+	return "unknown"
+}
+
+func (p RelocPhase) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// RelocTarget is where a relocation's target was resolved
+type RelocTarget int
+
+const (
+	RelocTargetSelf   RelocTarget = iota // The module being loaded
+	RelocTargetModule                    // Another loaded module
+	RelocTargetHost                      // The host binary, or memory outside any module such as TLS
+)
+
+func (t RelocTarget) String() string {
+	switch t {
+	case RelocTargetSelf:
+		return "self"
+	case RelocTargetModule:
+		return "module"
+	case RelocTargetHost:
+		return "host"
+	}
+	return "unknown"
+}
+
+func (t RelocTarget) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// RelocOverflow is how a relocation whose target was out of range of its instruction was resolved
+type RelocOverflow int
+
+const (
+	RelocInRange    RelocOverflow = iota
+	RelocEpilogue                 // Jumps to an epilogue after the function which reaches the target
+	RelocTrampoline               // Calls a trampoline in the far reference table, see FarRefStats
+	RelocGOTSlot                  // Loads the target's address from a GOT slot in the far reference table
+	RelocTypemap                  // Left pointing at the module's own copy of a type, which its typemap redirects to the target
+)
+
+func (o RelocOverflow) String() string {
+	switch o {
+	case RelocInRange:
+		return "none"
+	case RelocEpilogue:
+		return "epilogue"
+	case RelocTrampoline:
+		return "trampoline"
+	case RelocGOTSlot:
+		return "got"
+	case RelocTypemap:
+		return "typemap"
+	}
+	return "unknown"
+}
+
+func (o RelocOverflow) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// RelocRecord describes a relocation applied to a module, see WithRelocationRecords
+type RelocRecord struct {
+	Phase      RelocPhase    `json:"phase"`
+	Symbol     string        `json:"symbol"` // The symbol containing the relocation
+	Offset     int           `json:"offset"` // Within Symbol
+	Addr       uintptr       `json:"addr"`   // Of the relocated bytes
+	Type       string        `json:"type"`
+	Target     string        `json:"target"`
+	Add        int           `json:"add"`
+	TargetAddr uintptr       `json:"targetAddr"` // Of Target, not including Add
+	Resolved   RelocTarget   `json:"resolved"`
+	Overflow   RelocOverflow `json:"overflow"`
+}
+
+// recordReloc counts loc towards the module's LoadStats once it has been applied, and records it if records were asked
+// for. Relocations which only mark a dependency for the linker, and so don't change the module, are left out of both.
+func (linker *Linker) recordReloc(codeModule *CodeModule, phase RelocPhase, symbol *obj.Sym, loc obj.Reloc, addr uintptr) {
+	if loc.Offset == InvalidOffset || symbol.Offset < 0 {
+		return
+	}
+	switch loc.Type {
+	case reloctype.R_CALLIND, reloctype.R_USETYPE, reloctype.R_USEIFACE, reloctype.R_USEIFACEMETHOD,
+		reloctype.R_ADDRCUOFF, reloctype.R_KEEP, reloctype.R_INITORDER:
+		return
+	}
+	var weakness string
+	if loc.Type&reloctype.R_WEAK > 0 {
+		weakness = "WEAK|"
+	}
+	typ := weakness + objabi.RelocType(loc.Type&^reloctype.R_WEAK).String()
+	codeModule.countReloc(phase, typ, linker.relocOverflow)
+	if !linker.options.RecordRelocations {
+		return
+	}
+	base := codeModule.dataBase
+	if symbol.Kind == symkind.STEXT {
+		base = codeModule.codeBase
+	}
+	record := RelocRecord{
+		Phase:      phase,
+		Symbol:     symbol.Name,
+		Offset:     loc.Offset - symbol.Offset,
+		Addr:       uintptr(base + loc.Offset),
+		Type:       typ,
+		Target:     loc.Sym.Name,
+		Add:        loc.Add,
+		TargetAddr: addr,
+		Resolved:   RelocTargetHost,
+		Overflow:   linker.relocOverflow,
+	}
+	if codeModule.contains(addr) {
+		record.Resolved = RelocTargetSelf
+	} else {
+		modulesLock.Lock()
+		for cm := range modules {
+			if cm.contains(addr) {
+				record.Resolved = RelocTargetModule
+				break
+			}
+		}
+		modulesLock.Unlock()
+	}
+	codeModule.relocRecords = append(codeModule.relocRecords, record)
+}
+
+// RelocRecords returns the relocations applied to the module, in the order they were applied. Relocations redirected
+// by type deduplication appear again for the second time they were applied, while those of functions left to be bound
+// on first call only appear once bound. It's empty unless the module was loaded with WithRelocationRecords.
+func (cm *CodeModule) RelocRecords() []RelocRecord {
+	if cm.lazy != nil {
+		cm.lazy.mu.Lock()
+		defer cm.lazy.mu.Unlock()
+	}
+	return append([]RelocRecord(nil), cm.relocRecords...)
+}
+
+// WriteRelocRecords writes the module's RelocRecords to w as JSON lines
+func (cm *CodeModule) WriteRelocRecords(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, record := range cm.RelocRecords() {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package goloader
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// SizeBreakdown is the number of bytes a symbol or package adds to a module, by where they're placed
+type SizeBreakdown struct {
+	Text     int `json:"text"`     // Function code, including alignment but not relocation epilogues
+	Epilogue int `json:"epilogue"` // Relocation epilogues appended to functions, see WithNoRelocationEpilogues
+	Rodata   int `json:"rodata"`   // Including string constants, which are kept on the heap rather than in the module
+	Data     int `json:"data"`     // data and noptrdata
+	BSS      int `json:"bss"`      // bss and noptrbss
+	Pcln     int `json:"pcln"`     // Each function's name, pc-value tables, _func, functab entry and funcdata such as stack maps
+}
+
+// Total returns the bytes of all kinds
+func (b SizeBreakdown) Total() int {
+	return b.Text + b.Epilogue + b.Rodata + b.Data + b.BSS + b.Pcln
+}
+
+func (b *SizeBreakdown) add(other SizeBreakdown) {
+	b.Text += other.Text
+	b.Epilogue += other.Epilogue
+	b.Rodata += other.Rodata
+	b.Data += other.Data
+	b.BSS += other.BSS
+	b.Pcln += other.Pcln
+}
+
+// SizeReportSymbol is a symbol's size in a SizeReport
+type SizeReportSymbol struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	SizeBreakdown
+	Roots []string `json:"roots,omitempty"` // The nearest roots which reach the symbol, if it was among the largest explained
+	Path  []string `json:"path,omitempty"`  // The shortest chain of references to the symbol from one of Roots
+}
+
+// SizeReportPackage totals the sizes of a package's symbols in a SizeReport
+type SizeReportPackage struct {
+	Package string `json:"package"`
+	SizeBreakdown
+	Symbols int `json:"symbols"`
+}
+
+// SizeReport attributes the bytes a linker adds to a module to the packages and symbols they belong to, like
+// go tool nm -size plus each function's pcln overhead. The far reference table, whose size is only known once the
+// module is relocated, isn't included, see FarRefStats. Reports can be saved as JSON and compared with
+// DiffSizeReports.
+type SizeReport struct {
+	Total    SizeBreakdown       `json:"total"`
+	Packages []SizeReportPackage `json:"packages"` // Largest first
+	Symbols  []SizeReportSymbol  `json:"symbols"`  // Largest first
+}
+
+// SizeReport returns the size of each symbol and package the linker read. For the explain largest symbols, it also
+// finds the roots keeping them alive: the module's package's own symbols, from which collectReachableSymbols
+// conservatively marks everything they refer to as reachable. It fails once the linker is released.
+func (linker *Linker) SizeReport(explain int) (*SizeReport, error) {
+	if linker.released {
+		return nil, fmt.Errorf("can't report the sizes of a linker after Release")
+	}
+	report := &SizeReport{}
+	pkgPath := linker.pkgPathResolver()
+	pkgs := map[string]*SizeReportPackage{}
+	funcdata := map[string]struct{}{}
+	for _, objsym := range linker.objsymbolMap {
+		if objsym.Func != nil {
+			for _, name := range objsym.Func.FuncData {
+				funcdata[name] = struct{}{}
+			}
+		}
+	}
+	for name, symbol := range linker.symMap {
+		objsym := linker.objsymbolMap[name]
+		if symbol.Offset == InvalidOffset || objsym == nil {
+			continue
+		}
+		size := SizeReportSymbol{Name: name, Package: pkgPath(objsym.Pkg)}
+		switch symbol.Kind {
+		case symkind.STEXT:
+			for _, reloc := range symbol.Reloc {
+				size.Epilogue += reloc.EpilogueSize
+			}
+			size.Text = symbol.Size - size.Epilogue
+			if objsym.Func != nil {
+				size.Pcln = len(name) + 1 + len(objsym.Func.PCSP) + len(objsym.Func.PCFile) + len(objsym.Func.PCLine) + len(objsym.Func.PCInline) +
+					_FuncSize + Uint32Size*(len(objsym.Func.PCData)+len(objsym.Func.FuncData)+2)
+				for _, pcdata := range objsym.Func.PCData {
+					size.Pcln += len(pcdata)
+				}
+			}
+		case symkind.SDATA:
+			size.Data = symbol.Size
+		case symkind.SNOPTRDATA, symkind.SRODATA:
+			if _, ok := funcdata[name]; ok {
+				size.Pcln = len(objsym.Data)
+			} else if strings.HasPrefix(name, TypeStringPrefix) || isReadOnlyDataSymbol(symbol.Kind) {
+				size.Rodata = len(objsym.Data)
+			} else {
+				size.Data = len(objsym.Data)
+			}
+		case symkind.SBSS, symkind.SNOPTRBSS:
+			size.BSS = symbol.Size
+		default:
+			continue
+		}
+		report.Symbols = append(report.Symbols, size)
+		report.Total.add(size.SizeBreakdown)
+		pkg := pkgs[size.Package]
+		if pkg == nil {
+			pkg = &SizeReportPackage{Package: size.Package}
+			pkgs[size.Package] = pkg
+		}
+		pkg.add(size.SizeBreakdown)
+		pkg.Symbols++
+	}
+	for _, pkg := range pkgs {
+		report.Packages = append(report.Packages, *pkg)
+	}
+	sort.Slice(report.Packages, func(i, j int) bool {
+		a, b := report.Packages[i], report.Packages[j]
+		return a.Total() > b.Total() || a.Total() == b.Total() && a.Package < b.Package
+	})
+	sort.Slice(report.Symbols, func(i, j int) bool {
+		a, b := report.Symbols[i], report.Symbols[j]
+		return a.Total() > b.Total() || a.Total() == b.Total() && a.Name < b.Name
+	})
+	if explain > len(report.Symbols) {
+		explain = len(report.Symbols)
+	}
+	if explain > 0 && len(linker.pkgs) > 0 {
+		linker.explainSizes(report.Symbols[:explain])
+	}
+	return report, nil
+}
+
+// explainSizes finds the roots of each symbol by walking the references between reachable symbols backwards from it,
+// stopping at the first roots found along each chain
+func (linker *Linker) explainSizes(symbols []SizeReportSymbol) {
+	roots := linker.pkgs[len(linker.pkgs)-1].Syms
+	referrers := map[string][]string{}
+	reachable := make([]string, 0, len(linker.reachableSymbols))
+	for symName := range linker.reachableSymbols {
+		reachable = append(reachable, symName)
+	}
+	sort.Strings(reachable)
+	for _, symName := range reachable {
+		for _, ref := range linker.symbolReferences(symName) {
+			if ref != symName {
+				referrers[ref] = append(referrers[ref], symName)
+			}
+		}
+	}
+
+	for i := range symbols {
+		target := symbols[i].Name
+		// next leads from each symbol found one step closer to the target
+		next := map[string]string{target: ""}
+		queue := []string{target}
+		for len(queue) > 0 {
+			symName := queue[0]
+			queue = queue[1:]
+			if _, isRoot := roots[symName]; isRoot {
+				if symbols[i].Path == nil {
+					for step := symName; step != ""; step = next[step] {
+						symbols[i].Path = append(symbols[i].Path, step)
+					}
+				}
+				symbols[i].Roots = append(symbols[i].Roots, symName)
+				continue
+			}
+			for _, referrer := range referrers[symName] {
+				if _, seen := next[referrer]; !seen {
+					next[referrer] = symName
+					queue = append(queue, referrer)
+				}
+			}
+		}
+		sort.Strings(symbols[i].Roots)
+	}
+}
+
+// WriteText writes the report to w as tables of packages and the largest top symbols, or every symbol if top is 0
+func (r *SizeReport) WriteText(w io.Writer, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	header := "total\ttext\tepilogue\trodata\tdata\tbss\tpcln\t"
+	row := func(b SizeBreakdown, name string) {
+		_, _ = fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t  %s\n", b.Total(), b.Text, b.Epilogue, b.Rodata, b.Data, b.BSS, b.Pcln, name)
+	}
+	_, _ = fmt.Fprintln(tw, header+"  package")
+	for _, pkg := range r.Packages {
+		row(pkg.SizeBreakdown, pkg.Package)
+	}
+	row(r.Total, "(total)")
+	_, _ = fmt.Fprintln(tw)
+	_, _ = fmt.Fprintln(tw, header+"  symbol")
+	for i, symbol := range r.Symbols {
+		if top > 0 && i == top {
+			break
+		}
+		row(symbol.SizeBreakdown, symbol.Name)
+		if len(symbol.Path) > 0 {
+			_, _ = fmt.Fprintf(tw, "\t\t\t\t\t\t\t    reached via %s\n", strings.Join(symbol.Path, " -> "))
+		}
+		if len(symbol.Roots) > 1 {
+			_, _ = fmt.Fprintf(tw, "\t\t\t\t\t\t\t    and %d other roots\n", len(symbol.Roots)-1)
+		}
+	}
+	return tw.Flush()
+}
+
+// SizeDelta is the change in size of a package or symbol between two SizeReports
+type SizeDelta struct {
+	Name string        `json:"name"`
+	Old  SizeBreakdown `json:"old"`
+	New  SizeBreakdown `json:"new"`
+}
+
+// Change returns the change in total size, which is negative if it shrank
+func (d SizeDelta) Change() int {
+	return d.New.Total() - d.Old.Total()
+}
+
+// SizeDiff is the difference between two SizeReports, see DiffSizeReports
+type SizeDiff struct {
+	Total    SizeDelta   `json:"total"`
+	Packages []SizeDelta `json:"packages"` // Largest change first
+	Symbols  []SizeDelta `json:"symbols"`  // Largest change first
+}
+
+// DiffSizeReports compares the reports of two builds, returning the packages and symbols which were added, removed or
+// changed in size
+func DiffSizeReports(old, new *SizeReport) *SizeDiff {
+	diff := &SizeDiff{Total: SizeDelta{Name: "(total)", Old: old.Total, New: new.Total}}
+	oldPkgs, newPkgs := map[string]SizeBreakdown{}, map[string]SizeBreakdown{}
+	for _, pkg := range old.Packages {
+		oldPkgs[pkg.Package] = pkg.SizeBreakdown
+	}
+	for _, pkg := range new.Packages {
+		newPkgs[pkg.Package] = pkg.SizeBreakdown
+	}
+	oldSyms, newSyms := map[string]SizeBreakdown{}, map[string]SizeBreakdown{}
+	for _, symbol := range old.Symbols {
+		oldSyms[symbol.Name] = symbol.SizeBreakdown
+	}
+	for _, symbol := range new.Symbols {
+		newSyms[symbol.Name] = symbol.SizeBreakdown
+	}
+	diff.Packages = diffSizes(oldPkgs, newPkgs)
+	diff.Symbols = diffSizes(oldSyms, newSyms)
+	return diff
+}
+
+func diffSizes(old, new map[string]SizeBreakdown) []SizeDelta {
+	var deltas []SizeDelta
+	for name, size := range new {
+		if oldSize, ok := old[name]; !ok || oldSize != size {
+			deltas = append(deltas, SizeDelta{Name: name, Old: oldSize, New: size})
+		}
+	}
+	for name, size := range old {
+		if _, ok := new[name]; !ok {
+			deltas = append(deltas, SizeDelta{Name: name, Old: size})
+		}
+	}
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		a, b := abs(deltas[i].Change()), abs(deltas[j].Change())
+		return a > b || a == b && deltas[i].Name < deltas[j].Name
+	})
+	return deltas
+}
+
+// WriteText writes the diff to w as tables of the changed packages and the top symbols with the largest changes, or
+// every changed symbol if top is 0
+func (d *SizeDiff) WriteText(w io.Writer, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	row := func(delta SizeDelta) {
+		_, _ = fmt.Fprintf(tw, "%d\t%d\t%+d\t  %s\n", delta.Old.Total(), delta.New.Total(), delta.Change(), delta.Name)
+	}
+	_, _ = fmt.Fprintln(tw, "old\tnew\tchange\t  package")
+	for _, delta := range d.Packages {
+		row(delta)
+	}
+	row(d.Total)
+	_, _ = fmt.Fprintln(tw)
+	_, _ = fmt.Fprintln(tw, "old\tnew\tchange\t  symbol")
+	for i, delta := range d.Symbols {
+		if top > 0 && i == top {
+			break
+		}
+		row(delta)
+	}
+	return tw.Flush()
+}
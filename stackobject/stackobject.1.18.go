@@ -19,6 +19,6 @@ type stackObjectRecord struct {
 	gcdataoff uint32 // offset to gcdata from moduledata.rodata
 }
 
-func setStackObjectPtr(obj *stackObjectRecord, ptr unsafe.Pointer, noptrdata uintptr) {
-	obj.gcdataoff = uint32(uintptr(ptr) - noptrdata)
+func setStackObjectPtr(obj *stackObjectRecord, ptr unsafe.Pointer, rodata uintptr) {
+	obj.gcdataoff = uint32(uintptr(ptr) - rodata)
 }
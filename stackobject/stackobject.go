@@ -35,22 +35,29 @@ func addr2stackObjectRecords(addr unsafe.Pointer) *[]stackObjectRecord {
 	return (*[]stackObjectRecord)(unsafe.Pointer(&slice))
 }
 
-func AddStackObject(funcname string, symMap map[string]*obj.Sym, symbolMap map[string]uintptr, noptrdata uintptr) (err error) {
+func AddStackObject(funcname string, symMap map[string]*obj.Sym, symbolMap map[string]uintptr, rodata uintptr) (err error) {
 	Func := symMap[funcname].Func
 	if Func != nil && len(Func.FuncData) > dataindex.FUNCDATA_StackObjects &&
 		Func.FuncData[dataindex.FUNCDATA_StackObjects] != 0 {
-		objects := addr2stackObjectRecords(adduintptr(Func.FuncData[dataindex.FUNCDATA_StackObjects], int(noptrdata)))
-		for i := range *objects {
-			name := EmptyString
-			stkobjName := strings.TrimSuffix(funcname, obj.ABIInternalSuffix) + StkobjSuffix
-			if symbol := symMap[stkobjName]; symbol != nil {
-				name = symbol.Reloc[i].Sym.Name
-			}
-			if ptr, ok := symbolMap[name]; ok {
-				setStackObjectPtr(&((*objects)[i]), adduintptr(ptr, 0), noptrdata)
-			} else {
-				return fmt.Errorf("unresolved external var! Function name: %s index: %d, name:%s", funcname, i, name)
-			}
+		return SetStackObjectPtrs(funcname, adduintptr(Func.FuncData[dataindex.FUNCDATA_StackObjects], int(rodata)), symMap, symbolMap, rodata)
+	}
+	return nil
+}
+
+// SetStackObjectPtrs points funcname's stack object records, at addr, at the gcdata of each object's type, as offsets
+// from the module's rodata
+func SetStackObjectPtrs(funcname string, addr unsafe.Pointer, symMap map[string]*obj.Sym, symbolMap map[string]uintptr, rodata uintptr) (err error) {
+	objects := addr2stackObjectRecords(addr)
+	for i := range *objects {
+		name := EmptyString
+		stkobjName := strings.TrimSuffix(funcname, obj.ABIInternalSuffix) + StkobjSuffix
+		if symbol := symMap[stkobjName]; symbol != nil {
+			name = symbol.Reloc[i].Sym.Name
+		}
+		if ptr, ok := symbolMap[name]; ok {
+			setStackObjectPtr(&((*objects)[i]), adduintptr(ptr, 0), rodata)
+		} else {
+			return fmt.Errorf("unresolved external var! Function name: %s index: %d, name:%s", funcname, i, name)
 		}
 	}
 	return nil
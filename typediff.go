@@ -0,0 +1,202 @@
+package goloader
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+const (
+	TypeDiffKindChanged       = "kind changed"
+	TypeDiffNameChanged       = "type name changed"
+	TypeDiffPkgPathChanged    = "package path differs"
+	TypeDiffFieldAdded        = "field added"
+	TypeDiffFieldRemoved      = "field removed"
+	TypeDiffFieldTagChanged   = "tag differs"
+	TypeDiffFieldOffset       = "field offset differs"
+	TypeDiffFieldEmbedding    = "field embedding differs"
+	TypeDiffMethodSetChanged  = "method set differs"
+	TypeDiffLenChanged        = "array length differs"
+	TypeDiffChanDirChanged    = "channel direction differs"
+	TypeDiffSignatureChanged  = "function signature differs"
+	TypeDiffUnexplainedChange = "types differ"
+)
+
+// TypeDifference describes one divergence between two types, as found by DiffTypes
+type TypeDifference struct {
+	Path   string // Location of the divergence relative to the compared types, e.g. .Spec.Ports[].Protocol
+	Reason string // One of the TypeDiff* constants
+	Old    string // Description of the old side of the difference (may be empty for additions)
+	New    string // Description of the new side of the difference (may be empty for removals)
+}
+
+func (d TypeDifference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "."
+	}
+	switch {
+	case d.Old == "":
+		return fmt.Sprintf("%s: %s (%s)", path, d.Reason, d.New)
+	case d.New == "":
+		return fmt.Sprintf("%s: %s (%s)", path, d.Reason, d.Old)
+	default:
+		return fmt.Sprintf("%s: %s (%s vs %s)", path, d.Reason, d.Old, d.New)
+	}
+}
+
+func formatTypeDifferences(diffs []TypeDifference) string {
+	lines := make([]string, len(diffs))
+	for i, diff := range diffs {
+		lines[i] = "    " + diff.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+type typeDiffer struct {
+	seen  map[_typePair]struct{}
+	diffs []TypeDifference
+}
+
+// DiffTypes walks a and b in the same way as the runtime's typesEqual, and returns a description of every place they
+// diverge, rather than just whether they are equal. An empty result means typesEqual considers them equal: if it
+// doesn't, but the walk found nothing to explain why, a single TypeDiffUnexplainedChange is returned for the types as
+// a whole.
+func DiffTypes(a, b reflect.Type) []TypeDifference {
+	d := typeDiffer{seen: map[_typePair]struct{}{}}
+	d.diff("", a, b)
+	if len(d.diffs) == 0 && a != b && !typesEqual(fromRType(a), fromRType(b), map[_typePair]struct{}{}) {
+		d.add("", TypeDiffUnexplainedChange, a.String(), b.String())
+	}
+	return d.diffs
+}
+
+func (d *typeDiffer) add(path, reason, old, new string) {
+	d.diffs = append(d.diffs, TypeDifference{Path: path, Reason: reason, Old: old, New: new})
+}
+
+func (d *typeDiffer) diff(path string, a, b reflect.Type) {
+	if a == b {
+		return
+	}
+	pair := _typePair{fromRType(a), fromRType(b)}
+	if _, ok := d.seen[pair]; ok {
+		return
+	}
+	d.seen[pair] = struct{}{}
+
+	if a.Kind() != b.Kind() {
+		d.add(path, TypeDiffKindChanged, a.Kind().String(), b.Kind().String())
+		return
+	}
+	if a.String() != b.String() && (a.Name() != "" || b.Name() != "") {
+		d.add(path, TypeDiffNameChanged, a.String(), b.String())
+		return
+	}
+	if pa, pb := fromRType(a).PkgPath(), fromRType(b).PkgPath(); pa != pb {
+		d.add(path, TypeDiffPkgPathChanged, pa, pb)
+		return
+	}
+
+	before := len(d.diffs)
+	switch a.Kind() {
+	case reflect.Array:
+		if a.Len() != b.Len() {
+			d.add(path, TypeDiffLenChanged, strconv.Itoa(a.Len()), strconv.Itoa(b.Len()))
+		}
+		d.diff(path+"[]", a.Elem(), b.Elem())
+	case reflect.Chan:
+		if a.ChanDir() != b.ChanDir() {
+			d.add(path, TypeDiffChanDirChanged, a.ChanDir().String(), b.ChanDir().String())
+		}
+		d.diff(path+"<-", a.Elem(), b.Elem())
+	case reflect.Func:
+		if a.NumIn() != b.NumIn() || a.NumOut() != b.NumOut() || a.IsVariadic() != b.IsVariadic() {
+			d.add(path, TypeDiffSignatureChanged, a.String(), b.String())
+			break
+		}
+		for i := 0; i < a.NumIn(); i++ {
+			d.diff(fmt.Sprintf("%s(in %d)", path, i), a.In(i), b.In(i))
+		}
+		for i := 0; i < a.NumOut(); i++ {
+			d.diff(fmt.Sprintf("%s(out %d)", path, i), a.Out(i), b.Out(i))
+		}
+	case reflect.Interface:
+		ita := (*interfacetype)(unsafe.Pointer(fromRType(a)))
+		itb := (*interfacetype)(unsafe.Pointer(fromRType(b)))
+		if pa, pb := ita.pkgpath.name(), itb.pkgpath.name(); pa != pb {
+			d.add(path, TypeDiffPkgPathChanged, pa, pb)
+		}
+		methodsB := map[string]reflect.Method{}
+		for i := 0; i < b.NumMethod(); i++ {
+			methodsB[b.Method(i).Name] = b.Method(i)
+		}
+		for i := 0; i < a.NumMethod(); i++ {
+			ma := a.Method(i)
+			mb, ok := methodsB[ma.Name]
+			if !ok {
+				d.add(path+"."+ma.Name+"()", TypeDiffMethodSetChanged, "method "+ma.Name+" "+ma.Type.String(), "")
+				continue
+			}
+			delete(methodsB, ma.Name)
+			if ma.PkgPath != mb.PkgPath {
+				d.add(path+"."+ma.Name+"()", TypeDiffPkgPathChanged, ma.PkgPath, mb.PkgPath)
+			}
+			d.diff(path+"."+ma.Name+"()", ma.Type, mb.Type)
+		}
+		for i := 0; i < b.NumMethod(); i++ {
+			if mb, ok := methodsB[b.Method(i).Name]; ok {
+				d.add(path+"."+mb.Name+"()", TypeDiffMethodSetChanged, "", "method "+mb.Name+" "+mb.Type.String())
+			}
+		}
+	case reflect.Map:
+		d.diff(path+"[key]", a.Key(), b.Key())
+		d.diff(path+"[]", a.Elem(), b.Elem())
+	case reflect.Ptr:
+		d.diff(path, a.Elem(), b.Elem())
+	case reflect.Slice:
+		d.diff(path+"[]", a.Elem(), b.Elem())
+	case reflect.Struct:
+		fieldsB := map[string]int{}
+		for i := 0; i < b.NumField(); i++ {
+			fieldsB[b.Field(i).Name] = i
+		}
+		for i := 0; i < a.NumField(); i++ {
+			fa := a.Field(i)
+			fieldPath := path + "." + fa.Name
+			j, ok := fieldsB[fa.Name]
+			if !ok {
+				d.add(fieldPath, TypeDiffFieldRemoved, fa.Type.String(), "")
+				continue
+			}
+			delete(fieldsB, fa.Name)
+			fb := b.Field(j)
+			if fa.PkgPath != fb.PkgPath {
+				d.add(fieldPath, TypeDiffPkgPathChanged, fa.PkgPath, fb.PkgPath)
+			}
+			if fa.Tag != fb.Tag {
+				d.add(fieldPath, TypeDiffFieldTagChanged, strconv.Quote(string(fa.Tag)), strconv.Quote(string(fb.Tag)))
+			}
+			if fa.Anonymous != fb.Anonymous {
+				d.add(fieldPath, TypeDiffFieldEmbedding, strconv.FormatBool(fa.Anonymous), strconv.FormatBool(fb.Anonymous))
+			}
+			prevDiffs := len(d.diffs)
+			d.diff(fieldPath, fa.Type, fb.Type)
+			if fa.Offset != fb.Offset && len(d.diffs) == prevDiffs {
+				// Only worth mentioning if the offset isn't already explained by this field's type changing
+				d.add(fieldPath, TypeDiffFieldOffset, strconv.FormatUint(uint64(fa.Offset), 10), strconv.FormatUint(uint64(fb.Offset), 10))
+			}
+		}
+		for i := 0; i < b.NumField(); i++ {
+			fb := b.Field(i)
+			if _, ok := fieldsB[fb.Name]; ok {
+				d.add(path+"."+fb.Name, TypeDiffFieldAdded, "", fb.Type.String())
+			}
+		}
+	}
+	if len(d.diffs) == before && a.String() != b.String() {
+		d.add(path, TypeDiffUnexplainedChange, a.String(), b.String())
+	}
+}
@@ -0,0 +1,88 @@
+package goloader
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// typeIndex indexes the type descriptors of the host binary and every loaded module by hash, so that finding a type's
+// equivalent in another module doesn't need all the host's typelinks to be hashed again on every load or conversion.
+// The host's types are indexed on first use, and each module's are added and removed along with the module itself.
+// Results of typesEqual are cached too, since the same pairs tend to be compared on every reload.
+type typeIndex struct {
+	mu       sync.Mutex
+	byModule map[*moduledata]map[uint32][]*_type
+	equal    map[_typePair]bool
+}
+
+var typeIdx = &typeIndex{
+	byModule: map[*moduledata]map[uint32][]*_type{},
+	equal:    map[_typePair]bool{},
+}
+
+// hashesOf returns md's types by hash, indexing them if this is the first time md has been seen.
+// The returned map is never modified, so can be read without holding the lock.
+func (idx *typeIndex) hashesOf(md *moduledata) map[uint32][]*_type {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	hashes, ok := idx.byModule[md]
+	if !ok {
+		hashes = make(map[uint32][]*_type, len(md.typelinks))
+		buildModuleTypeHash(md, hashes)
+		idx.byModule[md] = hashes
+	}
+	return hashes
+}
+
+// lookup returns the types with the given hash in the host binary, followed by those in each of modules
+func (idx *typeIndex) lookup(hash uint32, modules ...*moduledata) []*_type {
+	types := idx.hashesOf(activeModules()[0])[hash]
+	for _, md := range modules {
+		if more := idx.hashesOf(md)[hash]; len(more) > 0 {
+			types = append(types[:len(types):len(types)], more...)
+		}
+	}
+	return types
+}
+
+func (idx *typeIndex) addModule(cm *CodeModule) {
+	idx.hashesOf(cm.module)
+}
+
+// removeModule forgets cm's types, and any cached comparison involving a type descriptor in cm's data,
+// since that memory may be reused by a later module
+func (idx *typeIndex) removeModule(cm *CodeModule) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.byModule, cm.module)
+	start := uintptr(cm.dataBase)
+	end := start + uintptr(len(cm.dataByte))
+	inModule := func(t *_type) bool {
+		addr := uintptr(unsafe.Pointer(t))
+		return addr >= start && addr < end
+	}
+	for pair := range idx.equal {
+		if inModule(pair.t1) || inModule(pair.t2) {
+			delete(idx.equal, pair)
+		}
+	}
+}
+
+// typesEqualCached is typesEqual, with the result remembered for as long as both types are loaded
+func typesEqualCached(t, v *_type) bool {
+	if t == v {
+		return true
+	}
+	pair := _typePair{t, v}
+	typeIdx.mu.Lock()
+	equal, ok := typeIdx.equal[pair]
+	typeIdx.mu.Unlock()
+	if ok {
+		return equal
+	}
+	equal = typesEqual(t, v, map[_typePair]struct{}{})
+	typeIdx.mu.Lock()
+	typeIdx.equal[pair] = equal
+	typeIdx.mu.Unlock()
+	return equal
+}
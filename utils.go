@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/eihigh/goloader/mmap"
@@ -25,6 +27,34 @@ func putUint24(b []byte, v uint32) {
 	b[2] = byte(v >> 16)
 }
 
+// parallelFor calls f for every index in [0, n) using up to parallelism goroutines, or GOMAXPROCS if parallelism is 0
+func parallelFor(n, parallelism int, f func(i int)) {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism > n {
+		parallelism = n
+	}
+	if parallelism <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(parallelism)
+	for w := 0; w < parallelism; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < n; i = int(atomic.AddInt64(&next, 1)) {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func alignof(i int, align int) int {
 	if i%align != 0 {
 		i = i + (align - i%align)
@@ -0,0 +1,271 @@
+package goloader
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/eihigh/goloader/objabi/dataindex"
+	"github.com/eihigh/goloader/objabi/reloctype"
+	"github.com/eihigh/goloader/objabi/symkind"
+)
+
+// Violation is an inconsistency in a loaded module's metadata found by CodeModule.Verify
+type Violation struct {
+	Check  string  // One of findfunc, pcsp, pcfile, pcline, stackmap, gcdata or gcbss
+	Symbol string  // The function or data symbol concerned, if known
+	Addr   uintptr // The PC or data address concerned
+	Detail string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s at 0x%x: %s", v.Check, v.Symbol, v.Addr, v.Detail)
+}
+
+// dataType is a data or bss symbol's declared type, which its part of the module's gcdata or gcbss was built from
+type dataType struct {
+	addr uintptr
+	typ  *_type
+}
+
+// buildCallSites records the PC of the last byte of every call instruction in the module, which is where the runtime
+// looks up the stack map of a frame whose function made the call. Calls to morestack from stack checks are skipped,
+// since those are made before the frame exists.
+func (linker *Linker) buildCallSites(codeModule *CodeModule) {
+	var sites []uintptr
+	for _, symbol := range linker.symMap {
+		if _, shared := linker.sharedSyms[symbol.Name]; shared || symbol.Kind != symkind.STEXT || symbol.Offset < 0 {
+			continue
+		}
+		for _, loc := range symbol.Reloc {
+			if loc.Offset == InvalidOffset || strings.HasPrefix(loc.Sym.Name, "runtime.morestack") {
+				continue
+			}
+			switch loc.Type &^ reloctype.R_WEAK {
+			case reloctype.R_CALL, reloctype.R_CALLARM, reloctype.R_CALLARM64:
+				sites = append(sites, uintptr(codeModule.codeBase+loc.Offset+loc.Size-1))
+			case reloctype.R_CALLIND:
+				// Only the start of an indirect call is known, but its stack map index can't change within it
+				sites = append(sites, uintptr(codeModule.codeBase+loc.Offset))
+			}
+		}
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i] < sites[j] })
+	codeModule.callSites = sites
+}
+
+// Verify checks that the runtime's view of the module is consistent, which is useful when chasing linker bugs which
+// would otherwise only show up later as "unexpected return pc" tracebacks or bad pointers found during GC. It checks
+// that findfunc resolves the entry, last byte and every pc-value boundary of each function to that function, that each
+// function's pcsp, pcfile and pcline tables decode and cover its code, that a stack map exists for every call site,
+// and that the module's gcdata and gcbss bitmaps match the declared types of its data and bss symbols.
+// Faults while reading the module's metadata are reported as violations too, rather than crashing the process.
+func (cm *CodeModule) Verify() []Violation {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	var violations []Violation
+	for _, symbol := range cm.symbols {
+		if symbol.Kind == SymbolText {
+			violations = append(violations, cm.verifyFunc(symbol)...)
+		}
+	}
+	md := cm.module
+	violations = append(violations, cm.verifyGCMask("gcdata", md.data, md.edata, md.gcdatamask)...)
+	violations = append(violations, cm.verifyGCMask("gcbss", md.bss, md.ebss, md.gcbssmask)...)
+	return violations
+}
+
+func (cm *CodeModule) verifyFunc(symbol Symbol) (violations []Violation) {
+	report := func(check string, addr uintptr, format string, args ...interface{}) {
+		violations = append(violations, Violation{Check: check, Symbol: symbol.Name, Addr: addr, Detail: fmt.Sprintf(format, args...)})
+	}
+	check := "findfunc"
+	defer func() {
+		if v := recover(); v != nil {
+			report(check, symbol.Addr, "fault while reading function metadata: %v", v)
+		}
+	}()
+
+	entry, end := symbol.Addr, symbol.Addr+symbol.Size
+	codeEnd := end
+	if f, ok := cm.funcs[symbol.Name]; ok {
+		codeEnd = f.entry + uintptr(f.size)
+	}
+	f := findfunc(entry)
+	if f._func == nil {
+		report(check, entry, "no function found")
+		return violations
+	}
+	if f.datap != cm.module || getfuncentry(f._func, f.datap.text) != entry || funcname(f) != symbol.Name {
+		report(check, entry, "found %s at 0x%x instead", funcname(f), getfuncentry(f._func, f.datap.text))
+		return violations
+	}
+	resolves := func(pc uintptr) bool {
+		return findfunc(pc)._func == f._func
+	}
+	if !resolves(end - 1) {
+		report(check, end-1, "last byte resolves to %s", funcname(findfunc(end-1)))
+	}
+
+	tables := []struct {
+		check string
+		off   uint32
+	}{{"pcsp", f.pcsp}, {"pcfile", f.pcfile}, {"pcline", f.pcln}}
+	for _, table := range tables {
+		check = table.check
+		if table.off == 0 || table.off >= uint32(len(cm.module.pctab)) {
+			report(check, entry, "table offset %d is outside pctab (%d bytes)", table.off, len(cm.module.pctab))
+			continue
+		}
+		pcs, vals := decodeTable(cm.module.pctab[table.off:], entry, end)
+		if len(pcs) == 0 {
+			report(check, entry, "table is empty")
+			continue
+		}
+		for i, pc := range pcs {
+			if pc > end {
+				report(check, pc, "table runs past the end of the function at 0x%x", end)
+				break
+			}
+			if pc < end && !resolves(pc) {
+				report("findfunc", pc, "pc-value boundary resolves to %s", funcname(findfunc(pc)))
+			}
+			val := vals[i]
+			switch {
+			case check == "pcsp" && val < 0:
+				report(check, pc, "negative SP delta %d", val)
+			case check == "pcline" && val < 0:
+				report(check, pc, "negative line %d", val)
+			case check == "pcfile":
+				if val < 0 || int(f.cuOffset)+int(val) >= len(cm.module.cutab) {
+					report(check, pc, "file index %d is outside the CU's files", val)
+				} else if fileOff := cm.module.cutab[int(f.cuOffset)+int(val)]; fileOff != ^uint32(0) && int(fileOff) >= len(cm.module.filetab) {
+					report(check, pc, "file offset %d is outside filetab (%d bytes)", fileOff, len(cm.module.filetab))
+				}
+			}
+		}
+		if last := pcs[len(pcs)-1]; last < codeEnd {
+			report(check, last, "table ends before the end of the function's code at 0x%x", codeEnd)
+		}
+	}
+
+	check = "stackmap"
+	i := sort.Search(len(cm.callSites), func(i int) bool { return cm.callSites[i] >= entry })
+	if f.flag&funcFlag_ASM != 0 || i == len(cm.callSites) || cm.callSites[i] >= end {
+		// Assembly functions have no stack maps, and are only scanned conservatively if at all
+		return violations
+	}
+	var indexPCs []uintptr
+	var indexVals []int32
+	if off := pcdataOffset(f._func, dataindex.PCDATA_StackMapIndex); off != 0 {
+		indexPCs, indexVals = decodeTable(cm.module.pctab[off:], entry, end)
+	}
+	locals := (*stackmap)(funcdata(f, dataindex.FUNCDATA_LocalsPointerMaps))
+	args := (*stackmap)(funcdata(f, dataindex.FUNCDATA_ArgsPointerMaps))
+	for ; i < len(cm.callSites) && cm.callSites[i] < end; i++ {
+		pc := cm.callSites[i]
+		j := sort.Search(len(indexPCs), func(j int) bool { return indexPCs[j] > pc })
+		if j == len(indexPCs) || indexVals[j] < 0 {
+			report(check, pc, "no stack map index at call site")
+			continue
+		}
+		index := indexVals[j]
+		if locals == nil {
+			report(check, pc, "call site has stack map index %d, but there are no locals stack maps", index)
+		} else if index >= locals.n {
+			report(check, pc, "stack map index %d is beyond the %d locals stack maps", index, locals.n)
+		}
+		if args != nil && index >= args.n {
+			report(check, pc, "stack map index %d is beyond the %d args stack maps", index, args.n)
+		}
+	}
+	return violations
+}
+
+// decodeTable decodes the pc-value table p of a function starting at entry, returning the end PC and value of each
+// run. Decoding stops early if the table runs past end.
+func decodeTable(p []byte, entry, end uintptr) (pcs []uintptr, vals []int32) {
+	pc := entry
+	val := int32(-1)
+	for first := true; len(p) > 0; first = false {
+		var ok bool
+		if p, ok = step(p, &pc, &val, first); !ok {
+			break
+		}
+		pcs = append(pcs, pc)
+		vals = append(vals, val)
+		if pc > end {
+			break
+		}
+	}
+	return pcs, vals
+}
+
+// pcdataOffset returns the offset in pctab of f's table for the given PCDATA index, or 0 if it has none
+func pcdataOffset(f *_func, table int) uint32 {
+	if table >= int(f.npcdata) {
+		return 0
+	}
+	return *(*uint32)(adduintptr(uintptr(unsafe.Pointer(f)), _FuncSize+table*Uint32Size))
+}
+
+// verifyGCMask compares the pointer bitmap of the module's data or bss in [start, end) with the one expected from the
+// declared types of the symbols within it, reporting the first mismatched word of each symbol (or stray word outside
+// any symbol)
+func (cm *CodeModule) verifyGCMask(check string, start, end uintptr, mask bitvector) (violations []Violation) {
+	defer func() {
+		if v := recover(); v != nil {
+			violations = append(violations, Violation{Check: check, Addr: start, Detail: fmt.Sprintf("fault while reading pointer bitmaps: %v", v)})
+		}
+	}()
+	nwords := int((end - start) / PtrSize)
+	if int(mask.n) != nwords {
+		violations = append(violations, Violation{Check: check, Addr: start, Detail: fmt.Sprintf("bitmap has %d bits for %d words", mask.n, nwords)})
+		if int(mask.n) < nwords {
+			nwords = int(mask.n)
+		}
+	}
+	bit := func(bytes *uint8, i int) bool {
+		return (*(*uint8)(adduintptr(uintptr(unsafe.Pointer(bytes)), i/8))>>(i%8))&1 != 0
+	}
+
+	want := make([]bool, nwords)
+	for _, dt := range cm.dataTypes {
+		if dt.addr < start || dt.addr >= end {
+			continue
+		}
+		first := int((dt.addr - start) / PtrSize)
+		nptr := int(dt.typ.ptrdata / PtrSize)
+		typeMask := dt.typ.gcdata
+		if dt.typ.kind&KindGCProg != 0 {
+			typeMask = progToPointerMask((*byte)(adduintptr(uintptr(unsafe.Pointer(dt.typ.gcdata)), Uint32Size)), dt.typ.ptrdata).bytedata
+		}
+		for i := 0; i < nptr && first+i < nwords; i++ {
+			want[first+i] = bit(typeMask, i)
+		}
+	}
+
+	reported := uintptr(0)
+	for i := 0; i < nwords; i++ {
+		got := bit(mask.bytedata, i)
+		if got == want[i] {
+			continue
+		}
+		addr := start + uintptr(i)*PtrSize
+		symbol, ok := cm.symbolAt(addr)
+		if ok && symbol.Addr == reported {
+			continue
+		}
+		reported = symbol.Addr
+		detail := "pointer bit set in a word which holds no pointer"
+		if want[i] {
+			detail = "pointer bit missing for a word which holds a pointer"
+		}
+		if ok {
+			detail += fmt.Sprintf(" (offset %d)", addr-symbol.Addr)
+		}
+		violations = append(violations, Violation{Check: check, Symbol: symbol.Name, Addr: addr, Detail: detail})
+	}
+	return violations
+}